@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 24
+	minPasswordLength = 8
+	maxPasswordLength = 72 // bcrypt ignores anything longer
+	sessionLifetime   = 30 * 24 * time.Hour
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var (
+	errAccountsDisabled = errors.New("accounts need a database (DB_PATH)")
+	errUsernameTaken    = errors.New("username is taken")
+	errBadCredentials   = errors.New("wrong username or password")
+	errBadSession       = errors.New("invalid or expired session")
+)
+
+// dummyHash is compared against when a username doesn't exist, so a failed login takes as
+// long whether or not the account is real.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// User is a registered account.
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// SeatUsers names the accounts playing a game, refreshed on every broadcast. Anonymous seats are omitted.
+type SeatUsers struct {
+	P1 *User `json:"p1,omitempty"`
+	P2 *User `json:"p2,omitempty"`
+}
+
+// Session is the response to a successful registration or login.
+type Session struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+	User    User      `json:"user"`
+}
+
+// sessionClaims is the signed body of a session token.
+type sessionClaims struct {
+	UserID   int64  `json:"uid"`
+	Username string `json:"name"`
+	Expires  int64  `json:"exp"` // unix seconds
+}
+
+// newSessionSecret returns a random signing key. Sessions signed with it end when the process does.
+func newSessionSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("crypto/rand failed")
+	}
+	return secret
+}
+
+func validateCredentials(username string, password string) error {
+	if n := len(username); n < minUsernameLength || n > maxUsernameLength || !usernamePattern.MatchString(username) {
+		return fmt.Errorf("username must be %d-%d letters, digits, _ or -", minUsernameLength, maxUsernameLength)
+	}
+	if n := len(password); n < minPasswordLength || n > maxPasswordLength {
+		return fmt.Errorf("password must be %d-%d bytes", minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
+
+// registerUser creates an account. Usernames are unique ignoring case.
+func (s *Server) registerUser(username string, password string) (*User, error) {
+	if s.db == nil {
+		return nil, errAccountsDisabled
+	}
+	if err := validateCredentials(username, password); err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(`INSERT INTO users (username, password_hash) VALUES (?, ?) ON CONFLICT DO NOTHING`, username, string(hash))
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, errUsernameTaken
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Account registered: %s", username)
+	return &User{ID: id, Username: username}, nil
+}
+
+// authenticate checks a username and password, returning the account they belong to.
+func (s *Server) authenticate(username string, password string) (*User, error) {
+	if s.db == nil {
+		return nil, errAccountsDisabled
+	}
+	var user User
+	var hash string
+	err := s.db.QueryRow(`SELECT id, username, password_hash FROM users WHERE username = ?`, username).Scan(&user.ID, &user.Username, &hash)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errBadCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, errBadCredentials
+	}
+	return &user, nil
+}
+
+// issueSession signs a token for user: base64url(claims) "." base64url(HMAC-SHA256).
+func (s *Server) issueSession(user *User, now time.Time) Session {
+	expires := now.Add(sessionLifetime)
+	body, _ := json.Marshal(sessionClaims{UserID: user.ID, Username: user.Username, Expires: expires.Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(body)
+	return Session{
+		Token:   payload + "." + base64.RawURLEncoding.EncodeToString(s.signSession(payload)),
+		Expires: expires.UTC().Truncate(time.Second),
+		User:    *user,
+	}
+}
+
+func (s *Server) signSession(payload string) []byte {
+	mac := hmac.New(sha256.New, s.sessionSecret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verifySession returns the user a session token was issued to.
+func (s *Server) verifySession(token string, now time.Time) (*User, error) {
+	payload, sig, found := strings.Cut(token, ".")
+	if !found {
+		return nil, errBadSession
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, s.signSession(payload)) {
+		return nil, errBadSession
+	}
+	body, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errBadSession
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(body, &claims); err != nil || now.Unix() >= claims.Expires {
+		return nil, errBadSession
+	}
+	return &User{ID: claims.UserID, Username: claims.Username}, nil
+}
+
+// bindUser attributes seat to user for the rest of the game. The first user bound to a seat
+// keeps it, so rejoining the seat's token from another account doesn't change who played.
+func (game *Game) bindUser(seat string, user *User) {
+	if user == nil {
+		return
+	}
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if _, bound := game.users[seat]; !bound {
+		game.users[seat] = user
+	}
+}
+
+// seatUsers is the accounts in the game for a broadcast, or nil if both seats are anonymous.
+func (game *Game) seatUsers() *SeatUsers {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if len(game.users) == 0 {
+		return nil
+	}
+	return &SeatUsers{P1: game.users["player1"], P2: game.users["player2"]}
+}
+
+// --- HTTP ---
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// preflight answers CORS preflight requests and rejects methods other than want.
+func preflight(w http.ResponseWriter, r *http.Request, want string) bool {
+	enableCors(&w)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	if r.Method != want {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// handleRegister creates an account from {username, password} and logs it in.
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, http.MethodPost) {
+		return
+	}
+	var creds credentials
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&creds); err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+	if err := validateCredentials(creds.Username, creds.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := s.registerUser(creds.Username, creds.Password)
+	switch {
+	case errors.Is(err, errAccountsDisabled):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, errUsernameTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		log.Printf("Registration failed: %v", err)
+		http.Error(w, "registration failed", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusCreated, s.issueSession(user, time.Now()))
+	}
+}
+
+// handleLogin exchanges {username, password} for a session token.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, http.MethodPost) {
+		return
+	}
+	var creds credentials
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&creds); err != nil {
+		http.Error(w, "malformed request", http.StatusBadRequest)
+		return
+	}
+	user, err := s.authenticate(creds.Username, creds.Password)
+	switch {
+	case errors.Is(err, errAccountsDisabled):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, errBadCredentials):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case err != nil:
+		log.Printf("Login failed: %v", err)
+		http.Error(w, "login failed", http.StatusInternalServerError)
+	default:
+		writeJSON(w, http.StatusOK, s.issueSession(user, time.Now()))
+	}
+}
+
+// handleMe returns the user behind an "Authorization: Bearer <token>" header.
+func (s *Server) handleMe(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, http.MethodGet) {
+		return
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		http.Error(w, errBadSession.Error(), http.StatusUnauthorized)
+		return
+	}
+	user, err := s.verifySession(token, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// --- Helpers ---
+
+// postCredentials calls handler with a JSON {username, password} body.
+func postCredentials(handler http.HandlerFunc, username, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(credentials{Username: username, Password: password})
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("POST", "/account", strings.NewReader(string(body))))
+	return rec
+}
+
+func registerSession(t *testing.T, s *Server, username string) Session {
+	t.Helper()
+	rec := postCredentials(s.handleRegister, username, "correct horse")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register %s returned %d: %s", username, rec.Code, rec.Body.String())
+	}
+	var session Session
+	if err := json.Unmarshal(rec.Body.Bytes(), &session); err != nil {
+		t.Fatalf("unreadable session: %v", err)
+	}
+	return session
+}
+
+// --- Registration and login ---
+
+func TestAccounts_RegisterLoginMe(t *testing.T) {
+	s := newTestServerWithDB(t)
+	session := registerSession(t, s, "Mochi")
+	if session.Token == "" || session.User.Username != "Mochi" || session.User.ID == 0 {
+		t.Fatalf("expected a session for Mochi, got %+v", session)
+	}
+
+	if rec := postCredentials(s.handleRegister, "mochi", "another password"); rec.Code != http.StatusConflict {
+		t.Errorf("expected usernames to be unique ignoring case, got %d", rec.Code)
+	}
+	if rec := postCredentials(s.handleRegister, "a b", "correct horse"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad username to be rejected, got %d", rec.Code)
+	}
+	if rec := postCredentials(s.handleRegister, "Biscuit", "short"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a short password to be rejected, got %d", rec.Code)
+	}
+	// Both bounds count bytes, as bcrypt does
+	if err := validateCredentials("Biscuit", "éééé"); err != nil {
+		t.Errorf("expected an 8-byte password of 4 letters to be accepted, got %v", err)
+	}
+	if err := validateCredentials("Biscuit", strings.Repeat("é", 37)); err == nil {
+		t.Error("expected a 74-byte password to be rejected")
+	}
+	if err := validateCredentials("Biscuit", "ééé"); err == nil {
+		t.Error("expected a 6-byte password to be rejected")
+	}
+
+	if rec := postCredentials(s.handleLogin, "Mochi", "wrong password"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a wrong password to be refused, got %d", rec.Code)
+	}
+	if rec := postCredentials(s.handleLogin, "Nobody", "correct horse"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected an unknown user to be refused, got %d", rec.Code)
+	}
+	rec := postCredentials(s.handleLogin, "MOCHI", "correct horse")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var login Session
+	json.Unmarshal(rec.Body.Bytes(), &login)
+	if login.User != session.User {
+		t.Errorf("expected login to return the registered account, got %+v", login.User)
+	}
+
+	req := httptest.NewRequest("GET", "/account/me", nil)
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	rec = httptest.NewRecorder()
+	s.handleMe(rec, req)
+	var me User
+	if json.Unmarshal(rec.Body.Bytes(), &me); rec.Code != http.StatusOK || me != session.User {
+		t.Errorf("expected /account/me to return Mochi, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	s.handleMe(rec, httptest.NewRequest("GET", "/account/me", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected /account/me without a token to be refused, got %d", rec.Code)
+	}
+}
+
+func TestAccounts_NeedDatabase(t *testing.T) {
+	s := NewServer()
+	if rec := postCredentials(s.handleRegister, "Mochi", "correct horse"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected registration without a database to be unavailable, got %d", rec.Code)
+	}
+	if rec := postCredentials(s.handleLogin, "Mochi", "correct horse"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected login without a database to be unavailable, got %d", rec.Code)
+	}
+}
+
+// --- Sessions ---
+
+func TestVerifySession(t *testing.T) {
+	s := NewServer()
+	now := time.Now()
+	user := &User{ID: 7, Username: "Mochi"}
+	token := s.issueSession(user, now).Token
+
+	got, err := s.verifySession(token, now.Add(time.Hour))
+	if err != nil || *got != *user {
+		t.Errorf("expected the token to identify Mochi, got %+v (%v)", got, err)
+	}
+	if _, err := s.verifySession(token, now.Add(sessionLifetime)); err == nil {
+		t.Error("expected an expired token to be refused")
+	}
+
+	// Claiming another user invalidates the signature
+	_, sig, _ := strings.Cut(token, ".")
+	claims, _ := json.Marshal(sessionClaims{UserID: 1, Username: "Admin", Expires: now.Add(time.Hour).Unix()})
+	forged := base64.RawURLEncoding.EncodeToString(claims) + "." + sig
+	if _, err := s.verifySession(forged, now); err == nil {
+		t.Error("expected a modified token to be refused")
+	}
+	other := NewServer()
+	if _, err := other.verifySession(token, now); err == nil {
+		t.Error("expected a token signed with another secret to be refused")
+	}
+	for _, bad := range []string{"", "no-dot", "a.b"} {
+		if _, err := s.verifySession(bad, now); err == nil {
+			t.Errorf("expected %q to be refused", bad)
+		}
+	}
+}
+
+// --- Seats ---
+
+func TestAccounts_SessionBindsSeat(t *testing.T) {
+	s := newTestServerWithDB(t)
+	mochi := registerSession(t, s, "Mochi")
+	biscuit := registerSession(t, s, "Biscuit")
+
+	creator := dialServer(t, s, "name=Ignored&session="+url.QueryEscape(mochi.Token))
+	joined := readUntil(t, creator, "joined")
+	gameID := joined["gameID"].(string)
+	if games := lobbyListing(t, s, ""); len(games) != 1 || games[0].Creator != "Mochi" {
+		t.Errorf("expected the lobby to show the account name, got %+v", games)
+	}
+
+	opponent := dialServer(t, s, "gameID="+gameID+"&session="+url.QueryEscape(biscuit.Token))
+	readUntil(t, opponent, "joined")
+	state := readUntil(t, creator, "gameState")["payload"].(map[string]interface{})
+	users, _ := state["users"].(map[string]interface{})
+	p1, _ := users["p1"].(map[string]interface{})
+	p2, _ := users["p2"].(map[string]interface{})
+	if p1["username"] != "Mochi" || p2["username"] != "Biscuit" {
+		t.Errorf("expected the broadcast to name both accounts, got %+v", state["users"])
+	}
+
+	// Each account is written as its seat is taken, before the game starts
+	loaded, err := s.loadGameUsers(gameID)
+	if err != nil || loaded["player1"] == nil || loaded["player2"] == nil || *loaded["player1"] != mochi.User || *loaded["player2"] != biscuit.User {
+		t.Errorf("expected seat accounts to be persisted, got %+v (%v)", loaded, err)
+	}
+}
+
+func TestAccounts_BadSessionRejected(t *testing.T) {
+	s := NewServer()
+	client := dialServer(t, s, "session=forged.token")
+	if msg := readUntil(t, client, "error"); msg["code"] != CodeBadSession {
+		t.Errorf("expected bad_session for a forged token, got %+v", msg)
+	}
+	s.serverMutex.Lock()
+	defer s.serverMutex.Unlock()
+	if len(s.games) != 0 {
+		t.Error("expected no game to be created")
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	archiveListLimit = 20
+	maxArchiveList   = 100
+)
+
+// ArchivedAction is one board action as it was played: a placement, a line selection or a
+// single-piece graduation, with what it caused.
+type ArchivedAction struct {
+	Seq    int       `json:"seq"` // from 0, in the order played
+	Seat   string    `json:"seat"`
+	Action Action    `json:"action"`
+	Events []Event   `json:"events"`
+	At     time.Time `json:"at"`
+}
+
+// ArchivedGame summarises a finished game in /archive/games.
+type ArchivedGame struct {
+	ID          string       `json:"id"`
+	Players     SeatUsers    `json:"players"` // accounts; anonymous seats and the bot are omitted
+	BotSeat     string       `json:"botSeat,omitempty"`
+	BotLevel    string       `json:"botLevel,omitempty"`
+	Winner      uint8        `json:"winner"` // 0 for a draw
+	Result      string       `json:"result"`
+	Rated       bool         `json:"rated"`
+	TimeControl *TimeControl `json:"timeControl,omitempty"`
+	FirstMover  uint8        `json:"firstMover"`
+	Position    string       `json:"position,omitempty"` // starting position, if not the empty board
+	Actions     int          `json:"actions"`            // number of actions played
+	StartedAt   time.Time    `json:"startedAt"`
+	FinishedAt  time.Time    `json:"finishedAt"`
+}
+
+// ArchivedGameDetail is a finished game in full, from /archive/game.
+type ArchivedGameDetail struct {
+	ArchivedGame
+	Moves      []ArchivedAction `json:"moves"`
+	FinalState json.RawMessage  `json:"finalState"`
+}
+
+// recordAction appends an applied action to the game's move list. seat is the player who
+// took it. Caller must hold game.mutex.
+func (game *Game) recordAction(seat string, action Action, events []Event) {
+	if events == nil {
+		events = []Event{}
+	}
+	game.actions = append(game.actions, ArchivedAction{
+		Seq:    len(game.actions),
+		Seat:   seat,
+		Action: action,
+		Events: events,
+		At:     time.Now().UTC(),
+	})
+}
+
+// dropActions forgets the last n actions after a takeback. Caller must hold game.mutex.
+func (game *Game) dropActions(n int) {
+	game.actions = game.actions[:max(0, len(game.actions)-n)]
+	game.actionsSaved = min(game.actionsSaved, len(game.actions))
+}
+
+// saveActions writes the actions played since the last save and removes any that were
+// taken back. Runs from saveGame.
+func (s *Server) saveActions(game *Game) {
+	game.mutex.Lock()
+	total := len(game.actions)
+	unsaved := append([]ArchivedAction(nil), game.actions[game.actionsSaved:]...)
+	game.mutex.Unlock()
+
+	if _, err := s.db.Exec(`DELETE FROM game_actions WHERE game_id = ? AND seq >= ?`, game.ID, total-len(unsaved)); err != nil {
+		log.Printf("saveActions: failed to clear taken-back actions for game %s: %v", game.ID, err)
+		return
+	}
+	for _, a := range unsaved {
+		action, _ := json.Marshal(a.Action)
+		events, _ := json.Marshal(a.Events)
+		_, err := s.db.Exec(`INSERT OR REPLACE INTO game_actions (game_id, seq, seat, action, events, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			game.ID, a.Seq, a.Seat, string(action), string(events), a.At)
+		if err != nil {
+			log.Printf("saveActions: failed to save action %d of game %s: %v", a.Seq, game.ID, err)
+			return
+		}
+	}
+
+	game.mutex.Lock()
+	// Only advance if nothing was taken back meanwhile
+	if len(game.actions) >= total {
+		game.actionsSaved = max(game.actionsSaved, total)
+	}
+	game.mutex.Unlock()
+}
+
+// loadActions returns a game's move list, oldest first.
+func (s *Server) loadActions(gameID string) ([]ArchivedAction, error) {
+	rows, err := s.db.Query(`SELECT seq, seat, action, events, created_at FROM game_actions WHERE game_id = ? ORDER BY seq`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []ArchivedAction{}
+	for rows.Next() {
+		var a ArchivedAction
+		var action, events string
+		if err := rows.Scan(&a.Seq, &a.Seat, &action, &events, &a.At); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(action), &a.Action); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(events), &a.Events); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
+
+// archiveGame records a finished game's players, result and final state. The move list is
+// already in game_actions. A game is archived once; later saves leave the row alone.
+func (s *Server) archiveGame(game *Game) {
+	game.mutex.Lock()
+	gs := game.GameState
+	if !gs.isOver() {
+		game.mutex.Unlock()
+		return
+	}
+	finalState, err := json.Marshal(gs)
+	var p1, p2 interface{}
+	if user := game.users["player1"]; user != nil {
+		p1 = user.ID
+	}
+	if user := game.users["player2"]; user != nil {
+		p2 = user.ID
+	}
+	var botSeat, botLevel interface{}
+	if game.bot != nil {
+		botSeat, botLevel = game.bot.Seat, game.bot.Level
+	}
+	var timeControl interface{}
+	if game.options.TimeControl != nil {
+		data, _ := json.Marshal(game.options.TimeControl)
+		timeControl = string(data)
+	}
+	startedAt := game.createdAt
+	if len(game.actions) > 0 {
+		startedAt = game.actions[0].At
+	}
+	winner, result, rated, firstMover, actions := gs.Winner, gs.Result, game.options.Rated, gs.firstMover(), len(game.actions)
+	game.mutex.Unlock()
+	if err != nil {
+		log.Printf("archiveGame: failed to marshal game %s: %v", game.ID, err)
+		return
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO finished_games (id, p1_user_id, p2_user_id, bot_seat, bot_level, winner, result, rated,
+			time_control, first_mover, actions, started_at, finished_at, final_state)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO NOTHING
+	`, game.ID, p1, p2, botSeat, botLevel, winner, result, rated, timeControl, firstMover, actions,
+		startedAt.UTC(), time.Now().UTC(), string(finalState))
+	if err != nil {
+		log.Printf("archiveGame: failed to archive game %s: %v", game.ID, err)
+	}
+}
+
+const archivedGameColumns = `
+	f.id, u1.id, u1.username, u2.id, u2.username, f.bot_seat, f.bot_level, f.winner, f.result,
+	f.rated, f.time_control, f.first_mover, p.position, f.actions, f.started_at, f.finished_at`
+
+const archivedGameJoins = `
+	FROM finished_games f
+	LEFT JOIN users u1 ON u1.id = f.p1_user_id
+	LEFT JOIN users u2 ON u2.id = f.p2_user_id
+	LEFT JOIN game_positions p ON p.game_id = f.id`
+
+// scanArchivedGame reads a row selected with archivedGameColumns.
+func scanArchivedGame(row interface{ Scan(...interface{}) error }, extra ...interface{}) (ArchivedGame, error) {
+	var g ArchivedGame
+	var p1ID, p2ID sql.NullInt64
+	var p1Name, p2Name, botSeat, botLevel, timeControl, position sql.NullString
+	dest := []interface{}{&g.ID, &p1ID, &p1Name, &p2ID, &p2Name, &botSeat, &botLevel, &g.Winner, &g.Result,
+		&g.Rated, &timeControl, &g.FirstMover, &position, &g.Actions, &g.StartedAt, &g.FinishedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return g, err
+	}
+	if p1ID.Valid {
+		g.Players.P1 = &User{ID: p1ID.Int64, Username: p1Name.String}
+	}
+	if p2ID.Valid {
+		g.Players.P2 = &User{ID: p2ID.Int64, Username: p2Name.String}
+	}
+	g.BotSeat, g.BotLevel, g.Position = botSeat.String, botLevel.String, position.String
+	if timeControl.Valid {
+		g.TimeControl = &TimeControl{}
+		if err := json.Unmarshal([]byte(timeControl.String), g.TimeControl); err != nil {
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+// --- HTTP ---
+
+// handleArchivedGames lists ?user='s finished games, newest first: {"games": [...]}.
+// ?limit= (default 20, max 100) and ?offset= page through them.
+func (s *Server) handleArchivedGames(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	if s.db == nil {
+		http.Error(w, "archive needs a database (DB_PATH)", http.StatusServiceUnavailable)
+		return
+	}
+	limit, err := parseLimit(r.URL.Query().Get("limit"), archiveListLimit, maxArchiveList)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset := 0
+	if value := r.URL.Query().Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			http.Error(w, "offset must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+	}
+	var userID int64
+	err = s.db.QueryRow(`SELECT id FROM users WHERE username = ?`, r.URL.Query().Get("user")).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "no such user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("handleArchivedGames: %v", err)
+		http.Error(w, "archive unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := s.db.Query(`SELECT `+archivedGameColumns+archivedGameJoins+`
+		WHERE f.p1_user_id = ? OR f.p2_user_id = ?
+		ORDER BY f.finished_at DESC, f.id LIMIT ? OFFSET ?`, userID, userID, limit, offset)
+	if err != nil {
+		log.Printf("handleArchivedGames: %v", err)
+		http.Error(w, "archive unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	games := []ArchivedGame{}
+	for rows.Next() {
+		g, err := scanArchivedGame(rows)
+		if err != nil {
+			log.Printf("handleArchivedGames: %v", err)
+			http.Error(w, "archive unavailable", http.StatusInternalServerError)
+			return
+		}
+		games = append(games, g)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Games []ArchivedGame `json:"games"`
+	}{games})
+}
+
+// handleArchivedGame returns one finished game with its full move list and final state.
+func (s *Server) handleArchivedGame(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	if s.db == nil {
+		http.Error(w, "archive needs a database (DB_PATH)", http.StatusServiceUnavailable)
+		return
+	}
+	gameID := r.URL.Query().Get("id")
+	var detail ArchivedGameDetail
+	var finalState string
+	row := s.db.QueryRow(`SELECT `+archivedGameColumns+`, f.final_state`+archivedGameJoins+` WHERE f.id = ?`, gameID)
+	game, err := scanArchivedGame(row, &finalState)
+	if err == sql.ErrNoRows {
+		http.Error(w, "no such finished game", http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		detail.ArchivedGame = game
+		detail.FinalState = json.RawMessage(finalState)
+		detail.Moves, err = s.loadActions(gameID)
+	}
+	if err != nil {
+		log.Printf("handleArchivedGame: %v", err)
+		http.Error(w, "archive unavailable", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// --- Helpers ---
+
+func countActions(t *testing.T, s *Server, gameID string) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM game_actions WHERE game_id = ?`, gameID).Scan(&n); err != nil {
+		t.Fatalf("failed to count actions: %v", err)
+	}
+	return n
+}
+
+// --- Move list ---
+
+func TestArchive_RecordsEveryAction(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newSeatedGame(s)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	s.saveGame(game)
+	playAction(t, game, placeAction(P2Kitten, 1, 1))
+	s.saveGame(game)
+
+	moves, err := s.loadActions(game.ID)
+	if err != nil || len(moves) != 2 {
+		t.Fatalf("expected two saved actions, got %+v (%v)", moves, err)
+	}
+	second := moves[1]
+	if second.Seq != 1 || second.Seat != "player2" || second.Action != placeAction(P2Kitten, 1, 1) || second.At.IsZero() {
+		t.Errorf("expected player2's placement second, got %+v", second)
+	}
+	// The kitten at 1,1 boops player1's kitten off the corner
+	if len(second.Events) < 2 || second.Events[0].Type != EventPlaced || second.Events[1].Type != EventBoopedOff {
+		t.Errorf("expected the placement and boop in the events, got %+v", second.Events)
+	}
+}
+
+func TestArchive_TakebackDropsActions(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newSeatedGame(s)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	playAction(t, game, placeAction(P2Kitten, 5, 5))
+	s.saveGame(game)
+	if n := countActions(t, s, game.ID); n != 2 {
+		t.Fatalf("expected two saved actions, got %d", n)
+	}
+
+	game.requestTakeback(s, "player1")
+	if err := game.answerTakeback(s, "player2", true); err != nil {
+		t.Fatalf("unexpected takeback error: %v", err)
+	}
+	if n := countActions(t, s, game.ID); n != 0 || len(game.actions) != 0 {
+		t.Errorf("expected the taken-back actions to be removed, got %d saved and %d in memory", n, len(game.actions))
+	}
+
+	playAction(t, game, placeAction(P1Kitten, 2, 2))
+	s.saveGame(game)
+	moves, _ := s.loadActions(game.ID)
+	if len(moves) != 1 || moves[0].Seq != 0 || moves[0].Action.Position != (Position{X: 2, Y: 2}) {
+		t.Errorf("expected the replayed move to take seq 0, got %+v", moves)
+	}
+}
+
+func TestArchive_RestoredGameContinuesMoveList(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newSeatedGame(s)
+	s.saveNewGame(game)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	s.saveGame(game)
+
+	restarted := NewServer()
+	restarted.db = s.db
+	if err := restarted.restoreGames(); err != nil {
+		t.Fatalf("unexpected restore error: %v", err)
+	}
+	restored := restarted.games[game.ID]
+	if restored == nil || len(restored.actions) != 1 {
+		t.Fatal("expected the restored game to keep its move list")
+	}
+	defer restored.shutdown()
+	playAction(t, restored, placeAction(P2Kitten, 5, 5))
+	restarted.saveGame(restored)
+	if moves, _ := restarted.loadActions(game.ID); len(moves) != 2 || moves[1].Seq != 1 {
+		t.Errorf("expected the next action to follow on, got %+v", moves)
+	}
+}
+
+// --- Finished games ---
+
+func TestArchive_FinishedGameOutlivesDelete(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newRatedGame(t, s, "Mochi", "Biscuit")
+	game.options.Rated = false
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	game.resign(s, "player2")
+
+	s.serverMutex.Lock()
+	game.Players = nil
+	s.evictGame(game)
+	s.serverMutex.Unlock()
+
+	var list struct {
+		Games []ArchivedGame `json:"games"`
+	}
+	if code := getJSON(t, s.handleArchivedGames, "/archive/games?user=biscuit", &list); code != http.StatusOK {
+		t.Fatalf("archive list returned %d", code)
+	}
+	if len(list.Games) != 1 {
+		t.Fatalf("expected Biscuit to have one finished game, got %+v", list.Games)
+	}
+	summary := list.Games[0]
+	if summary.ID != game.ID || summary.Winner != 1 || summary.Result != ResultResignation || summary.Actions != 1 ||
+		summary.Players.P1 == nil || summary.Players.P1.Username != "Mochi" || summary.Players.P2.Username != "Biscuit" ||
+		summary.FirstMover != 1 || summary.Rated || summary.FinishedAt.Before(summary.StartedAt) {
+		t.Errorf("expected Mochi's win by resignation, got %+v", summary)
+	}
+
+	var detail ArchivedGameDetail
+	if code := getJSON(t, s.handleArchivedGame, "/archive/game?id="+game.ID, &detail); code != http.StatusOK {
+		t.Fatalf("archived game returned %d", code)
+	}
+	if len(detail.Moves) != 1 || detail.Moves[0].Seat != "player1" || len(detail.FinalState) == 0 {
+		t.Errorf("expected the full move list and final state, got %+v", detail)
+	}
+	if code := getJSON(t, s.handleArchivedGame, "/archive/game?id=NOPE", &detail); code != http.StatusNotFound {
+		t.Errorf("expected an unknown game to be not found, got %d", code)
+	}
+	if code := getJSON(t, s.handleArchivedGames, "/archive/games?user=Nobody", &list); code != http.StatusNotFound {
+		t.Errorf("expected an unknown user to be not found, got %d", code)
+	}
+}
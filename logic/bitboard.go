@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// BitState is a GameState for search and bulk simulation. Each kind of piece is a 64-bit
+// mask with bit y*8+x set for every square it holds, and boops, lines and wins are found
+// with shifts against masks precomputed per board size rather than by walking the Board.
+// It plays exactly as Apply and LegalMoves do (bitboard_test.go checks them against each
+// other) but carries nothing else: no animation fields, events or server data. Convert
+// with NewBitState and GameState at the edges.
+type BitState struct {
+	Pieces     [4]uint64 // indexed by bitP1Kitten, bitP1Cat, bitP2Kitten, bitP2Cat
+	P1         Player
+	P2         Player
+	TurnNumber uint8
+	FirstMover uint8
+	State      string
+	Winner     uint8
+	Result     string
+	Choices    uint64   // middles of the lines to pick from in MULTIPLE_WAITING
+	Rules      *Ruleset // nil for StandardRules, shared with the GameState it came from
+}
+
+// Indexes into BitState.Pieces
+const (
+	bitP1Kitten = iota
+	bitP1Cat
+	bitP2Kitten
+	bitP2Cat
+)
+
+var bitTiles = [4]uint8{P1Kitten, P1Cat, P2Kitten, P2Cat}
+
+func bitIndex(tile uint8) int {
+	switch tile {
+	case P1Kitten:
+		return bitP1Kitten
+	case P1Cat:
+		return bitP1Cat
+	case P2Kitten:
+		return bitP2Kitten
+	case P2Cat:
+		return bitP2Cat
+	}
+	return -1
+}
+
+// The four line directions in the order isMiddleOfThreeInARow tries them (across, down,
+// top-left to bottom-right, top-right to bottom-left), as the bit distance between
+// neighbouring squares of a line. They are also the directions winCheckRun scans.
+var lineSteps = [4]int{1, 8, 9, 7}
+
+// bitTables are the precomputed masks for one board size.
+type bitTables struct {
+	onBoard uint64
+	// Squares that can be the middle of a line in each of lineSteps' directions
+	middles [4]uint64
+	// Squares a run of n cats can start from in each of lineSteps' directions, by n
+	runStarts [4][maxBoardSize + 1]uint64
+	// The squares around each square, and the squares pieces on them are booped to
+	neighbours [64]uint64
+	landings   [64]uint64
+	// The bot's evaluation: the squares along the edge, and the central ones off it
+	edges  uint64
+	centre uint64
+}
+
+var bitTablesBySize = buildBitTables()
+
+func buildBitTables() [maxBoardSize + 1]*bitTables {
+	var all [maxBoardSize + 1]*bitTables
+	for size := minBoardSize; size <= maxBoardSize; size++ {
+		t := &bitTables{}
+		onBoard := func(x, y int) bool {
+			return x >= 0 && y >= 0 && x < size && y < size
+		}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				bit := uint64(1) << (y*8 + x)
+				t.onBoard |= bit
+				lo, hi := (size-1)/2, size/2
+				switch {
+				case x == 0 || y == 0 || x == size-1 || y == size-1:
+					t.edges |= bit
+				case x >= lo && x <= hi && y >= lo && y <= hi:
+					t.centre |= bit
+				}
+				interiorX, interiorY := x > 0 && x < size-1, y > 0 && y < size-1
+				if interiorX {
+					t.middles[0] |= bit
+				}
+				if interiorY {
+					t.middles[1] |= bit
+				}
+				if interiorX && interiorY {
+					t.middles[2] |= bit
+					t.middles[3] |= bit
+				}
+				for n := 1; n <= size; n++ {
+					for i, d := range []Direction{{1, 0}, {0, 1}, {1, 1}, {-1, 1}} {
+						if onBoard(x+(n-1)*int(d.X), y+(n-1)*int(d.Y)) {
+							t.runStarts[i][n] |= bit
+						}
+					}
+				}
+				for _, d := range directions {
+					if nx, ny := x+int(d.X), y+int(d.Y); onBoard(nx, ny) {
+						t.neighbours[y*8+x] |= 1 << (ny*8 + nx)
+					}
+					if lx, ly := x+2*int(d.X), y+2*int(d.Y); onBoard(lx, ly) {
+						t.landings[y*8+x] |= 1 << (ly*8 + lx)
+					}
+				}
+			}
+		}
+		all[size] = t
+	}
+	return all
+}
+
+// NewBitState converts gs.
+func NewBitState(gs *GameState) BitState {
+	b := BitState{
+		P1:         gs.P1,
+		P2:         gs.P2,
+		TurnNumber: gs.TurnNumber,
+		FirstMover: gs.FirstMover,
+		State:      gs.State,
+		Winner:     gs.Winner,
+		Result:     gs.Result,
+		Rules:      gs.Rules,
+	}
+	size := gs.boardSize()
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if k := bitIndex(gs.Board[y][x]); k >= 0 {
+				b.Pieces[k] |= 1 << (y*8 + x)
+			}
+		}
+	}
+	if gs.State == "MULTIPLE_WAITING" {
+		for _, pos := range gs.ThreeChoices {
+			b.Choices |= 1 << (int(pos.Y)*8 + int(pos.X))
+		}
+	}
+	return b
+}
+
+// GameState converts b back, with the lines to choose from filled in for MULTIPLE_WAITING.
+func (b *BitState) GameState() *GameState {
+	gs := NewGameState()
+	for k, mask := range b.Pieces {
+		for ; mask != 0; mask &= mask - 1 {
+			p := bits.TrailingZeros64(mask)
+			gs.Board[p/8][p%8] = bitTiles[k]
+		}
+	}
+	gs.PreviousBoard = gs.Board
+	gs.P1, gs.P2 = b.P1, b.P2
+	gs.TurnNumber, gs.FirstMover = b.TurnNumber, b.FirstMover
+	gs.State, gs.Winner, gs.Result = b.State, b.Winner, b.Result
+	gs.Rules = b.Rules
+	if b.State == "MULTIPLE_WAITING" {
+		_, byDir := b.lines(b.tables(), b.mover())
+		for mask := b.Choices; mask != 0; mask &= mask - 1 {
+			p := bits.TrailingZeros64(mask)
+			for d, step := range lineSteps {
+				if byDir[d]&(1<<p) != 0 {
+					gs.Lines = append(gs.Lines, []Position{bitPosition(p - step), bitPosition(p), bitPosition(p + step)})
+					gs.ThreeChoices = append(gs.ThreeChoices, bitPosition(p))
+				}
+			}
+		}
+	}
+	return gs
+}
+
+func bitPosition(p int) Position {
+	return Position{X: uint8(p % 8), Y: uint8(p / 8)}
+}
+
+func (b *BitState) rules() Ruleset {
+	if b.Rules == nil {
+		return StandardRules
+	}
+	return *b.Rules
+}
+
+func (b *BitState) tables() *bitTables {
+	return bitTablesBySize[b.rules().BoardSize]
+}
+
+func (b *BitState) isOver() bool {
+	return b.State == "GAME_OVER" || b.Winner != 0
+}
+
+// mover is 1 or 2 for the player who acts next, as moverNumber.
+func (b *BitState) mover() uint8 {
+	firstIsP1 := b.FirstMover != 2
+	if (b.TurnNumber%2 == 0) == firstIsP1 {
+		return 1
+	}
+	return 2
+}
+
+func (b *BitState) hand(player uint8) *Player {
+	if player == 1 {
+		return &b.P1
+	}
+	return &b.P2
+}
+
+func (b *BitState) occupied() uint64 {
+	return b.Pieces[0] | b.Pieces[1] | b.Pieces[2] | b.Pieces[3]
+}
+
+// owned is player's kittens and cats.
+func (b *BitState) owned(player uint8) (kittens, cats uint64) {
+	if player == 1 {
+		return b.Pieces[bitP1Kitten], b.Pieces[bitP1Cat]
+	}
+	return b.Pieces[bitP2Kitten], b.Pieces[bitP2Cat]
+}
+
+func (b *BitState) pieceAt(p int) int {
+	for k, mask := range b.Pieces {
+		if mask&(1<<p) != 0 {
+			return k
+		}
+	}
+	return -1
+}
+
+// LegalMoves lists the same actions, in the same order, as LegalMoves does for b's GameState.
+func (b *BitState) LegalMoves() []Action {
+	return b.AppendLegalMoves(nil)
+}
+
+// AppendLegalMoves is LegalMoves appending to dst, so a search can reuse one buffer.
+func (b *BitState) AppendLegalMoves(dst []Action) []Action {
+	if b.isOver() {
+		return dst
+	}
+	mover := b.mover()
+	kittens, cats := b.owned(mover)
+	var mask uint64
+	switch b.State {
+	case "WAITING":
+		hand := b.hand(mover)
+		kitten, cat := P1Kitten, P1Cat
+		if mover == 2 {
+			kitten, cat = P2Kitten, P2Cat
+		}
+		for mask = b.tables().onBoard &^ b.occupied(); mask != 0; mask &= mask - 1 {
+			position := bitPosition(bits.TrailingZeros64(mask))
+			if hand.Kittens > 0 {
+				dst = append(dst, Action{Type: ActionPlace, Position: position, Piece: kitten})
+			}
+			if hand.Cats > 0 {
+				dst = append(dst, Action{Type: ActionPlace, Position: position, Piece: cat})
+			}
+		}
+	case "MULTIPLE_WAITING":
+		for mask = b.Choices; mask != 0; mask &= mask - 1 {
+			dst = append(dst, Action{Type: ActionGraduateLine, Position: bitPosition(bits.TrailingZeros64(mask))})
+		}
+	case "MAX_WAITING":
+		for mask = kittens | cats; mask != 0; mask &= mask - 1 {
+			dst = append(dst, Action{Type: ActionGraduatePiece, Position: bitPosition(bits.TrailingZeros64(mask))})
+		}
+	}
+	return dst
+}
+
+// checkLegal reports whether action is one of b.LegalMoves(), without listing them.
+func (b *BitState) checkLegal(action Action) error {
+	legal := false
+	if !b.isOver() && action.Position.X < 8 && action.Position.Y < 8 {
+		bit := uint64(1) << (int(action.Position.Y)*8 + int(action.Position.X))
+		mover := b.mover()
+		kittens, cats := b.owned(mover)
+		switch action.Type {
+		case ActionPlace:
+			hand := b.hand(mover)
+			k := bitIndex(action.Piece)
+			mine := k >= 0 && (k < bitP2Kitten) == (mover == 1)
+			inHand := isKitten(action.Piece) && hand.Kittens > 0 || isCat(action.Piece) && hand.Cats > 0
+			legal = b.State == "WAITING" && mine && inHand && bit&b.tables().onBoard&^b.occupied() != 0
+		case ActionGraduateLine:
+			legal = b.State == "MULTIPLE_WAITING" && action.Piece == 0 && bit&b.Choices != 0
+		case ActionGraduatePiece:
+			legal = b.State == "MAX_WAITING" && action.Piece == 0 && bit&(kittens|cats) != 0
+		}
+	}
+	if !legal {
+		return fmt.Errorf("illegal move: %s at (%d, %d) is not available in state %s",
+			action.Type, action.Position.X, action.Position.Y, b.State)
+	}
+	return nil
+}
+
+// Apply is Apply for a BitState: the state after the player to move takes action, or an
+// error if action is not legal. b is not modified.
+func (b BitState) Apply(action Action) (BitState, error) {
+	if err := b.checkLegal(action); err != nil {
+		return b, err
+	}
+	next := b
+	mover := next.mover()
+	hand := next.hand(mover)
+	t := next.tables()
+	p := int(action.Position.Y)*8 + int(action.Position.X)
+
+	switch action.Type {
+	case ActionPlace:
+		next.place(t, p, action.Piece, mover)
+	case ActionGraduateLine:
+		_, byDir := next.lines(t, mover)
+		for d, step := range lineSteps {
+			if byDir[d]&(1<<p) != 0 {
+				next.graduate(mover, 1<<(p-step)|1<<p|1<<(p+step))
+			}
+		}
+		next.State = "WAITING"
+		next.Choices = 0
+	case ActionGraduatePiece:
+		next.remove(1 << p)
+		hand.Cats++
+		hand.Placed--
+		next.State = "WAITING"
+	}
+
+	if next.Winner != 0 && b.Winner == 0 {
+		next.State = "GAME_OVER"
+		next.Choices = 0
+	}
+	if next.State == "WAITING" {
+		next.TurnNumber++
+	}
+	return next, nil
+}
+
+// place puts tile on square p for mover and resolves boops, lines and the max-placed rule
+// as placePiece does.
+func (b *BitState) place(t *bitTables, p int, tile uint8, mover uint8) {
+	rules := b.rules()
+	hand := b.hand(mover)
+	b.Pieces[bitIndex(tile)] |= 1 << p
+	if isKitten(tile) {
+		hand.Kittens--
+	} else {
+		hand.Cats--
+	}
+	hand.Placed++
+
+	// Neighbours are pushed in different directions onto squares two away, so no boop
+	// changes another's source or landing square and the order doesn't matter here. A
+	// neighbour on n lands on 2n-p; off the board that is a square outside landings[p].
+	occupied := b.occupied()
+	for mask := occupied & t.neighbours[p]; mask != 0; mask &= mask - 1 {
+		n := bits.TrailingZeros64(mask)
+		k := b.pieceAt(n)
+		cat := k == bitP1Cat || k == bitP2Cat
+		if isKitten(tile) && cat && !rules.KittensBoopCats {
+			continue
+		}
+		switch to := 2*n - p; {
+		case to < 0 || to >= 64 || t.landings[p]&(1<<to) == 0:
+			b.Pieces[k] &^= 1 << n
+			owner := b.hand(tileOwner(bitTiles[k]))
+			if cat {
+				owner.Cats++
+			} else {
+				owner.Kittens++
+			}
+			owner.Placed--
+		case occupied&(1<<to) == 0:
+			b.Pieces[k] = b.Pieces[k]&^(1<<n) | 1<<to
+		}
+	}
+
+	middles, byDir := b.lines(t, mover)
+	_, cats := b.owned(mover)
+	if rules.WinLength == 3 {
+		for d, step := range lineSteps {
+			if byDir[d]&cats&(cats<<step)&(cats>>step) != 0 {
+				b.Winner, b.Result = mover, ResultThreeCats
+			}
+		}
+	} else if b.hasRun(t, cats, int(rules.WinLength)) {
+		b.Winner, b.Result = mover, ResultLine
+	}
+
+	switch bits.OnesCount64(middles) {
+	case 0:
+		if hand.Placed != rules.Pieces {
+			return
+		}
+		if rules.EightCatsWin && bits.OnesCount64(cats) >= int(rules.Pieces) {
+			b.Winner, b.Result = mover, ResultEightCats
+			return
+		}
+		b.State = "MAX_WAITING"
+	case 1:
+		p := bits.TrailingZeros64(middles)
+		for d, step := range lineSteps {
+			if byDir[d]&(1<<p) != 0 {
+				b.graduate(mover, 1<<(p-step)|1<<p|1<<(p+step))
+			}
+		}
+	default:
+		b.State = "MULTIPLE_WAITING"
+		b.Choices = middles
+	}
+}
+
+// lines finds the middles of mover's lines of three. Like isMiddleOfThreeInARow, a square
+// in the middle of lines in several directions only counts the first direction in
+// lineSteps; byDir holds each middle under the direction its line runs in.
+func (b *BitState) lines(t *bitTables, mover uint8) (middles uint64, byDir [4]uint64) {
+	kittens, cats := b.owned(mover)
+	mixed := b.rules().MixedLines
+	for d, step := range lineSteps {
+		var found uint64
+		if mixed {
+			all := kittens | cats
+			found = all & (all << step) & (all >> step)
+		} else {
+			found = kittens&(kittens<<step)&(kittens>>step) | cats&(cats<<step)&(cats>>step)
+		}
+		byDir[d] = found & t.middles[d] &^ middles
+		middles |= byDir[d]
+	}
+	return middles, byDir
+}
+
+// hasRun reports whether cats hold length squares in a row in any direction.
+func (b *BitState) hasRun(t *bitTables, cats uint64, length int) bool {
+	for d, step := range lineSteps {
+		run := cats & t.runStarts[d][length]
+		for i := 1; i < length && run != 0; i++ {
+			run &= cats >> (i * step)
+		}
+		if run != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// graduate takes the squares in mask off the board and gives mover a cat for each.
+func (b *BitState) graduate(mover uint8, mask uint64) {
+	b.remove(mask)
+	hand := b.hand(mover)
+	hand.Cats += 3
+	hand.Placed -= 3
+}
+
+func (b *BitState) remove(mask uint64) {
+	for k := range b.Pieces {
+		b.Pieces[k] &^= mask
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"math/rand"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// --- Helpers ---
+
+// bitStateRules are the rulesets the differential tests play under: the standard rules,
+// every board size and each rule changed on its own or together.
+var bitStateRules = []url.Values{
+	{},
+	{"boardSize": {"4"}},
+	{"boardSize": {"5"}, "pieces": {"6"}},
+	{"boardSize": {"7"}, "pieces": {"12"}, "mixedLines": {"false"}, "kittensBoopCats": {"true"}},
+	{"boardSize": {"8"}, "winLength": {"5"}},
+	{"kittensBoopCats": {"true"}},
+	{"mixedLines": {"false"}},
+	{"winLength": {"4"}, "eightCatsWin": {"false"}},
+	{"pieces": {"4"}},
+}
+
+func newRulesState(t testing.TB, query url.Values, firstMover uint8) GameState {
+	rules, err := parseRuleset(query)
+	if err != nil {
+		t.Fatalf("bad rules %v: %v", query, err)
+	}
+	gs := NewGameState()
+	gs.FirstMover = firstMover
+	gs.withRules(&rules, false)
+	return *gs
+}
+
+// randomAction is any action at all, legal or not.
+func randomAction(rng *rand.Rand) Action {
+	types := []ActionType{ActionPlace, ActionGraduateLine, ActionGraduatePiece}
+	pieces := []uint8{0, P1Kitten, P1Cat, P2Kitten, P2Cat}
+	return Action{
+		Type:     types[rng.Intn(len(types))],
+		Position: Position{X: uint8(rng.Intn(9)), Y: uint8(rng.Intn(9))},
+		Piece:    pieces[rng.Intn(len(pieces))],
+	}
+}
+
+// --- Differential ---
+
+func TestBitState_MatchesEngine(t *testing.T) {
+	seen := map[string]bool{}
+	for _, query := range bitStateRules {
+		for seed := int64(1); seed <= 25; seed++ {
+			rng := rand.New(rand.NewSource(seed))
+			state := newRulesState(t, query, uint8(1+seed%2))
+			bs := NewBitState(&state)
+			for n := 0; n < 300; n++ {
+				text := FormatState(&state)
+				if NewBitState(&state) != bs || FormatState(bs.GameState()) != text {
+					t.Fatalf("%v seed %d: expected the bitboard to be in %q, got %q", query, seed, text, FormatState(bs.GameState()))
+				}
+				legal := LegalMoves(&state)
+				if !slices.Equal(bs.LegalMoves(), legal) {
+					t.Fatalf("%v: expected the same legal moves in %q, got %v and %v", query, text, legal, bs.LegalMoves())
+				}
+				seen[state.State] = true
+				if state.isOver() {
+					break
+				}
+
+				// Arbitrary actions are refused, or taken, by both
+				probe := randomAction(rng)
+				slow, _, errSlow := Apply(state, probe)
+				fast, errFast := bs.Apply(probe)
+				if (errSlow == nil) != (errFast == nil) {
+					t.Fatalf("%v: expected %+v in %q to be refused by both or neither, got %v and %v", query, probe, text, errSlow, errFast)
+				}
+				if errSlow == nil && FormatState(&slow) != FormatState(fast.GameState()) {
+					t.Fatalf("%v: expected %+v in %q to agree, got %q and %q", query, probe, text, FormatState(&slow), FormatState(fast.GameState()))
+				}
+
+				action := legal[rng.Intn(len(legal))]
+				next, _, err := Apply(state, action)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if bs, err = bs.Apply(action); err != nil {
+					t.Fatalf("%v: unexpected bitboard error for %+v in %q: %v", query, action, text, err)
+				}
+				state = next
+			}
+		}
+	}
+	for _, state := range []string{"WAITING", "MULTIPLE_WAITING", "MAX_WAITING", "GAME_OVER"} {
+		if !seen[state] {
+			t.Errorf("expected the random games to reach %s", state)
+		}
+	}
+}
+
+func TestBitState_Positions(t *testing.T) {
+	for text, want := range map[string]int{
+		// b2 is in the middle of a1-b2-c3 and b1-b2-b3 but only the diagonal counts
+		"6/6/6/2K3/1KK3/KKK3 2,0 8,0 1 10 L:a1-b1-c1,a1-b2-c3,c1-c2-c3": 3,
+		"6/6/K1K3/6/K1K1K1/K1K1K1 0,0 8,0 1 10 G":                       8,
+		"6/6/6/6/6/6 8,0 8,0 1 0 #0:draw":                               0,
+		"5/5/5/5/K4 7,0 8,0 2 1 -":                                      24,
+		"8/8/8/8/8/8/8/7c 8,0 7,0 1 2 -":                                63,
+		"4/4/4/Kk2 7,0 7,0 1 2 -":                                       14,
+	} {
+		gs, err := ParseState(text)
+		if err != nil {
+			t.Errorf("%q: %v", text, err)
+			continue
+		}
+		bs := NewBitState(gs)
+		if got := len(bs.LegalMoves()); got != want || !slices.Equal(bs.LegalMoves(), LegalMoves(gs)) {
+			t.Errorf("%q: expected %d legal moves as LegalMoves lists them, got %d", text, want, got)
+		}
+		if FormatState(bs.GameState()) != FormatState(gs) {
+			t.Errorf("expected %q to convert back, got %q", text, FormatState(bs.GameState()))
+		}
+	}
+}
+
+// --- Benchmarks ---
+
+// Random playouts of up to 200 actions from the empty board, with each engine listing the
+// legal moves and applying one.
+func BenchmarkApplyPlayout(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	start := *NewGameState()
+	for i := 0; i < b.N; i++ {
+		state := start
+		for n := 0; n < 200 && !state.isOver(); n++ {
+			legal := LegalMoves(&state)
+			state, _, _ = Apply(state, legal[rng.Intn(len(legal))])
+		}
+	}
+}
+
+func BenchmarkBitStatePlayout(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	start := NewBitState(NewGameState())
+	var legal []Action
+	for i := 0; i < b.N; i++ {
+		state := start
+		for n := 0; n < 200 && !state.isOver(); n++ {
+			legal = state.AppendLegalMoves(legal[:0])
+			state, _ = state.Apply(legal[rng.Intn(len(legal))])
+		}
+	}
+}
+
+// Replaying the same recorded game, so only applying actions is timed.
+func BenchmarkApplyReplay(b *testing.B) {
+	actions, _ := randomGame(1, 200)
+	start := *NewGameState()
+	for i := 0; i < b.N; i++ {
+		state := start
+		for _, action := range actions {
+			state, _, _ = Apply(state, action)
+		}
+	}
+}
+
+func BenchmarkBitStateReplay(b *testing.B) {
+	actions, _ := randomGame(1, 200)
+	start := NewBitState(NewGameState())
+	for i := 0; i < b.N; i++ {
+		state := start
+		for _, action := range actions {
+			state, _ = state.Apply(action)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Bot difficulty levels. easy/medium/hard are accepted as aliases.
+const (
+	BotRandom  = "random"
+	BotGreedy  = "greedy"
+	BotMinimax = "minimax"
+)
+
+// Placements searched ahead by the minimax bot. Pending selections
+// (MULTIPLE_WAITING / MAX_WAITING) don't consume depth.
+const minimaxDepth = 3
+
+const winScore = 1_000_000
+
+// Pause before each bot action so the client's animations for the previous move can play.
+var botMoveDelay = 900 * time.Millisecond
+
+type Bot struct {
+	Level string
+	Seat  string
+	rng   *rand.Rand
+	wake  chan struct{}
+}
+
+func parseBotLevel(level string) (string, error) {
+	switch level {
+	case "easy", BotRandom:
+		return BotRandom, nil
+	case "", "medium", BotGreedy:
+		return BotGreedy, nil
+	case "hard", BotMinimax:
+		return BotMinimax, nil
+	}
+	return "", fmt.Errorf("unknown bot level %q", level)
+}
+
+func newBot(level string, seat string) *Bot {
+	return &Bot{
+		Level: level,
+		Seat:  seat,
+		rng:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// createBotGame starts a game with the connection as player1 and a bot in player2.
+// Bot games are never listed as waiting.
+func (server *Server) createBotGame(conn *websocket.Conn, level string, opts GameOptions) (*Game, error) {
+	level, err := parseBotLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	server.serverMutex.Lock()
+	defer server.serverMutex.Unlock()
+
+	game := NewGame()
+	game.applyOptions(opts)
+	for _, exists := server.games[game.ID]; exists; _, exists = server.games[game.ID] {
+		game.ID = generateGameID()
+	}
+	game.Players["player1"] = conn
+	game.bot = newBot(level, "player2")
+	server.games[game.ID] = game
+	server.saveNewGame(game)
+	log.Printf("Bot game created: %s (level %s)", game.ID, level)
+	return game, nil
+}
+
+// wakeBot nudges the bot goroutine to check whether it is its turn.
+func (game *Game) wakeBot() {
+	if game.bot == nil {
+		return
+	}
+	select {
+	case game.bot.wake <- struct{}{}:
+	default:
+	}
+}
+
+// botPump runs the bot's side of the game. One per bot game, exits when the game shuts down.
+func (game *Game) botPump(s *Server) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in botPump for game %s: %v", game.ID, r)
+		}
+	}()
+
+	for {
+		select {
+		case <-game.done:
+			return
+		case <-game.bot.wake:
+		}
+
+		for game.botToMove() {
+			select {
+			case <-game.done:
+				return
+			case <-time.After(botMoveDelay):
+			}
+
+			game.mutex.Lock()
+			snapshot := *game.GameState
+			game.mutex.Unlock()
+
+			action, ok := game.bot.chooseMove(&snapshot)
+			if !ok {
+				log.Printf("Bot in game %s has no legal move in state %s", game.ID, snapshot.State)
+				break
+			}
+
+			game.mutex.Lock()
+			if game.GameState.TurnNumber != snapshot.TurnNumber || game.GameState.State != snapshot.State {
+				// A takeback moved the game on while the bot was thinking
+				game.mutex.Unlock()
+				continue
+			}
+			_, err := game.applyAction(action)
+			game.mutex.Unlock()
+			if err != nil {
+				log.Printf("Bot in game %s chose an invalid action %+v: %v", game.ID, action, err)
+				break
+			}
+			game.commitTurn(s)
+		}
+	}
+}
+
+// botToMove reports whether the game is still running with the bot's seat to act.
+func (game *Game) botToMove() bool {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	return !game.GameState.isOver() && game.isValidTurn(game.bot.Seat)
+}
+
+// chooseMove picks the bot's next action for the current state. The search runs on a
+// BitState, which plays exactly as the engine does.
+func (bot *Bot) chooseMove(gs *GameState) (Action, bool) {
+	root := NewBitState(gs)
+	moves := root.LegalMoves()
+	if len(moves) == 0 {
+		return Action{}, false
+	}
+	// Shuffle so equally scored moves don't always resolve to the top-left corner
+	bot.rng.Shuffle(len(moves), func(i, j int) { moves[i], moves[j] = moves[j], moves[i] })
+
+	if bot.Level == BotRandom {
+		return moves[0], true
+	}
+
+	search := &botSearch{me: seatNumber(bot.Seat)}
+	depth := minimaxDepth
+	if gs.State == "WAITING" {
+		depth--
+	}
+	best, bestScore := moves[0], math.MinInt
+	for _, move := range moves {
+		next, err := root.Apply(move)
+		if err != nil {
+			continue
+		}
+		var score int
+		if bot.Level == BotGreedy {
+			score = search.resolvePending(&next, 0)
+		} else {
+			score = search.minimax(&next, depth, math.MinInt, math.MaxInt, 0)
+		}
+		if score > bestScore {
+			best, bestScore = move, score
+		}
+	}
+	return best, true
+}
+
+// botSearch is one chooseMove's look-ahead, scoring for player me. It keeps a move list
+// and a child list for each ply and reuses them, so once every ply has been reached the
+// search allocates nothing.
+type botSearch struct {
+	me       uint8
+	moves    [][]Action
+	children [][]BitState
+	order    [][]searchChild
+}
+
+// searchChild is a child's static score and its index in the ply's child list, which is
+// sorted instead of the children themselves.
+type searchChild struct {
+	score int
+	index int
+}
+
+// buffers returns ply's emptied move, child and order lists.
+func (search *botSearch) buffers(ply int) ([]Action, []BitState, []searchChild) {
+	for len(search.moves) <= ply {
+		search.moves = append(search.moves, nil)
+		search.children = append(search.children, nil)
+		search.order = append(search.order, nil)
+	}
+	return search.moves[ply][:0], search.children[ply][:0], search.order[ply][:0]
+}
+
+// resolvePending plays out any selection the mover still owes (best choice for them)
+// and scores the result.
+func (search *botSearch) resolvePending(b *BitState, ply int) int {
+	if b.Winner != 0 || b.State == "WAITING" {
+		return evaluate(b, search.me)
+	}
+	maximizing := b.mover() == search.me
+	best := math.MaxInt
+	if maximizing {
+		best = math.MinInt
+	}
+	moves, _, _ := search.buffers(ply)
+	moves = b.AppendLegalMoves(moves)
+	search.moves[ply] = moves
+	for _, move := range moves {
+		next, err := b.Apply(move)
+		if err != nil {
+			continue
+		}
+		score := search.resolvePending(&next, ply+1)
+		if maximizing && score > best || !maximizing && score < best {
+			best = score
+		}
+	}
+	return best
+}
+
+// minimax searches depth placements ahead with alpha-beta pruning. Whose move it is comes
+// from the state, not from alternation, since a placement that leaves a pending selection
+// keeps the same player on move.
+func (search *botSearch) minimax(b *BitState, depth int, alpha, beta int, ply int) int {
+	me := search.me
+	if b.Winner != 0 {
+		// Prefer faster wins and slower losses
+		if b.Winner == me {
+			return winScore + depth
+		}
+		return -winScore - depth
+	}
+	if depth <= 0 && b.State == "WAITING" {
+		return evaluate(b, me)
+	}
+
+	moves, children, order := search.buffers(ply)
+	moves = b.AppendLegalMoves(moves)
+	search.moves[ply] = moves
+	if len(moves) == 0 {
+		return evaluate(b, me)
+	}
+	maximizing := b.mover() == me
+
+	// Order children by static score so alpha-beta cuts early
+	for _, move := range moves {
+		next, err := b.Apply(move)
+		if err != nil {
+			continue
+		}
+		order = append(order, searchChild{evaluate(&next, me), len(children)})
+		children = append(children, next)
+	}
+	search.children[ply], search.order[ply] = children, order
+
+	nextDepth := depth
+	if b.State == "WAITING" {
+		nextDepth--
+	}
+	// On the last ply the children back in WAITING are leaves scored already, so ordering
+	// them gains nothing
+	if nextDepth > 0 {
+		if maximizing {
+			slices.SortFunc(order, highestScoreFirst)
+		} else {
+			slices.SortFunc(order, lowestScoreFirst)
+		}
+	}
+	value := func(c searchChild) int {
+		child := &children[c.index]
+		if nextDepth <= 0 && child.Winner == 0 && child.State == "WAITING" {
+			return c.score
+		}
+		return search.minimax(child, nextDepth, alpha, beta, ply+1)
+	}
+
+	if maximizing {
+		best := math.MinInt
+		for _, c := range order {
+			best = max(best, value(c))
+			alpha = max(alpha, best)
+			if alpha >= beta {
+				break
+			}
+		}
+		return best
+	}
+	best := math.MaxInt
+	for _, c := range order {
+		best = min(best, value(c))
+		beta = min(beta, best)
+		if alpha >= beta {
+			break
+		}
+	}
+	return best
+}
+
+func highestScoreFirst(a, b searchChild) int { return b.score - a.score }
+func lowestScoreFirst(a, b searchChild) int  { return a.score - b.score }
+
+// evaluate scores a position from player me's point of view.
+func evaluate(b *BitState, me uint8) int {
+	if b.Winner != 0 {
+		if b.Winner == me {
+			return winScore
+		}
+		return -winScore
+	}
+	t := b.tables()
+	score := sideScore(b, t, 1) - sideScore(b, t, 2)
+	if me == 2 {
+		score = -score
+	}
+	return score
+}
+
+// sideScore rates one player's material and board presence.
+func sideScore(b *BitState, t *bitTables, player uint8) int {
+	kittens, cats := b.owned(player)
+	own := kittens | cats
+	score := 4*bits.OnesCount64(kittens) + 6*bits.OnesCount64(cats)
+	// Edge pieces are easy to boop off; central ones are hard to dislodge
+	score += -2*bits.OnesCount64(own&t.edges) + 3*bits.OnesCount64(own&t.centre) +
+		bits.OnesCount64(own&^t.edges&^t.centre)
+	// Every cat owned is progress towards a win, whether placed or in hand
+	score += 100 * (int(b.hand(player).Cats) + bits.OnesCount64(cats))
+	score += openTwos(b, t, player)
+	return score
+}
+
+// openTwos rewards three-square windows holding two of player's pieces and an empty
+// square, more so when both pieces are cats.
+func openTwos(b *BitState, t *bitTables, player uint8) int {
+	kittens, cats := b.owned(player)
+	own := kittens | cats
+	empty := t.onBoard &^ b.occupied()
+	score := 0
+	for d, step := range lineSteps {
+		// Bit s of each mask is the square step or 2*step along from window start s
+		own1, own2 := own>>step, own>>(2*step)
+		empty1, empty2 := empty>>step, empty>>(2*step)
+		cats1, cats2 := cats>>step, cats>>(2*step)
+		starts := t.runStarts[d][3]
+		twos := (own&own1&empty2 | own&empty1&own2 | empty&own1&own2) & starts
+		catTwos := (cats&cats1&empty2 | cats&empty1&cats2 | empty&cats1&cats2) & starts
+		score += 15*bits.OnesCount64(twos) + 30*bits.OnesCount64(catTwos)
+	}
+	return score
+}
+
+func seatNumber(seat string) uint8 {
+	if seat == "player1" {
+		return 1
+	}
+	return 2
+}
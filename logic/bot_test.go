@@ -0,0 +1,171 @@
+package main
+
+import "testing"
+
+// --- Helpers ---
+
+func newTestBot(level string) *Bot {
+	bot := newBot(level, "player1")
+	bot.rng.Seed(1)
+	return bot
+}
+
+// hand returns a player's pieces in hand plus on board, which must always total 8.
+func piecesOwned(gs *GameState, player uint8) int {
+	p := gs.P1
+	if player == 2 {
+		p = gs.P2
+	}
+	return int(p.Kittens) + int(p.Cats) + int(p.Placed)
+}
+
+// --- Levels ---
+
+func TestParseBotLevel(t *testing.T) {
+	cases := map[string]string{
+		"easy": BotRandom, "random": BotRandom,
+		"": BotGreedy, "medium": BotGreedy,
+		"hard": BotMinimax, "minimax": BotMinimax,
+	}
+	for in, want := range cases {
+		if got, err := parseBotLevel(in); err != nil || got != want {
+			t.Errorf("parseBotLevel(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := parseBotLevel("impossible"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+// --- Search ---
+
+// Searching never touches the state the bot is asked about.
+func TestChooseMove_DoesNotMutateInput(t *testing.T) {
+	gs := newP1Turn()
+	place(gs, P2Kitten, 3, 3)
+	before := FormatState(gs)
+
+	if _, ok := newTestBot(BotMinimax).chooseMove(gs); !ok {
+		t.Fatal("expected a move")
+	}
+	if FormatState(gs) != before {
+		t.Errorf("expected the search to leave %q untouched, got %q", before, FormatState(gs))
+	}
+}
+
+// The bitboard evaluation scores material, placement and open twos.
+func TestEvaluate_Scores(t *testing.T) {
+	gs := newP1Turn()
+	gs.Board[2][2] = P1Cat    // central
+	gs.Board[2][3] = P1Cat    // central, with c3 an open two of cats along the rank
+	gs.Board[0][5] = P2Kitten // edge
+	gs.P1 = Player{Kittens: 6, Placed: 2}
+	gs.P2 = Player{Kittens: 7, Placed: 1}
+	b := NewBitState(gs)
+	// player1: 2 cats (12) + centre (6) + cats owned (200) + open twos across, both ends (2 * 45)
+	// player2: a kitten (4) on the edge (-2)
+	if got, want := evaluate(&b, 1), 12+6+200+90-2; got != want {
+		t.Errorf("expected player1 to score %d, got %d", want, got)
+	}
+	if evaluate(&b, 2) != -evaluate(&b, 1) {
+		t.Error("expected the score to be zero-sum")
+	}
+}
+
+// --- Play ---
+
+// Bots of every level play complete, legal games against each other.
+func TestBot_SelfPlayStaysLegal(t *testing.T) {
+	for _, level := range []string{BotRandom, BotGreedy} {
+		gs := NewGameState()
+		p1, p2 := newTestBot(level), newTestBot(level)
+		p2.Seat = "player2"
+		for ply := 0; ply < 400 && gs.Winner == 0; ply++ {
+			bot := p1
+			if !gs.isPlayer1() {
+				bot = p2
+			}
+			move, ok := bot.chooseMove(gs)
+			if !ok {
+				t.Fatalf("%s: no move available in state %s", level, gs.State)
+			}
+			next, _, err := Apply(*gs, move)
+			if err != nil {
+				t.Fatalf("%s: bot chose illegal move %+v: %v", level, move, err)
+			}
+			gs = &next
+			if piecesOwned(gs, 1) != 8 || piecesOwned(gs, 2) != 8 {
+				t.Fatalf("%s: piece count drifted: P1 %+v, P2 %+v", level, gs.P1, gs.P2)
+			}
+		}
+	}
+}
+
+// Greedy and minimax bots take a winning line of cats when one is available.
+func TestBot_TakesImmediateWin(t *testing.T) {
+	for _, level := range []string{BotGreedy, BotMinimax} {
+		gs := newP1Turn()
+		gs.P1 = Player{Kittens: 6, Cats: 0, Placed: 2}
+		gs.Board[2][1] = P1Cat
+		gs.Board[2][2] = P1Cat
+		gs.P1.Cats = 1
+		gs.P1.Kittens = 5
+
+		move, _ := newTestBot(level).chooseMove(gs)
+		next, _, err := Apply(*gs, move)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", level, err)
+		}
+		if next.Winner != 1 {
+			t.Errorf("%s: expected bot to win immediately, played %+v", level, move)
+		}
+	}
+}
+
+// The minimax bot blocks an opponent's open line of two cats.
+func TestBot_MinimaxBlocksThreat(t *testing.T) {
+	gs := newP1Turn()
+	gs.P1 = Player{Kittens: 8, Cats: 0, Placed: 0}
+	gs.P2 = Player{Kittens: 8, Cats: 1, Placed: 2}
+	gs.Board[0][0] = P2Cat
+	gs.Board[0][1] = P2Cat
+
+	move, _ := newTestBot(BotMinimax).chooseMove(gs)
+	next, _, err := Apply(*gs, move)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, reply := range LegalMoves(&next) {
+		after, _, err := Apply(next, reply)
+		if err == nil && after.Winner == 2 {
+			t.Fatalf("expected bot to stop the three-cat threat, played %+v and lost to %+v", move, reply)
+		}
+	}
+}
+
+func BenchmarkMinimaxMove(b *testing.B) {
+	gs := newP1Turn()
+	place(gs, P1Kitten, 2, 2)
+	place(gs, P2Kitten, 3, 3)
+	place(gs, P1Kitten, 1, 4)
+	place(gs, P2Kitten, 4, 1)
+	bot := newTestBot(BotMinimax)
+	for i := 0; i < b.N; i++ {
+		bot.chooseMove(gs)
+	}
+}
+
+func BenchmarkMinimaxMoveLargeBoard(b *testing.B) {
+	rules := StandardRules
+	rules.BoardSize = 8
+	gs := newRulesTurn(rules, Player{Kittens: 8})
+	gs.P2 = Player{Kittens: 8}
+	place(gs, P1Kitten, 3, 3)
+	place(gs, P2Kitten, 4, 4)
+	place(gs, P1Kitten, 2, 5)
+	place(gs, P2Kitten, 5, 2)
+	bot := newTestBot(BotMinimax)
+	for i := 0; i < b.N; i++ {
+		bot.chooseMove(gs)
+	}
+}
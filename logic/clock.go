@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits on what a creator can ask for
+const (
+	maxInitialTime = 3 * time.Hour
+	maxIncrement   = time.Minute
+	minMoveTime    = 5 * time.Second
+	maxMoveTime    = 10 * time.Minute
+)
+
+// TimeControl is fixed at game creation. A game with neither a bank nor a per-move limit is untimed.
+type TimeControl struct {
+	InitialMs   int64 `json:"initialMs"`           // each seat's bank; 0 for no bank
+	IncrementMs int64 `json:"incrementMs"`         // Fischer increment added after each completed turn
+	PerMoveMs   int64 `json:"perMoveMs,omitempty"` // cap on a single turn; 0 for none
+}
+
+// ClockState is the clock as of a broadcast. Clients count the running seat down locally.
+type ClockState struct {
+	TimeControl
+	P1Ms    int64  `json:"p1Ms"`              // remaining bank
+	P2Ms    int64  `json:"p2Ms"`              // remaining bank
+	Running string `json:"running,omitempty"` // seat whose clock is running, "" when stopped
+	TurnMs  int64  `json:"turnMs,omitempty"`  // time left for the running seat's current turn
+}
+
+// gameClock is the server-authoritative clock for a timed game. Guarded by game.mutex.
+type gameClock struct {
+	control   TimeControl
+	remaining map[string]time.Duration // bank per seat
+	running   string
+	turn      uint8 // TurnNumber when the running seat's turn began
+	turnStart time.Time
+	flag      *time.Timer // fires when the running seat runs out of time
+}
+
+// parseTimeControl reads "minutes+increment" (e.g. "5+3") and an optional per-move limit in
+// seconds. Both empty (or time "untimed") means an untimed game, returned as nil.
+func parseTimeControl(timeParam string, moveParam string) (*TimeControl, error) {
+	var tc TimeControl
+	if timeParam != "" && timeParam != "untimed" {
+		minutes, increment, _ := strings.Cut(timeParam, "+")
+		m, err := strconv.ParseFloat(minutes, 64)
+		initial := time.Duration(m * float64(time.Minute))
+		if err != nil || initial <= 0 || initial > maxInitialTime {
+			return nil, fmt.Errorf("invalid time control %q", timeParam)
+		}
+		tc.InitialMs = initial.Milliseconds()
+		if increment != "" {
+			inc, err := strconv.Atoi(increment)
+			if err != nil || inc < 0 || time.Duration(inc)*time.Second > maxIncrement {
+				return nil, fmt.Errorf("invalid increment in time control %q", timeParam)
+			}
+			tc.IncrementMs = (time.Duration(inc) * time.Second).Milliseconds()
+		}
+	}
+	if moveParam != "" {
+		secs, err := strconv.Atoi(moveParam)
+		perMove := time.Duration(secs) * time.Second
+		if err != nil || perMove < minMoveTime || perMove > maxMoveTime {
+			return nil, fmt.Errorf("invalid move time %q", moveParam)
+		}
+		tc.PerMoveMs = perMove.Milliseconds()
+	}
+	if tc.InitialMs == 0 && tc.PerMoveMs == 0 {
+		return nil, nil
+	}
+	return &tc, nil
+}
+
+func newGameClock(tc TimeControl) *gameClock {
+	bank := time.Duration(tc.InitialMs) * time.Millisecond
+	return &gameClock{
+		control:   tc,
+		remaining: map[string]time.Duration{"player1": bank, "player2": bank},
+	}
+}
+
+// restoreGameClock rebuilds a stopped clock from the last persisted broadcast.
+func restoreGameClock(cs *ClockState) *gameClock {
+	clock := newGameClock(cs.TimeControl)
+	clock.remaining["player1"] = time.Duration(cs.P1Ms) * time.Millisecond
+	clock.remaining["player2"] = time.Duration(cs.P2Ms) * time.Millisecond
+	return clock
+}
+
+func (clock *gameClock) banked() bool {
+	return clock.control.InitialMs > 0
+}
+
+// budget is how long seat has for a turn starting now.
+func (clock *gameClock) budget(seat string) time.Duration {
+	perMove := time.Duration(clock.control.PerMoveMs) * time.Millisecond
+	if !clock.banked() {
+		return perMove
+	}
+	if perMove > 0 {
+		return min(perMove, clock.remaining[seat])
+	}
+	return clock.remaining[seat]
+}
+
+// start runs seat's clock from now and arms the flag timer.
+func (clock *gameClock) start(seat string, turn uint8, now time.Time, onFlag func(seat string, started time.Time)) {
+	clock.running = seat
+	clock.turn = turn
+	clock.turnStart = now
+	clock.flag = time.AfterFunc(clock.budget(seat), func() {
+		onFlag(seat, now)
+	})
+}
+
+// stop charges the running seat for the time used and disarms the flag timer.
+func (clock *gameClock) stop(now time.Time) {
+	if clock.running == "" {
+		return
+	}
+	if clock.flag != nil {
+		clock.flag.Stop()
+	}
+	if clock.banked() {
+		clock.remaining[clock.running] = max(0, clock.remaining[clock.running]-now.Sub(clock.turnStart))
+	}
+	clock.running = ""
+}
+
+func (clock *gameClock) state(now time.Time) *ClockState {
+	cs := &ClockState{
+		TimeControl: clock.control,
+		P1Ms:        clock.remaining["player1"].Milliseconds(),
+		P2Ms:        clock.remaining["player2"].Milliseconds(),
+		Running:     clock.running,
+	}
+	if clock.running != "" {
+		elapsed := now.Sub(clock.turnStart)
+		cs.TurnMs = max(0, clock.budget(clock.running)-elapsed).Milliseconds()
+		if clock.banked() {
+			left := max(0, clock.remaining[clock.running]-elapsed).Milliseconds()
+			if clock.running == "player1" {
+				cs.P1Ms = left
+			} else {
+				cs.P2Ms = left
+			}
+		}
+	}
+	return cs
+}
+
+// moverSeat is the seat whose clock should be running in gs.
+func moverSeat(gs *GameState) string {
+	if gs.isPlayer1() {
+		return "player1"
+	}
+	return "player2"
+}
+
+// tickClock keeps the clock in step with the game after a state change: the mover's clock
+// runs through any pending selection, the increment is paid once their turn completes, and
+// everything stops once the game is over.
+func (game *Game) tickClock(s *Server) {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+
+	clock := game.clock
+	if clock == nil {
+		return
+	}
+	now := time.Now()
+	if game.GameState.isOver() {
+		clock.stop(now)
+		return
+	}
+	mover := moverSeat(game.GameState)
+	if mover == clock.running {
+		return
+	}
+	if previous := clock.running; previous != "" {
+		clock.stop(now)
+		if clock.banked() && game.GameState.TurnNumber > clock.turn {
+			clock.remaining[previous] += time.Duration(clock.control.IncrementMs) * time.Millisecond
+		}
+	}
+	clock.start(mover, game.GameState.TurnNumber, now, func(seat string, started time.Time) {
+		s.flagFall(game, seat, started)
+	})
+}
+
+// stopClock freezes both clocks, e.g. when the game leaves memory.
+func (game *Game) stopClock() {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if game.clock != nil {
+		game.clock.stop(time.Now())
+	}
+}
+
+// clockState is the clock for a broadcast, or nil for an untimed game.
+func (game *Game) clockState() *ClockState {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if game.clock == nil {
+		return nil
+	}
+	return game.clock.state(time.Now())
+}
+
+// flagFall runs when seat's turn that began at started runs out of time: the opponent wins.
+func (s *Server) flagFall(game *Game, seat string, started time.Time) {
+	game.mutex.Lock()
+	clock := game.clock
+	if clock.running != seat || !clock.turnStart.Equal(started) || game.GameState.isOver() {
+		// The turn ended (or the game did) just as the timer fired
+		game.mutex.Unlock()
+		return
+	}
+	clock.stop(time.Now())
+	game.endGame(seatNumber(otherSeat(seat)), ResultTimeout)
+	game.mutex.Unlock()
+
+	log.Printf("Player %s ran out of time in game %s", seat, game.ID)
+	game.commitTurn(s)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// --- Helpers ---
+
+func newTimedGame(s *Server, tc TimeControl) *Game {
+	game := newSeatedGame(s)
+	game.applyOptions(GameOptions{TimeControl: &tc})
+	game.tickClock(s)
+	return game
+}
+
+// --- Time control parsing ---
+
+func TestParseTimeControl(t *testing.T) {
+	tc, err := parseTimeControl("5+3", "")
+	if err != nil || tc == nil || tc.InitialMs != 300_000 || tc.IncrementMs != 3_000 || tc.PerMoveMs != 0 {
+		t.Errorf("expected 5 minutes + 3 seconds, got %+v (%v)", tc, err)
+	}
+	tc, err = parseTimeControl("", "30")
+	if err != nil || tc == nil || tc.InitialMs != 0 || tc.PerMoveMs != 30_000 {
+		t.Errorf("expected a 30 second per-move limit, got %+v (%v)", tc, err)
+	}
+	if tc, err := parseTimeControl("untimed", ""); err != nil || tc != nil {
+		t.Errorf("expected untimed to mean no clock, got %+v (%v)", tc, err)
+	}
+	if tc, err := parseTimeControl("", ""); err != nil || tc != nil {
+		t.Errorf("expected no parameters to mean no clock, got %+v (%v)", tc, err)
+	}
+	for _, bad := range [][2]string{{"fast", ""}, {"0+5", ""}, {"5+120", ""}, {"", "2"}, {"", "soon"}} {
+		if _, err := parseTimeControl(bad[0], bad[1]); err == nil {
+			t.Errorf("expected time=%q moveTime=%q to be rejected", bad[0], bad[1])
+		}
+	}
+}
+
+// --- Clock running ---
+
+func TestClock_FischerIncrementAfterTurn(t *testing.T) {
+	s := NewServer()
+	game := newTimedGame(s, TimeControl{InitialMs: 60_000, IncrementMs: 2_000})
+	if game.clock.running != "player1" {
+		t.Fatalf("expected player1's clock to be running, got %q", game.clock.running)
+	}
+	game.clock.turnStart = game.clock.turnStart.Add(-5 * time.Second)
+
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	game.tickClock(s)
+
+	if game.clock.running != "player2" {
+		t.Errorf("expected player2's clock to be running, got %q", game.clock.running)
+	}
+	bank := game.clock.remaining["player1"]
+	if bank > 57*time.Second || bank < 56*time.Second {
+		t.Errorf("expected about 57s left for player1 (60 - 5 + 2), got %v", bank)
+	}
+	if game.clock.remaining["player2"] != 60*time.Second {
+		t.Errorf("expected player2's bank to be untouched, got %v", game.clock.remaining["player2"])
+	}
+	game.stopClock()
+}
+
+func TestClock_RunsThroughPendingSelection(t *testing.T) {
+	s := NewServer()
+	game := newTimedGame(s, TimeControl{InitialMs: 60_000, IncrementMs: 2_000})
+	place(game.GameState, P1Kitten, 0, 2)
+	place(game.GameState, P1Kitten, 1, 2)
+	place(game.GameState, P1Kitten, 2, 0)
+	place(game.GameState, P1Kitten, 2, 1)
+	started := game.clock.turnStart
+
+	playAction(t, game, placeAction(P1Kitten, 2, 2))
+	game.tickClock(s)
+	if game.clock.running != "player1" || !game.clock.turnStart.Equal(started) {
+		t.Error("expected player1's clock to keep running during MULTIPLE_WAITING")
+	}
+	game.stopClock()
+}
+
+func TestClock_FlagFallLosesGame(t *testing.T) {
+	s := NewServer()
+	game := newTimedGame(s, TimeControl{PerMoveMs: 20})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		game.mutex.Lock()
+		winner := game.GameState.Winner
+		game.mutex.Unlock()
+		if winner != 0 {
+			if winner != 2 {
+				t.Errorf("expected player2 to win on time, got winner %d", winner)
+			}
+			if game.clock.running != "" {
+				t.Error("expected the clock to stop once the flag fell")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected player1's flag to fall")
+}
+
+func TestClock_InBroadcast(t *testing.T) {
+	s := NewServer()
+	game := newTimedGame(s, TimeControl{InitialMs: 60_000})
+	defer game.stopClock()
+
+	game.broadcastGameState()
+	clock := game.GameState.Clock
+	if clock == nil || clock.Running != "player1" || clock.P2Ms != 60_000 || clock.P1Ms > 60_000 {
+		t.Errorf("expected the broadcast to carry player1's running clock, got %+v", clock)
+	}
+
+	untimed := newSeatedGame(s)
+	untimed.broadcastGameState()
+	if untimed.GameState.Clock != nil {
+		t.Error("expected no clock in an untimed game's broadcast")
+	}
+}
@@ -1,31 +1,137 @@
-//go:build db
-
 package main
 
 import (
 	"database/sql"
 	"encoding/json"
 	"log"
+	"sync"
+	"time"
+
 	_ "modernc.org/sqlite"
 )
 
+// Unfinished games that nobody has touched for this long are pruned on boot.
+const gameRetention = 7 * 24 * time.Hour
+
 func initDB(path string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite", path)
 	if err != nil {
 		return nil, err
 	}
+	// SQLite only supports a single writer; serialise access through one connection
+	db.SetMaxOpenConns(1)
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS games (
 			id TEXT PRIMARY KEY,
 			state TEXT NOT NULL,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)
+		);
+		CREATE TABLE IF NOT EXISTS seats (
+			game_id TEXT NOT NULL,
+			seat TEXT NOT NULL,
+			token TEXT NOT NULL,
+			PRIMARY KEY (game_id, seat)
+		);
+		CREATE TABLE IF NOT EXISTS bots (
+			game_id TEXT PRIMARY KEY,
+			seat TEXT NOT NULL,
+			level TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			password_hash TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS game_users (
+			game_id TEXT NOT NULL,
+			seat TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			PRIMARY KEY (game_id, seat)
+		);
+		CREATE TABLE IF NOT EXISTS ratings (
+			user_id INTEGER PRIMARY KEY,
+			rating REAL NOT NULL,
+			deviation REAL NOT NULL,
+			volatility REAL NOT NULL,
+			games INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS rating_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			game_id TEXT NOT NULL,
+			opponent_id INTEGER NOT NULL,
+			score REAL NOT NULL,
+			rating_before REAL NOT NULL,
+			rating REAL NOT NULL,
+			deviation REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS rating_history_user ON rating_history (user_id, id);
+		CREATE TABLE IF NOT EXISTS game_actions (
+			game_id TEXT NOT NULL,
+			seq INTEGER NOT NULL,
+			seat TEXT NOT NULL,
+			action TEXT NOT NULL,
+			events TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (game_id, seq)
+		);
+		CREATE TABLE IF NOT EXISTS finished_games (
+			id TEXT PRIMARY KEY,
+			p1_user_id INTEGER,
+			p2_user_id INTEGER,
+			bot_seat TEXT,
+			bot_level TEXT,
+			winner INTEGER NOT NULL,
+			result TEXT NOT NULL,
+			rated INTEGER NOT NULL,
+			time_control TEXT,
+			first_mover INTEGER NOT NULL,
+			actions INTEGER NOT NULL,
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME NOT NULL,
+			final_state TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS game_positions (
+			game_id TEXT PRIMARY KEY,
+			position TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS rated_games (
+			game_id TEXT PRIMARY KEY
+		);
+		CREATE TABLE IF NOT EXISTS game_options (
+			game_id TEXT PRIMARY KEY,
+			first TEXT NOT NULL,
+			visibility TEXT NOT NULL,
+			passphrase TEXT NOT NULL,
+			name TEXT NOT NULL,
+			variant TEXT NOT NULL,
+			time_control TEXT,
+			invite_code TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS waiting_games (
+			game_id TEXT PRIMARY KEY
+		);
+		CREATE INDEX IF NOT EXISTS finished_games_p1 ON finished_games (p1_user_id, finished_at);
+		CREATE INDEX IF NOT EXISTS finished_games_p2 ON finished_games (p2_user_id, finished_at)
 	`)
-	return db, err
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
 }
 
 func (s *Server) saveGame(game *Game) {
+	if s.db == nil {
+		return
+	}
+	game.mutex.Lock()
 	data, err := json.Marshal(game.GameState)
+	game.mutex.Unlock()
 	if err != nil {
 		log.Printf("saveGame: failed to marshal game state: %v", err)
 		return
@@ -36,6 +142,100 @@ func (s *Server) saveGame(game *Game) {
 	`, game.ID, string(data))
 	if err != nil {
 		log.Printf("saveGame: failed to save game %s: %v", game.ID, err)
+		return
+	}
+	s.saveActions(game)
+	s.archiveGame(game)
+}
+
+// saveNewGame persists a game as it is created. Its seat tokens, bot, options and invite
+// code are fixed from then on, so they are written here once rather than on every save.
+// Caller must hold serverMutex.
+func (s *Server) saveNewGame(game *Game) {
+	if s.db == nil {
+		return
+	}
+	s.saveGame(game)
+	game.mutex.Lock()
+	tokens := make(map[string]string, len(game.tokens))
+	for seat, token := range game.tokens {
+		tokens[seat] = token
+	}
+	opts := game.options
+	bot := game.bot
+	inviteCode, createdAt := game.inviteCode, game.createdAt
+	game.mutex.Unlock()
+
+	for seat, token := range tokens {
+		_, err := s.db.Exec(`INSERT OR IGNORE INTO seats (game_id, seat, token) VALUES (?, ?, ?)`, game.ID, seat, token)
+		if err != nil {
+			log.Printf("saveNewGame: failed to save seat %s for game %s: %v", seat, game.ID, err)
+		}
+		s.saveGameUser(game, seat)
+	}
+	var timeControl interface{}
+	if opts.TimeControl != nil {
+		data, _ := json.Marshal(opts.TimeControl)
+		timeControl = string(data)
+	}
+	_, err := s.db.Exec(`
+		INSERT OR IGNORE INTO game_options (game_id, first, visibility, passphrase, name, variant, time_control, invite_code, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, game.ID, opts.First, opts.Visibility, opts.Passphrase, opts.Name, opts.Variant, timeControl, inviteCode, createdAt.UTC())
+	if err != nil {
+		log.Printf("saveNewGame: failed to save options for game %s: %v", game.ID, err)
+	}
+	if _, waiting := s.waitingGames[game.ID]; waiting {
+		_, err := s.db.Exec(`INSERT OR IGNORE INTO waiting_games (game_id) VALUES (?)`, game.ID)
+		if err != nil {
+			log.Printf("saveNewGame: failed to save waiting flag for game %s: %v", game.ID, err)
+		}
+	}
+	if opts.Position != "" {
+		_, err := s.db.Exec(`INSERT OR IGNORE INTO game_positions (game_id, position) VALUES (?, ?)`, game.ID, opts.Position)
+		if err != nil {
+			log.Printf("saveNewGame: failed to save start position for game %s: %v", game.ID, err)
+		}
+	}
+	if opts.Rated {
+		_, err := s.db.Exec(`INSERT OR IGNORE INTO rated_games (game_id) VALUES (?)`, game.ID)
+		if err != nil {
+			log.Printf("saveNewGame: failed to save rated flag for game %s: %v", game.ID, err)
+		}
+	}
+	if bot != nil {
+		_, err := s.db.Exec(`INSERT OR IGNORE INTO bots (game_id, seat, level) VALUES (?, ?, ?)`, game.ID, bot.Seat, bot.Level)
+		if err != nil {
+			log.Printf("saveNewGame: failed to save bot for game %s: %v", game.ID, err)
+		}
+	}
+}
+
+// saveGameJoined records that a waiting game's second seat has been taken. Caller must hold
+// serverMutex.
+func (s *Server) saveGameJoined(game *Game) {
+	if s.db == nil {
+		return
+	}
+	if _, err := s.db.Exec(`DELETE FROM waiting_games WHERE game_id = ?`, game.ID); err != nil {
+		log.Printf("saveGameJoined: failed to clear waiting flag for game %s: %v", game.ID, err)
+	}
+}
+
+// saveGameUser records the account bound to seat, if any. Like bindUser, the first one sticks.
+func (s *Server) saveGameUser(game *Game, seat string) {
+	if s.db == nil {
+		return
+	}
+	game.mutex.Lock()
+	user := game.users[seat]
+	game.mutex.Unlock()
+	if user == nil {
+		return
+	}
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO game_users (game_id, seat, user_id) VALUES (?, ?, ?)`, game.ID, seat, user.ID)
+	if err != nil {
+		log.Printf("saveGameUser: failed to save user for seat %s of game %s: %v", seat, game.ID, err)
 	}
 }
 
@@ -52,9 +252,351 @@ func (s *Server) loadGame(gameID string) (*GameState, error) {
 	return &gameState, nil
 }
 
+func (s *Server) loadSeatTokens(gameID string) (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT seat, token FROM seats WHERE game_id = ?`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make(map[string]string)
+	for rows.Next() {
+		var seat, token string
+		if err := rows.Scan(&seat, &token); err != nil {
+			return nil, err
+		}
+		tokens[seat] = token
+	}
+	return tokens, rows.Err()
+}
+
+// loadBot returns the bot seated in a game, or nil if both seats are human.
+func (s *Server) loadBot(gameID string) (*Bot, error) {
+	var seat, level string
+	err := s.db.QueryRow(`SELECT seat, level FROM bots WHERE game_id = ?`, gameID).Scan(&seat, &level)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBot(level, seat), nil
+}
+
+// loadRated reports whether a game was started as rated.
+func (s *Server) loadRated(gameID string) (bool, error) {
+	var id string
+	err := s.db.QueryRow(`SELECT game_id FROM rated_games WHERE game_id = ?`, gameID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// storedOptions is a game_options row: the creator's choices that the game state doesn't
+// carry, and what the game was given at creation.
+type storedOptions struct {
+	GameOptions
+	inviteCode string
+	createdAt  time.Time
+}
+
+// loadGameOptions returns the options a game was created with. Games saved before options
+// were stored come back public with no invite code or passphrase.
+func (s *Server) loadGameOptions(gameID string) (storedOptions, error) {
+	var stored storedOptions
+	var timeControl sql.NullString
+	err := s.db.QueryRow(`
+		SELECT first, visibility, passphrase, name, variant, time_control, invite_code, created_at
+		FROM game_options WHERE game_id = ?
+	`, gameID).Scan(&stored.First, &stored.Visibility, &stored.Passphrase, &stored.Name, &stored.Variant,
+		&timeControl, &stored.inviteCode, &stored.createdAt)
+	if err == sql.ErrNoRows {
+		stored.Visibility = VisibilityPublic
+		stored.createdAt = time.Now()
+		return stored, nil
+	}
+	if err != nil {
+		return stored, err
+	}
+	if timeControl.Valid {
+		stored.TimeControl = &TimeControl{}
+		if err := json.Unmarshal([]byte(timeControl.String), stored.TimeControl); err != nil {
+			return stored, err
+		}
+	}
+	return stored, nil
+}
+
+// loadWaiting reports whether a game was still waiting for its second player.
+func (s *Server) loadWaiting(gameID string) (bool, error) {
+	var id string
+	err := s.db.QueryRow(`SELECT game_id FROM waiting_games WHERE game_id = ?`, gameID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// loadGameUsers returns the accounts bound to a game's seats.
+func (s *Server) loadGameUsers(gameID string) (map[string]*User, error) {
+	rows, err := s.db.Query(`
+		SELECT game_users.seat, users.id, users.username FROM game_users
+		JOIN users ON users.id = game_users.user_id
+		WHERE game_users.game_id = ?
+	`, gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make(map[string]*User)
+	for rows.Next() {
+		var seat string
+		var user User
+		if err := rows.Scan(&seat, &user.ID, &user.Username); err != nil {
+			return nil, err
+		}
+		users[seat] = &user
+	}
+	return users, rows.Err()
+}
+
+// loadGames returns every persisted game state keyed by game ID.
+func (s *Server) loadGames() (map[string]*GameState, error) {
+	rows, err := s.db.Query(`SELECT id, state FROM games`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string]*GameState)
+	for rows.Next() {
+		var id, stateJSON string
+		if err := rows.Scan(&id, &stateJSON); err != nil {
+			return nil, err
+		}
+		var gameState GameState
+		if err := json.Unmarshal([]byte(stateJSON), &gameState); err != nil {
+			log.Printf("loadGames: skipping game %s with unreadable state: %v", id, err)
+			continue
+		}
+		states[id] = &gameState
+	}
+	return states, rows.Err()
+}
+
 func (s *Server) deleteGame(gameID string) {
+	if s.db == nil {
+		return
+	}
 	_, err := s.db.Exec(`DELETE FROM games WHERE id = ?`, gameID)
 	if err != nil {
 		log.Printf("deleteGame: failed to delete game %s: %v", gameID, err)
 	}
+	_, err = s.db.Exec(`DELETE FROM seats WHERE game_id = ?`, gameID)
+	if err != nil {
+		log.Printf("deleteGame: failed to delete seats for game %s: %v", gameID, err)
+	}
+	_, err = s.db.Exec(`DELETE FROM bots WHERE game_id = ?`, gameID)
+	if err != nil {
+		log.Printf("deleteGame: failed to delete bot for game %s: %v", gameID, err)
+	}
+	_, err = s.db.Exec(`DELETE FROM game_users WHERE game_id = ?`, gameID)
+	if err != nil {
+		log.Printf("deleteGame: failed to delete users for game %s: %v", gameID, err)
+	}
+	_, err = s.db.Exec(`DELETE FROM rated_games WHERE game_id = ?`, gameID)
+	if err != nil {
+		log.Printf("deleteGame: failed to delete rated flag for game %s: %v", gameID, err)
+	}
+	_, err = s.db.Exec(`DELETE FROM game_options WHERE game_id = ?`, gameID)
+	if err != nil {
+		log.Printf("deleteGame: failed to delete options for game %s: %v", gameID, err)
+	}
+	_, err = s.db.Exec(`DELETE FROM waiting_games WHERE game_id = ?`, gameID)
+	if err != nil {
+		log.Printf("deleteGame: failed to delete waiting flag for game %s: %v", gameID, err)
+	}
+}
+
+// pruneGames removes persisted games that have not been updated within the retention window.
+func (s *Server) pruneGames(olderThan time.Duration) {
+	cutoff := time.Now().UTC().Add(-olderThan).Format("2006-01-02 15:04:05")
+	res, err := s.db.Exec(`DELETE FROM games WHERE updated_at < ?`, cutoff)
+	if err != nil {
+		log.Printf("pruneGames: failed to prune stale games: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Pruned %d stale games", n)
+	}
+	if _, err := s.db.Exec(`DELETE FROM seats WHERE game_id NOT IN (SELECT id FROM games)`); err != nil {
+		log.Printf("pruneGames: failed to prune orphaned seats: %v", err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM bots WHERE game_id NOT IN (SELECT id FROM games)`); err != nil {
+		log.Printf("pruneGames: failed to prune orphaned bots: %v", err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM game_users WHERE game_id NOT IN (SELECT id FROM games)`); err != nil {
+		log.Printf("pruneGames: failed to prune orphaned game users: %v", err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM rated_games WHERE game_id NOT IN (SELECT id FROM games)`); err != nil {
+		log.Printf("pruneGames: failed to prune orphaned rated flags: %v", err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM game_options WHERE game_id NOT IN (SELECT id FROM games)`); err != nil {
+		log.Printf("pruneGames: failed to prune orphaned options: %v", err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM waiting_games WHERE game_id NOT IN (SELECT id FROM games)`); err != nil {
+		log.Printf("pruneGames: failed to prune orphaned waiting flags: %v", err)
+	}
+	// Finished games keep their moves in the archive
+	if _, err := s.db.Exec(`DELETE FROM game_actions WHERE game_id NOT IN (SELECT id FROM games) AND game_id NOT IN (SELECT id FROM finished_games)`); err != nil {
+		log.Printf("pruneGames: failed to prune orphaned actions: %v", err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM game_positions WHERE game_id NOT IN (SELECT id FROM games) AND game_id NOT IN (SELECT id FROM finished_games)`); err != nil {
+		log.Printf("pruneGames: failed to prune orphaned start positions: %v", err)
+	}
+}
+
+// restoreGames rehydrates every persisted game into memory so players can rejoin after a restart.
+func (s *Server) restoreGames() error {
+	s.pruneGames(gameRetention)
+
+	states, err := s.loadGames()
+	if err != nil {
+		return err
+	}
+
+	s.serverMutex.Lock()
+	defer s.serverMutex.Unlock()
+	for id, gameState := range states {
+		if gameState.isOver() {
+			continue
+		}
+		if game := s.registerRestoredGame(id, gameState); game != nil {
+			log.Printf("Game restored: %s (turn %d, state %s)", id, gameState.TurnNumber, gameState.State)
+		}
+	}
+	return nil
+}
+
+// reloadGame loads a single unfinished game evicted from memory. Caller must hold serverMutex.
+func (s *Server) reloadGame(gameID string) *Game {
+	if s.db == nil {
+		return nil
+	}
+	gameState, err := s.loadGame(gameID)
+	if err != nil || gameState.isOver() {
+		return nil
+	}
+	game := s.registerRestoredGame(gameID, gameState)
+	if game != nil {
+		log.Printf("Game reloaded from database: %s", gameID)
+	}
+	return game
+}
+
+// registerRestoredGame adds a persisted game to Server.games with no seated players, holds
+// its seats for the reconnect grace period and starts its writePump. A game that was still
+// waiting for its second player is listed again. Caller must hold serverMutex.
+func (s *Server) registerRestoredGame(gameID string, gameState *GameState) *Game {
+	tokens, err := s.loadSeatTokens(gameID)
+	if err != nil || len(tokens) == 0 {
+		log.Printf("Skipping game %s: no seat tokens (%v)", gameID, err)
+		return nil
+	}
+
+	game := NewGame()
+	game.ID = gameID
+	bot, err := s.loadBot(gameID)
+	if err != nil {
+		log.Printf("Skipping game %s: failed to load bot: %v", gameID, err)
+		return nil
+	}
+	users, err := s.loadGameUsers(gameID)
+	if err != nil {
+		log.Printf("Skipping game %s: failed to load users: %v", gameID, err)
+		return nil
+	}
+	actions, err := s.loadActions(gameID)
+	if err != nil {
+		log.Printf("Skipping game %s: failed to load moves: %v", gameID, err)
+		return nil
+	}
+	position, err := s.loadStartPosition(gameID)
+	if err != nil {
+		log.Printf("Skipping game %s: failed to load start position: %v", gameID, err)
+		return nil
+	}
+	rated, err := s.loadRated(gameID)
+	if err != nil {
+		log.Printf("Skipping game %s: failed to load rated flag: %v", gameID, err)
+		return nil
+	}
+	stored, err := s.loadGameOptions(gameID)
+	if err != nil {
+		log.Printf("Skipping game %s: failed to load options: %v", gameID, err)
+		return nil
+	}
+	waiting, err := s.loadWaiting(gameID)
+	if err != nil {
+		log.Printf("Skipping game %s: failed to load waiting flag: %v", gameID, err)
+		return nil
+	}
+	history, err := replayHistory(position, gameState, actions)
+	if err != nil {
+		// The game can still be played, just not taken back past the restart
+		log.Printf("Game %s restored without its takeback history: %v", gameID, err)
+	}
+
+	gameState.Spectators = 0
+	game.GameState = gameState
+	game.tokens = tokens
+	game.bot = bot
+	game.users = users
+	game.actions = actions
+	game.actionsSaved = len(actions)
+	game.history = history
+	game.options = stored.GameOptions
+	game.options.Position = position
+	game.options.Rules = gameState.Rules
+	game.options.Rated = rated
+	game.inviteCode = stored.inviteCode
+	game.createdAt = stored.createdAt
+	if gameState.Clock != nil {
+		// Time the server was down isn't charged; the clock stays stopped until the
+		// player to move is back (see resumeAfterRejoin)
+		game.clock = restoreGameClock(gameState.Clock)
+		tc := gameState.Clock.TimeControl
+		game.options.TimeControl = &tc
+	} else if game.options.TimeControl != nil {
+		// Never broadcast with a clock: the game was still waiting for its second player
+		game.clock = newGameClock(*game.options.TimeControl)
+	}
+	s.games[gameID] = game
+	if waiting {
+		s.addWaitingGame(game)
+	}
+
+	// Nobody is connected yet, so every human seat is held as if its player had just
+	// dropped: a game nobody comes back to is evicted once the grace period runs out.
+	// A waiting game's second seat is still open rather than held.
+	for seat := range tokens {
+		seat := seat
+		if game.bot != nil && game.bot.Seat == seat || waiting && seat == "player2" {
+			continue
+		}
+		game.graceTimers[seat] = time.AfterFunc(s.reconnectGrace, func() {
+			s.abandonSeat(game, seat)
+		})
+	}
+
+	var wpWg sync.WaitGroup
+	wpWg.Add(1)
+	go game.writePump(s, &wpWg)
+	if game.bot != nil {
+		// Woken by resumeAfterRejoin once the human is back
+		go game.botPump(s)
+	}
+	return game
 }
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// --- Helpers ---
+
+func newTestServerWithDB(t *testing.T) *Server {
+	t.Helper()
+	db, err := initDB(filepath.Join(t.TempDir(), "games.db"))
+	if err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	s := NewServer()
+	s.db = db
+	return s
+}
+
+// --- Persistence ---
+
+func TestSaveAndLoadGame_RoundTrip(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := NewGame()
+	place(game.GameState, P1Kitten, 2, 3)
+	game.GameState.TurnNumber = 1
+
+	s.saveGame(game)
+
+	loaded, err := s.loadGame(game.ID)
+	if err != nil {
+		t.Fatalf("loadGame: %v", err)
+	}
+	if loaded.Board != game.GameState.Board {
+		t.Error("expected loaded board to match saved board")
+	}
+	if loaded.TurnNumber != 1 || loaded.P1.Placed != 1 {
+		t.Errorf("expected turn 1 with 1 P1 piece placed, got turn %d placed %d", loaded.TurnNumber, loaded.P1.Placed)
+	}
+}
+
+func TestSaveGame_Upserts(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := NewGame()
+	s.saveGame(game)
+	game.GameState.TurnNumber = 5
+	s.saveGame(game)
+
+	states, err := s.loadGames()
+	if err != nil {
+		t.Fatalf("loadGames: %v", err)
+	}
+	if len(states) != 1 {
+		t.Fatalf("expected 1 persisted game, got %d", len(states))
+	}
+	if states[game.ID].TurnNumber != 5 {
+		t.Errorf("expected latest save to win, got turn %d", states[game.ID].TurnNumber)
+	}
+}
+
+// A game's seats, bot and options are written when it is created, before any move is saved.
+func TestSaveNewGame_WritesSetup(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game, err := s.createBotGame(nil, BotGreedy, GameOptions{Position: "6/6/6/6/6/6 8,0 8,0 1 0 -"})
+	if err != nil {
+		t.Fatalf("createBotGame: %v", err)
+	}
+	defer s.evictGame(game)
+
+	if _, err := s.loadGame(game.ID); err != nil {
+		t.Errorf("expected the new game to be persisted: %v", err)
+	}
+	if tokens, err := s.loadSeatTokens(game.ID); err != nil || tokens["player1"] != game.tokens["player1"] || tokens["player2"] != game.tokens["player2"] {
+		t.Errorf("expected both seat tokens to be persisted, got %+v (%v)", tokens, err)
+	}
+	if bot, err := s.loadBot(game.ID); err != nil || bot == nil || bot.Seat != "player2" || bot.Level != BotGreedy {
+		t.Errorf("expected the bot to be persisted, got %+v (%v)", bot, err)
+	}
+	if position, err := s.loadStartPosition(game.ID); err != nil || position != "6/6/6/6/6/6 8,0 8,0 1 0 -" {
+		t.Errorf("expected the start position to be persisted, got %q (%v)", position, err)
+	}
+}
+
+// A waiting game its creator leaves is gone for good; there is no seat to come back to.
+func TestHandlePlayerDisconnect_DeletesAbandonedWaitingGame(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := s.createGame(nil, GameOptions{}, nil)
+	s.handlePlayerDisconnect(game.ID, "player1", nil)
+
+	if _, err := s.loadGame(game.ID); err == nil {
+		t.Error("expected the abandoned waiting game to be deleted")
+	}
+}
+
+// Restored games are registered without players; finished games are skipped.
+func TestRestoreGames(t *testing.T) {
+	s := newTestServerWithDB(t)
+	live := NewGame()
+	finished := NewGame()
+	finished.GameState.Winner = 2
+	s.saveNewGame(live)
+	s.saveNewGame(finished)
+
+	if err := s.restoreGames(); err != nil {
+		t.Fatalf("restoreGames: %v", err)
+	}
+	defer func() {
+		for _, g := range s.games {
+			g.shutdown()
+		}
+	}()
+
+	game, ok := s.games[live.ID]
+	if !ok {
+		t.Fatal("expected unfinished game to be restored")
+	}
+	if len(game.Players) != 0 {
+		t.Error("expected restored game with no seated players")
+	}
+	if game.tokens["player1"] != live.tokens["player1"] || game.tokens["player2"] != live.tokens["player2"] {
+		t.Error("expected restored game to keep its seat tokens")
+	}
+	if _, ok := s.games[finished.ID]; ok {
+		t.Error("expected finished game to not be restored")
+	}
+	if _, ok := s.waitingGames[live.ID]; ok {
+		t.Error("expected restored game to not be listed as waiting")
+	}
+}
+
+// A waiting game comes back listed, with the creator's options and invite code, and only
+// the creator's seat held.
+func TestRestoreGames_WaitingGameListedAgain(t *testing.T) {
+	s := newTestServerWithDB(t)
+	opts := GameOptions{
+		TimeControl: &TimeControl{InitialMs: 180_000},
+		First:       FirstOpponent,
+		Visibility:  VisibilityUnlisted,
+		Passphrase:  "whiskers",
+		Name:        "Mochi",
+		Variant:     VariantStandard,
+	}
+	created := s.createGame(nil, opts, nil)
+	created.shutdown()
+
+	restarted := NewServer()
+	restarted.db = s.db
+	if err := restarted.restoreGames(); err != nil {
+		t.Fatalf("restoreGames: %v", err)
+	}
+	game := restarted.games[created.ID]
+	if game == nil {
+		t.Fatal("expected the waiting game to be restored")
+	}
+	defer game.shutdown()
+	if _, waiting := restarted.waitingGames[game.ID]; !waiting {
+		t.Error("expected the restored game to be waiting again")
+	}
+	if game.inviteCode != created.inviteCode || game.options.Visibility != VisibilityUnlisted || game.listed() {
+		t.Errorf("expected the game to stay unlisted with its invite code, got %q (%s)", game.inviteCode, game.options.Visibility)
+	}
+	if game.options.First != FirstOpponent || game.options.Passphrase != "whiskers" || game.options.Name != "Mochi" {
+		t.Errorf("expected the creator's options to be restored, got %+v", game.options)
+	}
+	if tc := game.options.TimeControl; tc == nil || *tc != *opts.TimeControl || game.clock == nil {
+		t.Errorf("expected the time control to be restored, got %+v", tc)
+	}
+	if !game.createdAt.Equal(created.createdAt) {
+		t.Errorf("expected the creation time to be kept, got %v want %v", game.createdAt, created.createdAt)
+	}
+	if game.graceTimers["player1"] == nil || game.graceTimers["player2"] != nil {
+		t.Error("expected only the creator's seat to be held")
+	}
+
+	if _, err := restarted.joinGame(nil, game.ID, "", "whiskers", nil); err == nil {
+		t.Error("expected the invite code to still be needed")
+	}
+	if _, err := restarted.joinGame(nil, game.ID, created.inviteCode, "whiskers", nil); err != nil {
+		t.Fatalf("joinGame: %v", err)
+	}
+	if _, seated := game.Players["player2"]; !seated {
+		t.Error("expected the joining player to take the second seat")
+	}
+	if waiting, err := s.loadWaiting(game.ID); err != nil || waiting {
+		t.Errorf("expected the joined game to no longer be stored as waiting (%v)", err)
+	}
+}
+
+// The creator of a restored waiting game can take their seat back; the open seat's token can't.
+func TestRestoreGames_CreatorRejoinsWaitingGame(t *testing.T) {
+	s := newTestServerWithDB(t)
+	created := s.createGame(nil, GameOptions{}, nil)
+	created.shutdown()
+
+	restarted := NewServer()
+	restarted.db = s.db
+	if err := restarted.restoreGames(); err != nil {
+		t.Fatalf("restoreGames: %v", err)
+	}
+	defer restarted.games[created.ID].shutdown()
+
+	if game, _ := restarted.rejoinGame(nil, created.ID, created.tokens["player2"]); game != nil {
+		t.Error("expected the open seat's token to be refused")
+	}
+	game, seat := restarted.rejoinGame(nil, created.ID, created.tokens["player1"])
+	if game == nil || seat != "player1" {
+		t.Fatalf("expected the creator to rejoin, got seat %q", seat)
+	}
+	if _, waiting := restarted.waitingGames[game.ID]; !waiting {
+		t.Error("expected the game to keep waiting for its second player")
+	}
+}
+
+// Restored seats are held like dropped ones, so a game nobody rejoins is evicted.
+func TestRestoreGames_EvictsUnclaimedGame(t *testing.T) {
+	s := newTestServerWithDB(t)
+	s.reconnectGrace = 10 * time.Millisecond
+	saved := newSeatedGame(s)
+	s.saveNewGame(saved)
+	delete(s.games, saved.ID)
+
+	if err := s.restoreGames(); err != nil {
+		t.Fatalf("restoreGames: %v", err)
+	}
+	s.serverMutex.Lock()
+	game := s.games[saved.ID]
+	held := game != nil && game.graceTimers["player1"] != nil && game.graceTimers["player2"] != nil
+	s.serverMutex.Unlock()
+	if !held {
+		t.Fatal("expected both seats of the restored game to be held")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	s.serverMutex.Lock()
+	_, exists := s.games[saved.ID]
+	s.serverMutex.Unlock()
+	if exists {
+		t.Error("expected a restored game nobody rejoined to be evicted")
+	}
+}
+
+// A timed game's clock stays stopped after a restart until the player to move is back.
+func TestRestoreGames_ClockWaitsForMover(t *testing.T) {
+	s := newTestServerWithDB(t)
+	saved := newTimedGame(s, TimeControl{InitialMs: 60_000})
+	saved.broadcastGameState()
+	s.saveNewGame(saved)
+	saved.stopClock()
+	delete(s.games, saved.ID)
+
+	if err := s.restoreGames(); err != nil {
+		t.Fatalf("restoreGames: %v", err)
+	}
+	game := s.games[saved.ID]
+	defer s.evictGame(game)
+	if game.clock.running != "" {
+		t.Fatalf("expected the restored clock to be stopped, got %q running", game.clock.running)
+	}
+	if tc := game.options.TimeControl; tc == nil || *tc != (TimeControl{InitialMs: 60_000}) {
+		t.Errorf("expected the time control to be restored from the clock, got %+v", tc)
+	}
+
+	mover := moverSeat(game.GameState)
+	other := otherSeat(mover)
+	s.rejoinGame(nil, game.ID, game.tokens[other])
+	game.resumeAfterRejoin(s)
+	if game.clock.running != "" {
+		t.Errorf("expected the clock to stay stopped until %s is back, got %q running", mover, game.clock.running)
+	}
+	s.rejoinGame(nil, game.ID, game.tokens[mover])
+	game.resumeAfterRejoin(s)
+	if game.clock.running != mover {
+		t.Errorf("expected %s's clock to run once they rejoin, got %q", mover, game.clock.running)
+	}
+}
+
+func TestDeleteGame(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := NewGame()
+	s.saveGame(game)
+	s.deleteGame(game.ID)
+
+	if _, err := s.loadGame(game.ID); err == nil {
+		t.Error("expected deleted game to be gone")
+	}
+}
+
+// Without a database, persistence calls are no-ops.
+func TestSaveGame_NoDB(t *testing.T) {
+	s := NewServer()
+	s.saveGame(NewGame())
+	s.deleteGame("ANY")
+}
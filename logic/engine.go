@@ -0,0 +1,104 @@
+package main
+
+// EventType names something that happened while applying an action.
+type EventType string
+
+const (
+	EventPlaced       EventType = "placed"       // Position, Tile
+	EventBoopMovement EventType = "boopMovement" // Position → FinalPosition, Tile
+	EventBoopedOff    EventType = "boopedOff"    // Position, Tile, Direction it left in
+	EventLine         EventType = "line"         // Line formed by the mover
+	EventGraduated    EventType = "graduated"    // Line (1 or 3 positions) returned to Player as cats
+	EventWinner       EventType = "winner"       // Player
+)
+
+type Event struct {
+	Type          EventType  `json:"type"`
+	Position      *Position  `json:"position,omitempty"`
+	FinalPosition *Position  `json:"finalPosition,omitempty"`
+	Direction     *Direction `json:"direction,omitempty"`
+	Tile          uint8      `json:"tile,omitempty"`
+	Line          []Position `json:"line,omitempty"`
+	Player        uint8      `json:"player,omitempty"`
+}
+
+// Apply is the engine's transition function. It returns the state after the player to
+// move takes action, plus what happened along the way, or an error if action is not in
+// LegalMoves(state). state is never modified, nothing is logged and no globals are
+// written, so states can be freely copied for search, undo and replay.
+//
+// The turn advances (TurnNumber++) whenever the result is back in WAITING; a placement
+// that leaves a selection pending keeps the same player on move. A win on the board ends
+// in GAME_OVER with the turn unchanged.
+func Apply(state GameState, action Action) (GameState, []Event, error) {
+	if err := state.checkLegal(action); err != nil {
+		return state, nil, err
+	}
+	return apply(state, action)
+}
+
+// apply is Apply without the legality check, for callers that took action from LegalMoves.
+// It relies on the engine only ever replacing GameState's slices, never writing through
+// them, so the shallow copy made by passing state by value is safe.
+func apply(state GameState, action Action) (GameState, []Event, error) {
+	next := state
+	mover := moverNumber(&next)
+	var events []Event
+
+	switch action.Type {
+	case ActionPlace:
+		kittenOrCat := int64(0)
+		if isCat(action.Piece) {
+			kittenOrCat = 1
+		}
+		if err := next.placePiece(action.Position, kittenOrCat); err != nil {
+			return state, nil, err
+		}
+		placed := next.Placed
+		events = append(events, Event{Type: EventPlaced, Position: &placed.Position, Tile: placed.Piece})
+		for _, m := range next.BoopMovement {
+			m := m
+			events = append(events, Event{Type: EventBoopMovement, Position: &m.Position, FinalPosition: &m.FinalPosition, Tile: m.Tile})
+		}
+		for _, b := range next.Booped {
+			b := b
+			events = append(events, Event{Type: EventBoopedOff, Position: &b.Position, Direction: &b.Direction, Tile: b.Tile})
+		}
+		for _, line := range next.Lines {
+			events = append(events, Event{Type: EventLine, Line: line, Player: mover})
+		}
+		// A single line graduates as part of the placement
+		if len(next.Lines) == 1 {
+			events = append(events, Event{Type: EventGraduated, Line: next.Lines[0], Player: mover})
+		}
+
+	case ActionGraduateLine:
+		if err := next.graduateLine(action.Position); err != nil {
+			return state, nil, err
+		}
+		events = append(events, Event{Type: EventGraduated, Line: next.GraduatedLine, Player: mover})
+
+	case ActionGraduatePiece:
+		if err := next.graduateSinglePiece(action.Position); err != nil {
+			return state, nil, err
+		}
+		events = append(events, Event{Type: EventGraduated, Line: next.GraduatedLine, Player: mover})
+	}
+
+	if next.Winner != 0 && state.Winner == 0 {
+		events = append(events, Event{Type: EventWinner, Player: next.Winner})
+		next.finish(next.Winner, next.Result)
+	}
+	if next.State == "WAITING" {
+		next.TurnNumber++
+	}
+	return next, events, nil
+}
+
+// moverNumber is 1 or 2 for the player who acts next in gs.
+func moverNumber(gs *GameState) uint8 {
+	if gs.isPlayer1() {
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// --- Helpers ---
+
+func eventsOfType(events []Event, eventType EventType) []Event {
+	var matching []Event
+	for _, e := range events {
+		if e.Type == eventType {
+			matching = append(matching, e)
+		}
+	}
+	return matching
+}
+
+// --- Apply ---
+
+func TestApply_DoesNotModifyInput(t *testing.T) {
+	gs := newP1Turn()
+	place(gs, P2Kitten, 3, 3)
+	place(gs, P2Kitten, 5, 0)
+	before := *gs
+
+	_, _, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 4, Y: 1}, Piece: P1Kitten})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(*gs, before) {
+		t.Error("expected Apply to leave its input state untouched")
+	}
+}
+
+func TestApply_Deterministic(t *testing.T) {
+	gs := newP1Turn()
+	place(gs, P2Kitten, 2, 2)
+	place(gs, P2Kitten, 4, 4)
+	place(gs, P2Kitten, 2, 4)
+	action := Action{Type: ActionPlace, Position: Position{X: 3, Y: 3}, Piece: P1Kitten}
+
+	first, firstEvents, _ := Apply(*gs, action)
+	for i := 0; i < 20; i++ {
+		again, againEvents, _ := Apply(*gs, action)
+		if !reflect.DeepEqual(first, again) || !reflect.DeepEqual(firstEvents, againEvents) {
+			t.Fatal("expected identical results for identical input")
+		}
+	}
+}
+
+func TestApply_RejectsIllegalAction(t *testing.T) {
+	gs := newP1Turn()
+	place(gs, P2Kitten, 3, 3)
+	_, _, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 3, Y: 3}, Piece: P1Kitten})
+	if err == nil {
+		t.Error("expected error placing on an occupied square")
+	}
+}
+
+func TestApply_AdvancesTurn(t *testing.T) {
+	gs := newP1Turn()
+	next, _, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 0, Y: 0}, Piece: P1Kitten})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next.TurnNumber != 1 || next.isPlayer1() {
+		t.Errorf("expected P2 to move next, got turn %d", next.TurnNumber)
+	}
+}
+
+func TestApply_BoopEvents(t *testing.T) {
+	gs := newP1Turn()
+	place(gs, P2Kitten, 3, 3) // slides right to (4,3)
+	place(gs, P2Kitten, 2, 4) // slides down to (2,5)
+	place(gs, P2Kitten, 1, 2) // slides up-left to (0,1)
+	place(gs, P2Kitten, 3, 2) // slides up-right to (4,1)
+	place(gs, P2Kitten, 5, 5) // not adjacent
+
+	next, events, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 2, Y: 3}, Piece: P1Kitten})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	placed := eventsOfType(events, EventPlaced)
+	if len(placed) != 1 || *placed[0].Position != (Position{X: 2, Y: 3}) || placed[0].Tile != P1Kitten {
+		t.Errorf("expected one placed event for P1 kitten at (2,3), got %+v", placed)
+	}
+	moves := eventsOfType(events, EventBoopMovement)
+	if len(moves) != len(next.BoopMovement) || len(moves) != 4 {
+		t.Fatalf("expected 4 boop movement events, got %d", len(moves))
+	}
+	seen := map[Position]bool{}
+	for _, m := range moves {
+		seen[*m.FinalPosition] = true
+	}
+	for _, want := range []Position{{4, 3}, {2, 5}, {0, 1}, {4, 1}} {
+		if !seen[want] {
+			t.Errorf("expected a boop movement ending at %v", want)
+		}
+	}
+}
+
+func TestApply_BoopOffEvent(t *testing.T) {
+	gs := newP1Turn()
+	place(gs, P2Kitten, 5, 3)
+
+	_, events, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 4, Y: 3}, Piece: P1Kitten})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	off := eventsOfType(events, EventBoopedOff)
+	if len(off) != 1 || *off[0].Position != (Position{X: 5, Y: 3}) || *off[0].Direction != (Direction{X: 1, Y: 0}) {
+		t.Errorf("expected P2 kitten booped off to the right, got %+v", off)
+	}
+}
+
+// A single line graduates with the placement and emits line + graduated events.
+func TestApply_SingleLineGraduates(t *testing.T) {
+	gs := newP1Turn()
+	place(gs, P1Kitten, 0, 0)
+	place(gs, P1Kitten, 1, 0)
+
+	next, events, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 2, Y: 0}, Piece: P1Kitten})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(eventsOfType(events, EventLine)) != 1 || len(eventsOfType(events, EventGraduated)) != 1 {
+		t.Errorf("expected one line and one graduation event, got %+v", events)
+	}
+	if next.P1.Cats != 3 || next.State != "WAITING" {
+		t.Errorf("expected 3 cats and WAITING, got %d cats in %s", next.P1.Cats, next.State)
+	}
+}
+
+// Two lines leave the mover to choose; the turn doesn't advance until they do.
+func TestApply_MultipleLinesThenSelection(t *testing.T) {
+	gs := newP1Turn()
+	// Boops on (1,2) and (2,1) are blocked, completing a row and a column at (2,2)
+	place(gs, P1Kitten, 0, 2)
+	place(gs, P1Kitten, 1, 2)
+	place(gs, P1Kitten, 2, 0)
+	place(gs, P1Kitten, 2, 1)
+
+	pending, events, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 2, Y: 2}, Piece: P1Kitten})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pending.State != "MULTIPLE_WAITING" || pending.TurnNumber != 0 {
+		t.Fatalf("expected MULTIPLE_WAITING on turn 0, got %s on turn %d", pending.State, pending.TurnNumber)
+	}
+	if len(eventsOfType(events, EventLine)) != 2 || len(eventsOfType(events, EventGraduated)) != 0 {
+		t.Errorf("expected two line events and no graduation yet, got %+v", events)
+	}
+
+	done, events, err := Apply(pending, Action{Type: ActionGraduateLine, Position: Position{X: 1, Y: 2}})
+	if err != nil {
+		t.Fatalf("unexpected error selecting line: %v", err)
+	}
+	graduated := eventsOfType(events, EventGraduated)
+	if len(graduated) != 1 || len(graduated[0].Line) != 3 || graduated[0].Player != 1 {
+		t.Errorf("expected one 3-piece graduation for P1, got %+v", graduated)
+	}
+	if done.State != "WAITING" || done.TurnNumber != 1 {
+		t.Errorf("expected WAITING on turn 1 after selection, got %s on turn %d", done.State, done.TurnNumber)
+	}
+}
+
+func TestApply_WinnerEvent(t *testing.T) {
+	gs := newP1Turn()
+	gs.P1 = Player{Kittens: 5, Cats: 1, Placed: 2}
+	gs.Board[0][0] = P1Cat
+	gs.Board[0][1] = P1Cat
+
+	next, events, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 2, Y: 0}, Piece: P1Cat})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	winners := eventsOfType(events, EventWinner)
+	if next.Winner != 1 || len(winners) != 1 || winners[0].Player != 1 {
+		t.Errorf("expected P1 win event, got winner %d and %+v", next.Winner, winners)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Why a game ended, carried in GameState.Result once State is GAME_OVER.
+const (
+	ResultThreeCats   = "threeCats"   // three cats in a row
+	ResultLine        = "line"        // a longer run of cats, under a ruleset with winLength above 3
+	ResultEightCats   = "eightCats"   // every piece on the board as a cat
+	ResultResignation = "resignation" // loser resigned
+	ResultTimeout     = "timeout"     // loser's clock ran out
+	ResultAbandonment = "abandonment" // loser didn't reconnect in time
+	ResultDraw        = "draw"        // agreed draw; Winner is 0
+)
+
+// DrawNotice is the payload of drawOffered / drawDeclined.
+type DrawNotice struct {
+	Seat string `json:"seat"` // seat that offered the draw
+}
+
+// isOver reports whether the game has finished. Games persisted before GAME_OVER existed
+// only have a winner.
+func (gs *GameState) isOver() bool {
+	return gs.State == "GAME_OVER" || gs.Winner != 0
+}
+
+// finish marks gs as over. The engine uses it for wins on the board; the server for everything else.
+func (gs *GameState) finish(winner uint8, result string) {
+	gs.Winner = winner
+	gs.Result = result
+	gs.State = "GAME_OVER"
+}
+
+// endGame finishes the game off the board and drops any pending requests. Caller must hold game.mutex.
+func (game *Game) endGame(winner uint8, result string) {
+	game.GameState.finish(winner, result)
+	game.takebackFrom = ""
+	game.drawOfferFrom = ""
+}
+
+// resign ends the game with the other seat as the winner.
+func (game *Game) resign(s *Server, playerID string) error {
+	game.mutex.Lock()
+	if game.GameState.isOver() {
+		game.mutex.Unlock()
+		return &ProtocolError{Code: CodeGameOver, Message: "Game is already over"}
+	}
+	game.endGame(seatNumber(otherSeat(playerID)), ResultResignation)
+	game.mutex.Unlock()
+
+	log.Printf("Player %s resigned game %s", playerID, game.ID)
+	game.commitTurn(s)
+	return nil
+}
+
+// offerDraw records seat's offer and asks the other seat. Offering while the opponent's
+// offer is pending accepts it. A bot opponent declines.
+func (game *Game) offerDraw(s *Server, seat string) error {
+	game.mutex.Lock()
+	if game.GameState.isOver() {
+		game.mutex.Unlock()
+		return fmt.Errorf("game is over")
+	}
+	switch game.drawOfferFrom {
+	case otherSeat(seat):
+		game.mutex.Unlock()
+		return game.answerDraw(s, seat, true)
+	case seat:
+		game.mutex.Unlock()
+		return fmt.Errorf("draw already offered")
+	}
+	game.drawOfferFrom = seat
+	game.mutex.Unlock()
+
+	log.Printf("Player %s offered a draw in game %s", seat, game.ID)
+	if game.bot != nil {
+		return game.answerDraw(s, game.bot.Seat, false)
+	}
+	game.sendDrawNotice("drawOffered", otherSeat(seat), seat)
+	return nil
+}
+
+// answerDraw resolves a pending offer. seat must be the opponent of the one who offered.
+func (game *Game) answerDraw(s *Server, seat string, accept bool) error {
+	game.mutex.Lock()
+	offeredBy := game.drawOfferFrom
+	if offeredBy == "" || offeredBy == seat || game.GameState.isOver() {
+		game.mutex.Unlock()
+		return fmt.Errorf("no draw offer to answer")
+	}
+	game.drawOfferFrom = ""
+	if !accept {
+		game.mutex.Unlock()
+		game.sendDrawNotice("drawDeclined", offeredBy, offeredBy)
+		return nil
+	}
+	game.endGame(0, ResultDraw)
+	game.mutex.Unlock()
+
+	log.Printf("Game %s drawn by agreement", game.ID)
+	game.commitTurn(s)
+	return nil
+}
+
+// sendDrawNotice sends msgType privately to seat to; the payload names the seat that offered.
+func (game *Game) sendDrawNotice(msgType string, to string, offeredBy string) {
+	msg := Message{
+		Type:     msgType,
+		GameID:   game.ID,
+		PlayerID: to,
+		State:    game.GameState.State,
+		Payload:  DrawNotice{Seat: offeredBy},
+		To:       to,
+	}
+	select {
+	case game.send <- msg:
+	default:
+		log.Printf("Send channel full, dropping %s for game %s", msgType, game.ID)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import "testing"
+
+// --- Wins on the board ---
+
+func TestGameOver_ThreeCatsEndsGame(t *testing.T) {
+	gs := newP1Turn()
+	gs.P1.Cats = 3
+	// The boop on (1,0) is blocked by (0,0), completing a row of cats
+	place(gs, P1Cat, 0, 0)
+	place(gs, P1Cat, 1, 0)
+
+	next, _, err := Apply(*gs, placeAction(P1Cat, 2, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next.State != "GAME_OVER" || next.Winner != 1 || next.Result != ResultThreeCats {
+		t.Errorf("expected GAME_OVER won by P1 with three cats, got %s winner %d result %q", next.State, next.Winner, next.Result)
+	}
+	if next.TurnNumber != 0 {
+		t.Errorf("expected the turn not to advance on a win, got %d", next.TurnNumber)
+	}
+	if len(LegalMoves(&next)) != 0 {
+		t.Error("expected no legal moves once the game is over")
+	}
+}
+
+func TestGameOver_MovesRejectedAfterEnd(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	game.resign(s, "player2")
+
+	err := game.handleFrame(s, nil, "player1", Inbound{Type: frameMove, Piece: "0"})
+	expectCode(t, err, CodeGameOver)
+	if err := game.handleFrame(s, nil, "player1", Inbound{Type: frameChat, Text: "gg"}); err != nil {
+		t.Errorf("expected chat to stay open after the game ends, got %v", err)
+	}
+}
+
+// --- Resignation ---
+
+func TestGameOver_Resignation(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	if err := game.resign(s, "player1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	gs := game.GameState
+	if gs.State != "GAME_OVER" || gs.Winner != 2 || gs.Result != ResultResignation {
+		t.Errorf("expected player2 to win by resignation, got %s winner %d result %q", gs.State, gs.Winner, gs.Result)
+	}
+}
+
+// --- Draws ---
+
+func TestDraw_AcceptedEndsGameWithoutWinner(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	if err := game.offerDraw(s, "player1"); err != nil {
+		t.Fatalf("unexpected error offering draw: %v", err)
+	}
+	msg := <-game.send
+	if msg.Type != "drawOffered" || msg.To != "player2" {
+		t.Errorf("expected drawOffered addressed to player2, got %+v", msg)
+	}
+	if err := game.answerDraw(s, "player1", true); err == nil {
+		t.Error("expected the offering seat to be unable to accept its own offer")
+	}
+	if err := game.answerDraw(s, "player2", true); err != nil {
+		t.Fatalf("unexpected error accepting draw: %v", err)
+	}
+	gs := game.GameState
+	if gs.State != "GAME_OVER" || gs.Winner != 0 || gs.Result != ResultDraw {
+		t.Errorf("expected an agreed draw, got %s winner %d result %q", gs.State, gs.Winner, gs.Result)
+	}
+}
+
+func TestDraw_DeclinedNotifiesOfferer(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	game.offerDraw(s, "player1")
+	<-game.send
+
+	if err := game.answerDraw(s, "player2", false); err != nil {
+		t.Fatalf("unexpected error declining draw: %v", err)
+	}
+	msg := <-game.send
+	if msg.Type != "drawDeclined" || msg.To != "player1" {
+		t.Errorf("expected drawDeclined addressed to player1, got %+v", msg)
+	}
+	if game.GameState.isOver() || game.drawOfferFrom != "" {
+		t.Error("expected the game to continue with no offer pending")
+	}
+}
+
+func TestDraw_CrossingOffersAgree(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	game.offerDraw(s, "player1")
+	if err := game.offerDraw(s, "player2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if game.GameState.Result != ResultDraw {
+		t.Errorf("expected a counter-offer to accept the draw, got result %q", game.GameState.Result)
+	}
+}
+
+func TestDraw_OfferStandsUntilOpponentMoves(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	game.offerDraw(s, "player1")
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	if game.drawOfferFrom != "player1" {
+		t.Fatal("expected the offer to survive the offering seat's own move")
+	}
+	playAction(t, game, placeAction(P2Kitten, 5, 5))
+	if game.drawOfferFrom != "" {
+		t.Error("expected the offer to lapse once the opponent moved")
+	}
+}
+
+func TestDraw_BotDeclines(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	game.bot = newBot(BotRandom, "player2")
+	if err := game.offerDraw(s, "player1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if game.GameState.isOver() || game.drawOfferFrom != "" {
+		t.Error("expected the bot to decline straight away")
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	VariantStandard = "standard" // StandardRules
+	VariantCustom   = "custom"   // any other Ruleset
+	defaultName     = "Anonymous"
+	maxNameLength   = 24
+	lobbyFeedBuffer = 32               // per-subscriber queue; a subscriber that falls this far behind is dropped
+	lobbyKeepAlive  = 30 * time.Second // comment line so proxies don't time out an idle feed
+)
+
+// LobbyGame is a waiting game as listed by /lobby and the lobby feed.
+type LobbyGame struct {
+	ID          string       `json:"id"`
+	Creator     string       `json:"creator"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	TimeControl *TimeControl `json:"timeControl,omitempty"` // omitted for an untimed game
+	Variant     string       `json:"variant"`
+	Rated       bool         `json:"rated"`
+	First       string       `json:"first"`              // the creator's first-mover choice
+	Passphrase  bool         `json:"passphrase"`         // joining needs a passphrase
+	Position    string       `json:"position,omitempty"` // starting position, if not the empty board
+	Rules       *Ruleset     `json:"rules,omitempty"`    // omitted for the standard rules
+}
+
+// LobbyRemoved is the data of a "removed" feed event.
+type LobbyRemoved struct {
+	ID string `json:"id"`
+}
+
+type lobbyEvent struct {
+	name string // "added" or "removed"
+	game LobbyGame
+}
+
+// lobbyFilter is what a client asked to see with /lobby query parameters.
+type lobbyFilter struct {
+	rated   *bool
+	timed   *bool
+	variant string
+	creator string // case-insensitive substring
+	sort    string // "newest" (default), "oldest" or "time" (shortest first, untimed last)
+}
+
+// lobbyFeed fans waiting games appearing and disappearing out to /lobby/feed subscribers.
+type lobbyFeed struct {
+	mutex       sync.Mutex
+	subscribers map[chan lobbyEvent]struct{}
+}
+
+// parseLobbyOptions reads the options shown in the lobby listing.
+func parseLobbyOptions(query url.Values, opts *GameOptions) error {
+	name := strings.TrimSpace(query.Get("name"))
+	if name == "" {
+		name = defaultName
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return fmt.Errorf("name is longer than %d characters", maxNameLength)
+	}
+	opts.Name = name
+
+	rules, err := parseRuleset(query)
+	if err != nil {
+		return err
+	}
+	switch variant := query.Get("variant"); variant {
+	case "":
+		opts.Variant = variantOf(&rules)
+	case VariantStandard:
+		if rules != StandardRules {
+			return fmt.Errorf("the standard variant can't change the rules")
+		}
+		opts.Variant = VariantStandard
+	default:
+		return fmt.Errorf("unknown variant %q", variant)
+	}
+	if opts.Variant == VariantCustom {
+		opts.Rules = &rules
+	}
+
+	if rated := query.Get("rated"); rated != "" {
+		r, err := strconv.ParseBool(rated)
+		if err != nil {
+			return fmt.Errorf("invalid rated flag %q", rated)
+		}
+		opts.Rated = r
+	}
+	if opts.Rated && opts.Rules != nil {
+		return fmt.Errorf("rated games use the standard rules")
+	}
+	return nil
+}
+
+func parseLobbyFilter(query url.Values) (lobbyFilter, error) {
+	var filter lobbyFilter
+	for _, param := range []struct {
+		name string
+		dest **bool
+	}{{"rated", &filter.rated}, {"timed", &filter.timed}} {
+		if value := query.Get(param.name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s filter %q", param.name, value)
+			}
+			*param.dest = &b
+		}
+	}
+	filter.variant = query.Get("variant")
+	filter.creator = strings.ToLower(query.Get("creator"))
+	switch filter.sort = query.Get("sort"); filter.sort {
+	case "":
+		filter.sort = "newest"
+	case "newest", "oldest", "time":
+	default:
+		return filter, fmt.Errorf("unknown sort %q", filter.sort)
+	}
+	return filter, nil
+}
+
+func (filter lobbyFilter) matches(lg LobbyGame) bool {
+	if filter.rated != nil && lg.Rated != *filter.rated {
+		return false
+	}
+	if filter.timed != nil && (lg.TimeControl != nil) != *filter.timed {
+		return false
+	}
+	if filter.variant != "" && lg.Variant != filter.variant {
+		return false
+	}
+	return filter.creator == "" || strings.Contains(strings.ToLower(lg.Creator), filter.creator)
+}
+
+// sortLobby orders games for a listing. Ties fall back to the game ID so the order is stable.
+func (filter lobbyFilter) sortLobby(games []LobbyGame) {
+	totalMs := func(lg LobbyGame) int64 {
+		if lg.TimeControl == nil {
+			return 1<<63 - 1
+		}
+		return lg.TimeControl.InitialMs + lg.TimeControl.PerMoveMs
+	}
+	sort.Slice(games, func(i, j int) bool {
+		a, b := games[i], games[j]
+		switch {
+		case filter.sort == "time" && totalMs(a) != totalMs(b):
+			return totalMs(a) < totalMs(b)
+		case filter.sort == "oldest" && !a.CreatedAt.Equal(b.CreatedAt):
+			return a.CreatedAt.Before(b.CreatedAt)
+		case filter.sort != "oldest" && !a.CreatedAt.Equal(b.CreatedAt):
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// lobbyEntry describes a waiting game. Options are fixed at creation, so no game lock is needed.
+func (game *Game) lobbyEntry() LobbyGame {
+	return LobbyGame{
+		ID:          game.ID,
+		Creator:     game.options.Name,
+		CreatedAt:   game.createdAt,
+		TimeControl: game.options.TimeControl,
+		Variant:     game.options.Variant,
+		Rated:       game.options.Rated,
+		First:       game.options.First,
+		Passphrase:  game.options.Passphrase != "",
+		Position:    game.options.Position,
+		Rules:       game.options.Rules,
+	}
+}
+
+// addWaitingGame opens a game's second seat and announces it. Caller must hold serverMutex.
+func (s *Server) addWaitingGame(game *Game) {
+	s.waitingGames[game.ID] = game
+	if game.listed() {
+		s.lobby.publish(lobbyEvent{name: "added", game: game.lobbyEntry()})
+	}
+}
+
+// removeWaitingGame closes a game's second seat, if open. Caller must hold serverMutex.
+func (s *Server) removeWaitingGame(game *Game) {
+	if _, waiting := s.waitingGames[game.ID]; !waiting {
+		return
+	}
+	delete(s.waitingGames, game.ID)
+	if game.listed() {
+		s.lobby.publish(lobbyEvent{name: "removed", game: LobbyGame{ID: game.ID}})
+	}
+}
+
+// listLobby returns the listed waiting games that pass filter, sorted. Caller must hold serverMutex.
+func (s *Server) listLobby(filter lobbyFilter) []LobbyGame {
+	games := []LobbyGame{}
+	for _, game := range s.waitingGames {
+		if lg := game.lobbyEntry(); game.listed() && filter.matches(lg) {
+			games = append(games, lg)
+		}
+	}
+	filter.sortLobby(games)
+	return games
+}
+
+func (feed *lobbyFeed) subscribe() chan lobbyEvent {
+	ch := make(chan lobbyEvent, lobbyFeedBuffer)
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	if feed.subscribers == nil {
+		feed.subscribers = make(map[chan lobbyEvent]struct{})
+	}
+	feed.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (feed *lobbyFeed) unsubscribe(ch chan lobbyEvent) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	if _, present := feed.subscribers[ch]; present {
+		delete(feed.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish queues ev for every subscriber without blocking. Subscribers whose queue is full
+// are dropped; their feed ends and the client reconnects for a fresh snapshot.
+func (feed *lobbyFeed) publish(ev lobbyEvent) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	for ch := range feed.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			delete(feed.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends every feed, e.g. on server shutdown.
+func (feed *lobbyFeed) close() {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+	for ch := range feed.subscribers {
+		delete(feed.subscribers, ch)
+		close(ch)
+	}
+}
+
+// handleLobby lists waiting games as {"games": [...]}, empty when nothing is waiting.
+func (s *Server) handleLobby(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	filter, err := parseLobbyFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.serverMutex.Lock()
+	games := s.listLobby(filter)
+	s.serverMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Games []LobbyGame `json:"games"`
+	}{games})
+}
+
+// handleLobbyFeed streams the lobby as server-sent events: a "snapshot" of the current
+// listing, then "added" and "removed" as games open and close. Takes the same filters as /lobby.
+func (s *Server) handleLobbyFeed(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	filter, err := parseLobbyFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe under the same lock as the snapshot so no change falls between them
+	s.serverMutex.Lock()
+	games := s.listLobby(filter)
+	events := s.lobby.subscribe()
+	s.serverMutex.Unlock()
+	defer s.lobby.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	writeEvent := func(name string, data interface{}) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, payload); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if err := writeEvent("snapshot", struct {
+		Games []LobbyGame `json:"games"`
+	}{games}); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(lobbyKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, open := <-events:
+			if !open {
+				return
+			}
+			var err error
+			switch {
+			case ev.name == "removed":
+				err = writeEvent("removed", LobbyRemoved{ID: ev.game.ID})
+			case filter.matches(ev.game):
+				err = writeEvent("added", ev.game)
+			}
+			if err != nil {
+				log.Printf("Lobby feed write failed: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// --- Helpers ---
+
+func lobbyListing(t *testing.T, s *Server, query string) []LobbyGame {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleLobby(rec, httptest.NewRequest("GET", "/lobby?"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("lobby?%s returned %d: %s", query, rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Games []LobbyGame `json:"games"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unreadable lobby response: %v", err)
+	}
+	return body.Games
+}
+
+func createListedGame(s *Server, query string, age time.Duration) *Game {
+	opts, err := parseGameOptions(mustParseQuery(query))
+	if err != nil {
+		panic(err)
+	}
+	game := s.createGame(nil, opts, nil)
+	game.createdAt = game.createdAt.Add(-age)
+	return game
+}
+
+func mustParseQuery(query string) url.Values {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		panic(err)
+	}
+	return values
+}
+
+// creators lists the games' creators in order, comma-separated.
+func creators(games []LobbyGame) string {
+	var out []string
+	for _, lg := range games {
+		out = append(out, lg.Creator)
+	}
+	return strings.Join(out, ",")
+}
+
+// readEvent reads the next server-sent event, skipping keep-alive comments.
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	t.Helper()
+	var name, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("lobby feed ended: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && name != "":
+			return name, data
+		}
+	}
+}
+
+// --- Options ---
+
+func TestParseLobbyOptions(t *testing.T) {
+	opts, err := parseGameOptions(url.Values{})
+	if err != nil || opts.Name != defaultName || opts.Variant != VariantStandard || opts.Rated {
+		t.Errorf("expected an anonymous casual standard game by default, got %+v (%v)", opts, err)
+	}
+	opts, err = parseGameOptions(url.Values{"name": {"  Mochi "}, "rated": {"true"}})
+	if err != nil || opts.Name != "Mochi" || !opts.Rated {
+		t.Errorf("expected a rated game by Mochi, got %+v (%v)", opts, err)
+	}
+	for _, bad := range []url.Values{
+		{"variant": {"hexagonal"}},
+		{"rated": {"maybe"}},
+		{"name": {strings.Repeat("n", maxNameLength+1)}},
+	} {
+		if _, err := parseGameOptions(bad); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+// --- Listing ---
+
+func TestLobby_EmptyList(t *testing.T) {
+	s := NewServer()
+	rec := httptest.NewRecorder()
+	s.handleLobby(rec, httptest.NewRequest("GET", "/lobby", nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"games":[]}` {
+		t.Errorf("expected an empty games list, got %s", body)
+	}
+	if ids := waitingGameIDs(t, s); ids == nil || len(ids) != 0 {
+		t.Errorf("expected /getWaitingGame to return an empty list, got %v", ids)
+	}
+}
+
+func TestLobby_MetadataFiltersAndSort(t *testing.T) {
+	s := NewServer()
+	createListedGame(s, "name=Ada&time=5%2B3&rated=true", 3*time.Minute)
+	createListedGame(s, "name=Bea", 2*time.Minute)
+	createListedGame(s, "name=Cy&time=1&passphrase=pw", time.Minute)
+	createListedGame(s, "name=Hidden&visibility=unlisted", 0)
+
+	all := lobbyListing(t, s, "")
+	if got := creators(all); got != "Cy,Bea,Ada" {
+		t.Fatalf("expected listed games newest first, got %s", got)
+	}
+	ada := all[2]
+	if ada.TimeControl == nil || ada.TimeControl.InitialMs != 300_000 || !ada.Rated || ada.Variant != VariantStandard || ada.CreatedAt.IsZero() {
+		t.Errorf("expected Ada's game to carry its metadata, got %+v", ada)
+	}
+	if !all[0].Passphrase || all[1].Passphrase {
+		t.Error("expected only Cy's game to need a passphrase")
+	}
+
+	for query, want := range map[string]string{
+		"sort=oldest":           "Ada,Bea,Cy",
+		"sort=time":             "Cy,Ada,Bea",
+		"rated=true":            "Ada",
+		"timed=false":           "Bea",
+		"creator=c&rated=false": "Cy",
+		"variant=standard":      "Cy,Bea,Ada",
+	} {
+		if got := creators(lobbyListing(t, s, query)); got != want {
+			t.Errorf("lobby?%s: expected %s, got %s", query, want, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleLobby(rec, httptest.NewRequest("GET", "/lobby?sort=elo", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown sort to be a bad request, got %d", rec.Code)
+	}
+}
+
+// --- Feed ---
+
+func TestLobbyFeed_SnapshotThenChanges(t *testing.T) {
+	s := NewServer()
+	existing := createListedGame(s, "name=Early", 0)
+	srv := httptest.NewServer(http.HandlerFunc(s.handleLobbyFeed))
+	defer srv.Close()
+	defer s.lobby.close()
+
+	resp, err := http.Get(srv.URL + "?rated=false")
+	if err != nil {
+		t.Fatalf("failed to open lobby feed: %v", err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+
+	name, data := readEvent(t, r)
+	if name != "snapshot" || !strings.Contains(data, existing.ID) {
+		t.Fatalf("expected a snapshot with %s, got %s %s", existing.ID, name, data)
+	}
+
+	createListedGame(s, "name=Ranked&rated=true", 0) // filtered out
+	createListedGame(s, "name=Secret&visibility=unlisted", 0)
+	added := createListedGame(s, "name=Late", 0)
+	name, data = readEvent(t, r)
+	if name != "added" || !strings.Contains(data, added.ID) {
+		t.Errorf("expected only the matching listed game to be added, got %s %s", name, data)
+	}
+
+	if _, err := s.joinGame(nil, existing.ID, "", "", nil); err != nil {
+		t.Fatalf("unexpected join error: %v", err)
+	}
+	name, data = readEvent(t, r)
+	if name != "removed" || data != `{"id":"`+existing.ID+`"}` {
+		t.Errorf("expected the joined game to be removed, got %s %s", name, data)
+	}
+}
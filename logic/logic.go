@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"slices"
+	"strconv"
 )
 
 type Position struct {
@@ -28,15 +30,17 @@ type BoopMovement struct {
 	Tile          uint8    `json:"tile"`
 }
 
-var directionMap = map[string]Direction{
-	"topLeft":     {-1, -1},
-	"above":       {0, -1},
-	"topRight":    {1, -1},
-	"right":       {1, 0},
-	"bottomRight": {1, 1},
-	"below":       {0, 1},
-	"bottomLeft":  {-1, 1},
-	"left":        {-1, 0},
+// The eight neighbours of a square, in the fixed order boops are resolved.
+// A slice rather than a map so results (and event order) are deterministic.
+var directions = []Direction{
+	{-1, -1}, // topLeft
+	{0, -1},  // above
+	{1, -1},  // topRight
+	{1, 0},   // right
+	{1, 1},   // bottomRight
+	{0, 1},   // below
+	{-1, 1},  // bottomLeft
+	{-1, 0},  // left
 }
 
 // Tile constants
@@ -79,15 +83,24 @@ func (gs *GameState) restorePiece(tile uint8) {
 	}
 }
 
-type Board [6][6]uint8
+// Boards are square, from minBoardSize to maxBoardSize squares a side. Board has room for
+// the largest; a smaller game plays on its top-left BoardSize×BoardSize squares and the rest
+// stay empty.
+const (
+	minBoardSize = 4
+	maxBoardSize = 8
+)
+
+type Board [maxBoardSize][maxBoardSize]uint8
 
 type GameState struct {
 	TurnNumber   uint8  `json:"turnNumber"`
+	FirstMover   uint8  `json:"firstMover"` // 1 or 2, the player who acts on turn 0
 	BroadcastSeq uint32 `json:"broadcastSeq"`
 	PlayerTurn   uint8  `json:"playerTurn"`
-	Board      Board  `json:"board"`
-	P1         Player `json:"p1"`
-	P2         Player `json:"p2"`
+	Board        Board  `json:"board"`
+	P1           Player `json:"p1"`
+	P2           Player `json:"p2"`
 	// previousBoard Board
 	State  string   `json:"state"`
 	Booped []Booped `json:"booped,omitempty"`
@@ -97,11 +110,65 @@ type GameState struct {
 	GraduationChoices Position       `json:"graduationChoices"`
 	ThreeChoices      []Position     `json:"threeChoices"`
 	Winner            uint8          `json:"winner"`
+	Result            string         `json:"result,omitempty"` // why the game ended, once State is GAME_OVER
 	Placed            Move           `json:"placed"`
 	BoopMovement      []BoopMovement `json:"boopMovement"`
 	GraduatedLine     []Position     `json:"graduatedLine,omitempty"`
 	Original          Board          `json:"original"`
 	PreviousBoard     Board          `json:"previousBoard"`
+	// Number of read-only connections watching, refreshed on every broadcast
+	Spectators int `json:"spectators"`
+	// Actions open to the player to move, refreshed on every broadcast
+	LegalMoves []Action `json:"legalMoves"`
+	// Server clocks, refreshed on every broadcast; nil for untimed games
+	Clock *ClockState `json:"clock,omitempty"`
+	// Running score across rematches, refreshed on every broadcast; nil for a one-off game
+	Series *SeriesScore `json:"series,omitempty"`
+	// Accounts playing each seat, refreshed on every broadcast; nil when both are anonymous
+	Users *SeatUsers `json:"users,omitempty"`
+	// Rating changes, set once a rated game between two accounts is over
+	Ratings *RatingChanges `json:"ratings,omitempty"`
+	// Rules the game is played under; nil for StandardRules
+	Rules *Ruleset `json:"rules,omitempty"`
+}
+
+// MarshalJSON writes the boards as BoardSize rows of BoardSize squares, so a standard game
+// encodes exactly as it did when Board was [6][6]. Decoding needs no help: a short JSON
+// array fills the front of a Go array and leaves the rest zero.
+func (gs GameState) MarshalJSON() ([]byte, error) {
+	type plain GameState
+	size := gs.boardSize()
+	return json.Marshal(struct {
+		plain
+		Board         sizedBoard `json:"board"`
+		Original      sizedBoard `json:"original"`
+		PreviousBoard sizedBoard `json:"previousBoard"`
+	}{plain(gs), sizedBoard{&gs.Board, size}, sizedBoard{&gs.Original, size}, sizedBoard{&gs.PreviousBoard, size}})
+}
+
+// sizedBoard is the top-left size×size squares of a Board, as nested JSON arrays.
+type sizedBoard struct {
+	board *Board
+	size  int
+}
+
+func (b sizedBoard) MarshalJSON() ([]byte, error) {
+	out := make([]byte, 0, 2*b.size*b.size+2*b.size+2)
+	out = append(out, '[')
+	for y := 0; y < b.size; y++ {
+		if y > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, '[')
+		for x := 0; x < b.size; x++ {
+			if x > 0 {
+				out = append(out, ',')
+			}
+			out = strconv.AppendUint(out, uint64(b.board[y][x]), 10)
+		}
+		out = append(out, ']')
+	}
+	return append(out, ']'), nil
 }
 
 func comparePosition(a, b Position) bool {
@@ -109,14 +176,7 @@ func comparePosition(a, b Position) bool {
 }
 
 func (gameState *GameState) calculateOriginal() {
-	gameState.Original = Board{
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-	}
+	gameState.Original = Board{}
 	//Remove Placed and BoopMovement from Board and add to Original
 	for y, row := range gameState.Board {
 		for x, boardTile := range row {
@@ -155,16 +215,10 @@ func NewGameState() *GameState {
 	gameState := new(GameState)
 
 	gameState.TurnNumber = 0
+	gameState.FirstMover = 1
 	gameState.State = "WAITING"
 
-	gameState.Board = Board{
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-		{0, 0, 0, 0, 0, 0},
-	}
+	gameState.Board = Board{}
 	gameState.PreviousBoard = gameState.Board
 	// gameState.previousBoard = Board{
 	// 	{0, 0, 0, 0, 0, 0},
@@ -183,7 +237,8 @@ func NewGameState() *GameState {
 }
 
 func (board *Board) move(position Position, tile uint8, gameState *GameState) error {
-	if int(position.X) > len(gameState.Board)-1 || int(position.Y) > len(gameState.Board)-1 || int(position.X) < 0 || int(position.Y) < 0 {
+	size := gameState.boardSize()
+	if int(position.X) > size-1 || int(position.Y) > size-1 || int(position.X) < 0 || int(position.Y) < 0 {
 		return fmt.Errorf("invalid position")
 	}
 	if tile != 1 && tile != 8 && tile != 2 && tile != 9 {
@@ -241,12 +296,104 @@ func (board *Board) move(position Position, tile uint8, gameState *GameState) er
 // 	return gameState.board(gameState)
 // }
 
-func (gameState *GameState) isPlayer1() bool {
-	if gameState.TurnNumber%2 == 0 {
-		return true
+// placePiece places a kitten (kittenOrCat == 0) or cat for the player to move, then
+// resolves boops, lines and the max-placed rule. One line graduates immediately;
+// several lines or a full hand leave the state pending a selection.
+func (gameState *GameState) placePiece(position Position, kittenOrCat int64) error {
+	gameState.PreviousBoard = gameState.Board
+	gameState.GraduatedLine = nil
+
+	var piece uint8
+	if gameState.isPlayer1() {
+		if kittenOrCat == 0 {
+			piece = P1Kitten
+		} else {
+			piece = P1Cat
+		}
 	} else {
-		return false
+		if kittenOrCat == 0 {
+			piece = P2Kitten
+		} else {
+			piece = P2Cat
+		}
 	}
+
+	if err := gameState.Board.move(position, piece, gameState); err != nil {
+		return fmt.Errorf("invalid move: %w", err)
+	}
+	gameState.Placed = Move{Position: position, Piece: piece}
+	gameState.calculateOriginal()
+
+	if len(gameState.Lines) > 1 {
+		gameState.State = "MULTIPLE_WAITING"
+	} else if len(gameState.Lines) == 1 {
+		gameState.Board.graduatePieces(gameState.Lines[0], gameState)
+	} else {
+		if gameState.shouldCheckMaxedOut() {
+			if gameState.Board.winCheckMaxCats(gameState) {
+				return nil
+			}
+			gameState.State = "MAX_WAITING"
+		}
+	}
+	return nil
+}
+
+func (gameState *GameState) shouldCheckMaxedOut() bool {
+	pieces := gameState.rules().Pieces
+	return (gameState.isPlayer1() && gameState.P1.Placed == pieces) ||
+		(!gameState.isPlayer1() && gameState.P2.Placed == pieces)
+}
+
+// graduateLine resolves MULTIPLE_WAITING by graduating the line whose middle is position.
+func (gameState *GameState) graduateLine(position Position) error {
+	if !slices.Contains(gameState.ThreeChoices, position) {
+		return fmt.Errorf("invalid graduation selection: position is not a valid choice")
+	}
+
+	line := gameState.getLineContainingPosition(position)
+	if line == nil {
+		return fmt.Errorf("invalid graduation selection: no complete line found at position")
+	}
+
+	gameState.Board.graduatePieces(line, gameState)
+	gameState.State = "WAITING"
+	gameState.GraduatedLine = line
+	gameState.Lines = nil
+	gameState.BoopMovement = nil
+	gameState.Booped = nil
+	gameState.Placed = Move{}
+	return nil
+}
+
+// graduateSinglePiece resolves MAX_WAITING by graduating one of the current player's pieces.
+func (gameState *GameState) graduateSinglePiece(position Position) error {
+	playerPieces := gameState.Board.getPlayerPiecePositions(gameState)
+	if !slices.Contains(playerPieces, position) {
+		return fmt.Errorf("invalid graduation selection: position is not a valid piece")
+	}
+
+	gameState.Board.graduatePiece(position, gameState)
+	gameState.State = "WAITING"
+	gameState.GraduatedLine = []Position{position}
+	gameState.Lines = nil
+	gameState.BoopMovement = nil
+	gameState.Booped = nil
+	gameState.Placed = Move{}
+	return nil
+}
+
+// isPlayer1 reports whether player 1 is to act: the first mover acts on even turns.
+func (gameState *GameState) isPlayer1() bool {
+	return (gameState.TurnNumber%2 == 0) == (gameState.firstMover() == 1)
+}
+
+// firstMover is 1 or 2. Games persisted before the choice existed have 0 and started with player 1.
+func (gameState *GameState) firstMover() uint8 {
+	if gameState.FirstMover == 2 {
+		return 2
+	}
+	return 1
 }
 
 func (board *Board) adjacencyCheck(newMove Position, gameState *GameState) {
@@ -264,10 +411,10 @@ func (board *Board) adjacencyCheck(newMove Position, gameState *GameState) {
 
 	// fmt.Printf("Checking for adjacency at position %v\n", newMove)
 
-	for _, direction := range directionMap {
+	for _, direction := range directions {
 		// fmt.Printf("key[%v], value[%v]\n", directionName, direction)
 
-		if isInBounds, contentsAtPosition := board.isDirectionInBounds(newMove, direction); isInBounds {
+		if isInBounds, contentsAtPosition := board.isDirectionInBounds(newMove, direction, gameState.boardSize()); isInBounds {
 			//can move this if we return whether the direction is in bounds AND on an empty square
 			if contentsAtPosition != 0 {
 				booped = append(booped, Booped{direction, Position{newMove.X + uint8(direction.X), newMove.Y + uint8(direction.Y)}, (*board)[int8(newMove.Y)+direction.Y][int8(newMove.X)+direction.X], (*board)[int8(newMove.Y)][int8(newMove.X)]})
@@ -283,24 +430,27 @@ func (board *Board) adjacencyCheck(newMove Position, gameState *GameState) {
 	board.checkBoardForThreeInARows(gameState)
 }
 
-// Check if middle of a 3 in a row
-func (board *Board) isMiddleOfThreeInARow(position Position) []Position {
+// Check if middle of a 3 in a row on a board size squares a side. Unless mixed, the three
+// must be all kittens or all cats.
+func (board *Board) isMiddleOfThreeInARow(position Position, size uint8, mixed bool) []Position {
 	// Check if the given position is in the middle of a 3 in a row line
 	// by checking if the positions in all four directions have the same tile value
 
 	tile := (*board)[position.Y][position.X]
+	last := size - 1
 
 	// Helper function to check if two tiles are in the same player category
 	sameCategory := func(a, b uint8) bool {
+		if !mixed {
+			return a != 0 && a == b
+		}
 		// Assuming 1 and 2 are player 1's pieces, and 8 and 9 are player 2's pieces
 		return (a == 1 || a == 2) && (b == 1 || b == 2) || (a == 8 || a == 9) && (b == 8 || b == 9)
 	}
 
 	// Check left and right directions
-	if position.X > 0 && position.X < 5 {
+	if position.X > 0 && position.X < last {
 		if sameCategory((*board)[position.Y][position.X-1], tile) && sameCategory((*board)[position.Y][position.X+1], tile) {
-
-			log.Println("Found 3 in a row at position:", position)
 			return []Position{
 				{X: position.X - 1, Y: position.Y},
 				{X: position.X, Y: position.Y},
@@ -310,10 +460,8 @@ func (board *Board) isMiddleOfThreeInARow(position Position) []Position {
 	}
 
 	// Check up and down directions
-	if position.Y > 0 && position.Y < 5 {
+	if position.Y > 0 && position.Y < last {
 		if sameCategory((*board)[position.Y-1][position.X], tile) && sameCategory((*board)[position.Y+1][position.X], tile) {
-
-			log.Println("Found 3 in a row at position:", position)
 			return []Position{
 				{X: position.X, Y: position.Y - 1},
 				{X: position.X, Y: position.Y},
@@ -323,10 +471,8 @@ func (board *Board) isMiddleOfThreeInARow(position Position) []Position {
 	}
 
 	// Check top-left to bottom-right diagonal
-	if position.X > 0 && position.X < 5 && position.Y > 0 && position.Y < 5 {
+	if position.X > 0 && position.X < last && position.Y > 0 && position.Y < last {
 		if sameCategory((*board)[position.Y-1][position.X-1], tile) && sameCategory((*board)[position.Y+1][position.X+1], tile) {
-
-			log.Println("Found 3 in a row at position:", position)
 			return []Position{
 				{X: position.X - 1, Y: position.Y - 1},
 				{X: position.X, Y: position.Y},
@@ -336,9 +482,8 @@ func (board *Board) isMiddleOfThreeInARow(position Position) []Position {
 	}
 
 	// Check top-right to bottom-left diagonal
-	if position.X > 0 && position.X < 5 && position.Y > 0 && position.Y < 5 {
+	if position.X > 0 && position.X < last && position.Y > 0 && position.Y < last {
 		if sameCategory((*board)[position.Y-1][position.X+1], tile) && sameCategory((*board)[position.Y+1][position.X-1], tile) {
-			log.Println("Found 3 in a row at position:", position)
 			return []Position{
 				{X: position.X + 1, Y: position.Y - 1},
 				{X: position.X, Y: position.Y},
@@ -366,20 +511,20 @@ func (board *Board) checkBoardForThreeInARows(gameState *GameState) {
 	}
 
 	// Check the entire board for any 3 in a row lines
-	for y := 0; y < len(*board); y++ {
-		for x := 0; x < len(*board); x++ {
+	rules := gameState.rules()
+	for y := 0; y < int(rules.BoardSize); y++ {
+		for x := 0; x < int(rules.BoardSize); x++ {
 			position := Position{X: uint8(x), Y: uint8(y)}
-			if line := board.isMiddleOfThreeInARow(position); line != nil {
+			if line := board.isMiddleOfThreeInARow(position, rules.BoardSize, rules.MixedLines); line != nil {
 				key := generateKey(line)
 				if !uniqueLines[key] {
 					uniqueLines[key] = true
-					if player, err := board.checkLinePlayer(line); err == nil {
+					if player, err := board.checkLinePlayer(line, rules.BoardSize); err == nil {
 						// Check if the line belongs to the current player
 						if (gameState.isPlayer1() && player == 1) || (!gameState.isPlayer1() && player == 2) {
 							gameState.Lines = append(gameState.Lines, line)
 							gameState.ThreeChoices = append(gameState.ThreeChoices, position)
 							board.winCheck(line, gameState)
-							log.Printf("Three in a row at (%d, %d)", x, y)
 						}
 					}
 				}
@@ -387,10 +532,16 @@ func (board *Board) checkBoardForThreeInARows(gameState *GameState) {
 		}
 	}
 	// fmt.Println("Lines found on the board: ", gameState.Lines, "Three choices: ", gameState.ThreeChoices)
-
+	if rules.WinLength != 3 {
+		board.winCheckRun(gameState)
+	}
 }
 
 func (board *Board) winCheck(line []Position, gameState *GameState) {
+	// Longer winning rows are found by winCheckRun
+	if gameState.rules().WinLength != 3 {
+		return
+	}
 	//check if a player has won, if 3 Cats are in a row
 	//if the 3 Cats are in a row, then the player has won
 	// fmt.Println("Checking for a win")
@@ -399,25 +550,55 @@ func (board *Board) winCheck(line []Position, gameState *GameState) {
 		// fmt.Println("Checking position: ", position, "Contents: ", (*board)[position.Y][position.X])
 		if (*board)[position.Y][position.X] == 2 || (*board)[position.Y][position.X] == 9 {
 			countCats++
-			log.Println("count cats:", countCats)
 		}
 	}
 	if countCats == 3 {
-		log.Println("Player has won")
 		if gameState.isPlayer1() {
 			gameState.Winner = 1
 		} else {
 			gameState.Winner = 2
 		}
+		gameState.Result = ResultThreeCats
 		//end the game
 	}
 }
 
+// winCheckRun is the win check for rulesets needing more than three cats in a row: the
+// current player wins with WinLength of their cats in a line in any direction.
+func (board *Board) winCheckRun(gameState *GameState) {
+	cat := P1Cat
+	if !gameState.isPlayer1() {
+		cat = P2Cat
+	}
+	length := int(gameState.rules().WinLength)
+	size := gameState.boardSize()
+	// Right, down and both diagonals cover every line once
+	for _, d := range []Direction{{1, 0}, {0, 1}, {1, 1}, {-1, 1}} {
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				run := 0
+				for cx, cy := x, y; run < length && cx >= 0 && cx < size && cy < size && (*board)[cy][cx] == cat; cx, cy = cx+int(d.X), cy+int(d.Y) {
+					run++
+				}
+				if run == length {
+					gameState.Winner = moverNumber(gameState)
+					gameState.Result = ResultLine
+					return
+				}
+			}
+		}
+	}
+}
+
 func (board *Board) winCheckMaxCats(gameState *GameState) bool {
-	// Check if the current player has 8 cats on the board
+	// Check if the current player has all their pieces on the board as cats
+	if !gameState.rules().EightCatsWin {
+		return false
+	}
 	countCats := 0
-	for y := 0; y < len(*board); y++ {
-		for x := 0; x < len(*board); x++ {
+	size := gameState.boardSize()
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
 			tile := (*board)[y][x]
 			if gameState.isPlayer1() && tile == 2 {
 				countCats++
@@ -426,13 +607,13 @@ func (board *Board) winCheckMaxCats(gameState *GameState) bool {
 			}
 		}
 	}
-	if countCats >= 8 {
-		log.Println("Player has won by having 8 cats on the board")
+	if countCats >= int(gameState.rules().Pieces) {
 		if gameState.isPlayer1() {
 			gameState.Winner = 1
 		} else {
 			gameState.Winner = 2
 		}
+		gameState.Result = ResultEightCats
 		return true
 	}
 	return false
@@ -440,8 +621,9 @@ func (board *Board) winCheckMaxCats(gameState *GameState) bool {
 
 func (board *Board) getPlayerPiecePositions(gameState *GameState) []Position {
 	var positions []Position
-	for y := 0; y < len(*board); y++ {
-		for x := 0; x < len(*board); x++ {
+	size := gameState.boardSize()
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
 			tile := (*board)[y][x]
 			if gameState.isPlayer1() && (tile == 1 || tile == 2) {
 				positions = append(positions, Position{X: uint8(x), Y: uint8(y)})
@@ -453,7 +635,7 @@ func (board *Board) getPlayerPiecePositions(gameState *GameState) []Position {
 	return positions
 }
 
-func (board *Board) validateLine(line []Position) bool {
+func (board *Board) validateLine(line []Position, size uint8) bool {
 	// Check if the line is valid
 	// A line is valid if it contains exactly 3 positions, and all positions are within the board
 	if len(line) != 3 {
@@ -461,7 +643,7 @@ func (board *Board) validateLine(line []Position) bool {
 	}
 
 	for _, position := range line {
-		if position.X > 5 || position.Y > 5 {
+		if position.X >= size || position.Y >= size {
 			return false
 		}
 	}
@@ -492,10 +674,10 @@ func isDiagonal(line []Position) bool {
 			(line[0].Y == line[1].Y+1 && line[1].Y == line[2].Y+1))
 }
 
-func (board *Board) checkLinePlayer(line []Position) (uint8, error) {
+func (board *Board) checkLinePlayer(line []Position, size uint8) (uint8, error) {
 	// Check if all pieces in the line belong to the same player
 	// Return the player number if all pieces belong to a player, otherwise return 0
-	if !board.validateLine(line) {
+	if !board.validateLine(line, size) {
 		return 0, fmt.Errorf("invalid line")
 	}
 
@@ -550,7 +732,6 @@ func (board *Board) graduatePiece(piecePosition Position, gameState *GameState)
 
 func (gameState *GameState) getLineContainingPosition(position Position) []Position {
 	// Return the line in which the position is in the middle of it
-	log.Println("Lines:", gameState.Lines)
 	for _, line := range gameState.Lines {
 		if position == line[1] {
 			return line
@@ -575,14 +756,13 @@ func (board *Board) boopCheck(booped []Booped, gameState *GameState) {
 	for _, piece := range booped {
 
 		//if the piece is a cat and the boopedBy is a kitten, then skip
-		if (piece.Tile == 2 || piece.Tile == 9) && (piece.BoopedBy == 1 || piece.BoopedBy == 8) {
+		if (piece.Tile == 2 || piece.Tile == 9) && (piece.BoopedBy == 1 || piece.BoopedBy == 8) && !gameState.rules().KittensBoopCats {
 			continue
 		}
 
-		var isInBounds, outcomePositionContents = board.isDirectionInBounds(piece.Position, piece.Direction)
+		var isInBounds, outcomePositionContents = board.isDirectionInBounds(piece.Position, piece.Direction, gameState.boardSize())
 		//if the piece's direction is out of bounds - then it is boopable, add back to player's pieces
 		if !isInBounds {
-			log.Printf("Piece %v at %v booped off board", piece.Tile, piece.Position)
 			(*board)[piece.Position.Y][piece.Position.X] = 0
 			gameState.Booped = append(gameState.Booped, piece)
 			if piece.Tile == 1 {
@@ -610,12 +790,12 @@ func (board *Board) boopCheck(booped []Booped, gameState *GameState) {
 	}
 }
 
-// if the direction is in the bounds return true/false and what is at that position
-func (board *Board) isDirectionInBounds(position Position, direction Direction) (bool, int8) {
+// if the direction is in the bounds of a board size squares a side return true/false and what is at that position
+func (board *Board) isDirectionInBounds(position Position, direction Direction, size int) (bool, int8) {
 	if (int8(position.X)+(direction.X) < 0) ||
 		(int8(position.Y)+(direction.Y) < 0) ||
-		(int8(position.X)+(direction.X) > int8(len(*board))-1) ||
-		(int8(position.Y)+(direction.Y) > int8(len(*board))-1) {
+		(int8(position.X)+(direction.X) > int8(size)-1) ||
+		(int8(position.Y)+(direction.Y) > int8(size)-1) {
 		return false, -1
 	}
 	return true, int8((*board)[int8(position.Y)+direction.Y][int8(position.X)+direction.X])
@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"crypto/rand"
 	"net/http"
 	"os"
 	"os/signal"
-	"slices"
+	"path/filepath"
 	"sync"
 	"syscall"
 	"time"
@@ -32,24 +34,69 @@ type Server struct {
 	serverMutex  sync.Mutex
 	games        map[string]*Game
 	waitingGames map[string]*Game
+	db           *sql.DB // nil when persistence is disabled
+	// How long a disconnected seat is held open before the game is abandoned
+	reconnectGrace time.Duration
+	// Subscribers to waiting games opening and closing
+	lobby lobbyFeed
+	// Clients waiting to be paired by /ws?queue=
+	matchmaker matchmaker
+	// Key that signs account session tokens
+	sessionSecret []byte
 }
 
 type Game struct {
-	ID        string                     `json:"id"`
-	Players   map[string]*websocket.Conn `json:"players"`
-	GameState *GameState                 `json:"gameState"`
-	mutex     sync.Mutex
-	send      chan Message
-	done      chan struct{} // signals all goroutines to stop
-	closeOnce sync.Once    // ensures done is closed exactly once
+	ID          string                     `json:"id"`
+	Players     map[string]*websocket.Conn `json:"players"`
+	GameState   *GameState                 `json:"gameState"`
+	mutex       sync.Mutex
+	send        chan Message
+	done        chan struct{}          // signals all goroutines to stop
+	closeOnce   sync.Once              // ensures done is closed exactly once
+	tokens      map[string]string      // secret per-seat rejoin tokens, fixed at creation
+	graceTimers map[string]*time.Timer // pending abandonment timers for disconnected seats
+	spectators  map[*spectator]struct{}
+	bot         *Bot // non-nil when a seat is played by the server
+	// States before each applied action, oldest first, for takebacks. Not persisted.
+	history       []GameState
+	takebackFrom  string     // seat with a pending takeback request, or ""
+	drawOfferFrom string     // seat with a standing draw offer, or ""
+	clock         *gameClock // nil for untimed games
+	options       GameOptions
+	// Rematches: the running score, which series side sits in player1, the pending request,
+	// and the game that replaced this one
+	series      *series
+	p1Side      int
+	rematchFrom string
+	successor   *Game
+	// Required to take the second seat of an unlisted game
+	inviteCode string
+	createdAt  time.Time
+	// Accounts bound to seats by /ws?session=; anonymous seats are absent
+	users map[string]*User
+	rated bool // ratings have been updated for this game's result
+	// Every action applied, for the archive, and how many of them are in the database
+	actions      []ArchivedAction
+	actionsSaved int
 }
 
 type Message struct {
-	Type     string      `json:"type"`
-	GameID   string      `json:"gameID"`
-	PlayerID string      `json:"playerID"`
-	State    string      `json:"state"`
-	Payload  interface{} `json:"payload"`
+	Type     string          `json:"type"`
+	GameID   string          `json:"gameID"`
+	PlayerID string          `json:"playerID"`
+	Token    string          `json:"token,omitempty"`
+	Version  int             `json:"version,omitempty"` // protocol version, on "joined" only
+	State    string          `json:"state"`
+	Code     string          `json:"code,omitempty"`   // machine-readable reason, on "error" only
+	Invite   *Invite         `json:"invite,omitempty"` // unlisted games, on the creator's "joined" only
+	Replay   *ReplayPosition `json:"replay,omitempty"` // replay viewers only
+	Payload  interface{}     `json:"payload"`
+	// Seat the message is private to, or "" to broadcast to both seats and spectators
+	To string `json:"-"`
+}
+
+func (msg *Message) deliversTo(seat string) bool {
+	return msg.To == "" || msg.To == seat
 }
 
 var upgrader = websocket.Upgrader{
@@ -64,8 +111,10 @@ var upgrader = websocket.Upgrader{
 
 func NewServer() *Server {
 	return &Server{
-		games:        make(map[string]*Game),
-		waitingGames: make(map[string]*Game),
+		games:          make(map[string]*Game),
+		waitingGames:   make(map[string]*Game),
+		reconnectGrace: defaultReconnectGrace,
+		sessionSecret:  newSessionSecret(),
 	}
 }
 
@@ -76,6 +125,14 @@ func NewGame() *Game {
 		Players:   make(map[string]*websocket.Conn),
 		send:      make(chan Message, 16), // buffered to prevent blocking
 		done:      make(chan struct{}),
+		tokens: map[string]string{
+			"player1": generateSeatToken(),
+			"player2": generateSeatToken(),
+		},
+		graceTimers: make(map[string]*time.Timer),
+		spectators:  make(map[*spectator]struct{}),
+		createdAt:   time.Now(),
+		users:       make(map[string]*User),
 	}
 }
 
@@ -85,42 +142,57 @@ func (game *Game) shutdown() {
 	})
 }
 
-func (server *Server) createGame(conn *websocket.Conn) *Game {
+// createGame seats conn as player1 in a new waiting game, signed in as user if it isn't nil.
+func (server *Server) createGame(conn *websocket.Conn, opts GameOptions, user *User) *Game {
 	server.serverMutex.Lock()
 	defer server.serverMutex.Unlock()
 
 	game := NewGame()
+	game.applyOptions(opts)
 	// Check for ID collision
 	for _, exists := server.games[game.ID]; exists; _, exists = server.games[game.ID] {
 		game.ID = generateGameID()
 	}
 	game.Players["player1"] = conn
+	game.bindUser("player1", user)
 	server.games[game.ID] = game
-	server.waitingGames[game.ID] = game
+	server.addWaitingGame(game)
+	server.saveNewGame(game)
 	log.Printf("Game created: %s", game.ID)
 	return game
 }
 
-func (server *Server) joinGame(conn *websocket.Conn, requestedGameID string) *Game {
+// joinGame seats conn as player2 in a waiting game. Unlisted games need the invite code,
+// games with a passphrase need it too, and rated games need an account other than the creator's.
+func (server *Server) joinGame(conn *websocket.Conn, requestedGameID string, invite string, passphrase string, user *User) (*Game, error) {
 	server.serverMutex.Lock()
 	defer server.serverMutex.Unlock()
 
 	if requestedGameID == "" {
-		return nil
+		return nil, fmt.Errorf("no game ID")
 	}
 
 	game, exists := server.waitingGames[requestedGameID]
 	if !exists {
-		return nil
+		return nil, fmt.Errorf("game %s is not waiting for a player", requestedGameID)
 	}
-	if len(game.Players) >= 2 {
-		return nil
+	// A restored waiting game may not have its creator back yet
+	const playerID = "player2"
+	if _, taken := game.Players[playerID]; taken {
+		return nil, fmt.Errorf("game %s is full", requestedGameID)
+	}
+	if err := game.admits(invite, passphrase); err != nil {
+		return nil, err
+	}
+	if err := game.admitsUser(user); err != nil {
+		return nil, err
 	}
 
-	playerID := fmt.Sprintf("player%d", len(game.Players)+1)
 	game.Players[playerID] = conn
-	delete(server.waitingGames, game.ID)
-	return game
+	game.bindUser(playerID, user)
+	server.removeWaitingGame(game)
+	server.saveGameJoined(game)
+	return game, nil
 }
 
 // writePump handles all writes to all players for a game.
@@ -154,25 +226,22 @@ func (game *Game) writePump(s *Server, wg *sync.WaitGroup) {
 			}
 			game.mutex.Unlock()
 
-			if msg.Type == "error" {
-				// Error messages go to the specific player (if set) or all
-				for _, conn := range players {
-					conn.SetWriteDeadline(time.Now().Add(writeWait))
-					if err := conn.WriteJSON(msg); err != nil {
-						log.Printf("Failed to write error message: %v", err)
-					}
+			for playerID, conn := range players {
+				if !msg.deliversTo(playerID) {
+					continue
 				}
-			}
-
-			if msg.Type == "gameState" {
-				for playerID, conn := range players {
-					outMsg := msg
+				outMsg := msg
+				if msg.Type == "gameState" {
+					// Each seat learns which player it is from the broadcast
 					outMsg.PlayerID = playerID
-					conn.SetWriteDeadline(time.Now().Add(writeWait))
-					if err := conn.WriteJSON(outMsg); err != nil {
-						log.Printf("Failed to broadcast to %s: %v", playerID, err)
-					}
 				}
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				if err := conn.WriteJSON(outMsg); err != nil {
+					log.Printf("Failed to write %s to %s: %v", msg.Type, playerID, err)
+				}
+			}
+			if msg.To == "" {
+				game.fanOutToSpectators(msg)
 			}
 
 		case <-ticker.C:
@@ -194,61 +263,13 @@ func (game *Game) writePump(s *Server, wg *sync.WaitGroup) {
 	}
 }
 
-func (game *Game) readMove(conn *websocket.Conn, playerID string) (error, Message, *NewMove) {
-	var newMove NewMove
-	errMsg := Message{
-		Type:    "error",
-		GameID:  game.ID,
-		Payload: "Failed to read move",
-		State:   game.GameState.State,
-	}
-
-	if err := conn.ReadJSON(&newMove); err != nil {
-		if websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-			log.Printf("WebSocket closed for %s: %v", playerID, err)
-		} else if websocket.IsUnexpectedCloseError(err) {
-			log.Printf("Unexpected WebSocket close for %s: %v", playerID, err)
-		} else {
-			log.Printf("Read error from %s: %v", playerID, err)
-		}
-		errMsg.Payload = "Disconnected"
-		return err, errMsg, nil
-	}
-
-	// Piece 99 = client pong
-	decodedPiece, _ := newMove.Piece.Int64()
-	if decodedPiece == 99 {
-		log.Printf("Pong received from %s", playerID)
-		if err := conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
-			log.Printf("Failed to set read deadline for %s: %v", playerID, err)
-			errMsg.Payload = "Disconnected"
-			return fmt.Errorf("failed to set read deadline"), errMsg, nil
-		}
-		return nil, Message{Type: "Pong", Payload: "Pong received"}, &newMove
-	}
-
-	if !game.isValidTurn(playerID) {
-		errMsg.Payload = "Not your turn"
-		// Non-blocking send
-		select {
-		case game.send <- errMsg:
-		default:
-			log.Printf("Send channel full, dropping 'not your turn' error for %s", playerID)
-		}
-		log.Printf("Not your turn %s", playerID)
-		return fmt.Errorf("not your turn"), errMsg, &newMove
-	}
-
-	return nil, Message{}, &newMove
-}
-
 const (
 	pingPeriod = 30 * time.Second
 	pongWait   = 60 * time.Second
 	writeWait  = 10 * time.Second
 )
 
-func (game *Game) readPump(conn *websocket.Conn, playerID string, wg *sync.WaitGroup) {
+func (game *Game) readPump(s *Server, conn *websocket.Conn, playerID string, version int, wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer func() {
 		if r := recover(); r != nil {
@@ -260,6 +281,9 @@ func (game *Game) readPump(conn *websocket.Conn, playerID string, wg *sync.WaitG
 
 	log.Printf("ReadPump: Player %s connected to game %s", playerID, game.ID)
 	for {
+		// A rematch moves the connection to a new game (and seat) before retiring this one
+		game, playerID = game.follow(playerID)
+
 		// Check if game is shutting down
 		select {
 		case <-game.done:
@@ -267,86 +291,44 @@ func (game *Game) readPump(conn *websocket.Conn, playerID string, wg *sync.WaitG
 		default:
 		}
 
-		// Stop processing moves after a winner is declared
-		if game.GameState.Winner != 0 {
-			return
+		frame, err := game.readFrame(conn, playerID, version)
+		game, playerID = game.follow(playerID)
+		if err == nil {
+			err = game.handleFrame(s, conn, playerID, frame)
 		}
-
-		switch game.GameState.State {
-		case "WAITING":
-			err, errMsg, newMove := game.readMove(conn, playerID)
-			if err != nil || errMsg.Type == "Pong" {
-				if errMsg.Payload == "Disconnected" {
-					return
-				}
-				continue
-			}
-			if err := game.processTurn(newMove); err != nil {
-				errMsg.Payload = err.Error()
-				errMsg.State = game.GameState.State
-				select {
-				case game.send <- errMsg:
-				default:
-				}
-				continue
-			}
-
-		case "MULTIPLE_WAITING":
-			err, errMsg, newMove := game.readMove(conn, playerID)
-			if err != nil || errMsg.Type == "Pong" {
-				if errMsg.Payload == "Disconnected" {
-					return
-				}
-				continue
-			}
-			if err := game.handleMultipleGraduations(newMove); err != nil {
-				errMsg.Payload = err.Error()
-				errMsg.State = game.GameState.State
-				select {
-				case game.send <- errMsg:
-				default:
-				}
-				continue
-			}
-
-		case "MAX_WAITING":
-			err, errMsg, newMove := game.readMove(conn, playerID)
-			if err != nil || errMsg.Type == "Pong" {
-				if errMsg.Payload == "Disconnected" {
-					return
-				}
-				continue
-			}
-			if err := game.handleMaxedOutGraduation(newMove); err != nil {
-				errMsg.Payload = err.Error()
-				errMsg.State = game.GameState.State
-				select {
-				case game.send <- errMsg:
-				default:
-				}
-				continue
-			}
+		if err == errDisconnected {
+			return
 		}
-
-		if game.GameState.State == "WAITING" {
-			game.GameState.TurnNumber++
+		var perr *ProtocolError
+		if errors.As(err, &perr) {
+			game.sendError(playerID, perr)
 		}
-		game.broadcastGameState()
 	}
 }
 
-func (s *Server) handleGameLoop(conn *websocket.Conn, game *Game, playerID string) {
+// commitTurn runs after every accepted action: the clocks are switched, a finished rated
+// game is rated, and the new state is broadcast, persisted, and handed to the bot.
+func (game *Game) commitTurn(s *Server) {
+	game.tickClock(s)
+	s.rateGame(game)
+	game.broadcastGameState()
+	s.saveGame(game)
+	game.wakeBot()
+}
+
+func (s *Server) handleGameLoop(conn *websocket.Conn, game *Game, playerID string, version int) {
 	defer func() {
+		game, playerID = game.follow(playerID)
 		log.Printf("handleGameLoop ending for %s player %s", game.ID, playerID)
 		conn.Close()
-		s.handlePlayerDisconnect(game.ID, playerID)
+		s.handlePlayerDisconnect(game.ID, playerID, conn)
 	}()
 
 	var wg sync.WaitGroup
 
 	// readPump per player, writePump per game (started once by first player)
 	wg.Add(1)
-	go game.readPump(conn, playerID, &wg)
+	go game.readPump(s, conn, playerID, version, &wg)
 
 	wg.Wait()
 }
@@ -356,108 +338,58 @@ func (game *Game) isValidTurn(playerID string) bool {
 		(!game.GameState.isPlayer1() && playerID == "player2")
 }
 
-func (game *Game) processTurn(newMove *NewMove) error {
-	game.mutex.Lock()
-	defer game.mutex.Unlock()
-
-	game.GameState.PreviousBoard = game.GameState.Board
-	game.GameState.GraduatedLine = nil
-
-	kittenOrCat, _ := newMove.Piece.Int64()
-
-	var piece uint8
-	if game.GameState.isPlayer1() {
-		if kittenOrCat == 0 {
-			piece = 1
-		} else {
-			piece = 2
-		}
-	} else {
-		if kittenOrCat == 0 {
-			piece = 8
-		} else {
-			piece = 9
-		}
-	}
-
-	if err := game.GameState.Board.move(newMove.Position, piece, game.GameState); err != nil {
-		return fmt.Errorf("invalid move: %w", err)
-	}
-	game.GameState.Placed = Move{Position: newMove.Position, Piece: piece}
-	game.GameState.calculateOriginal()
-
-	if len(game.GameState.Lines) > 1 {
-		game.GameState.State = "MULTIPLE_WAITING"
-	} else if len(game.GameState.Lines) == 1 {
-		game.GameState.Board.graduatePieces(game.GameState.Lines[0], game.GameState)
-	} else {
-		if game.shouldCheckMaxedOut() {
-			if game.GameState.Board.winCheckMaxCats(game.GameState) {
-				return nil
-			}
-			game.GameState.State = "MAX_WAITING"
-		}
+func otherSeat(playerID string) string {
+	if playerID == "player1" {
+		return "player2"
 	}
-
-	return nil
-}
-
-func (game *Game) shouldCheckMaxedOut() bool {
-	return (game.GameState.isPlayer1() && game.GameState.P1.Placed == 8) ||
-		(!game.GameState.isPlayer1() && game.GameState.P2.Placed == 8)
+	return "player1"
 }
 
-func (game *Game) handleMultipleGraduations(selection *NewMove) error {
-	log.Printf("handleMultipleGrad: Called, selection: %+v", selection)
-	log.Println(game.GameState.ThreeChoices)
-	if !slices.Contains(game.GameState.ThreeChoices, selection.Position) {
-		return fmt.Errorf("invalid graduation selection: position is not a valid choice")
+// applyAction runs action through the engine and, if it is legal, replaces the game's
+// state with the result. Caller must hold game.mutex.
+func (game *Game) applyAction(action Action) ([]Event, error) {
+	next, events, err := Apply(*game.GameState, action)
+	if err != nil {
+		return nil, err
 	}
-
-	line := game.GameState.getLineContainingPosition(selection.Position)
-	if line == nil {
-		return fmt.Errorf("invalid graduation selection: no complete line found at position")
+	game.recordHistory()
+	game.recordAction(moverSeat(game.GameState), action, events)
+	// Any move overtakes a pending takeback request; a draw offer stands until the other seat moves
+	game.takebackFrom = ""
+	if game.drawOfferFrom != moverSeat(game.GameState) {
+		game.drawOfferFrom = ""
 	}
-
-	game.GameState.Board.graduatePieces(line, game.GameState)
-	log.Println("handleMultipleGrad: changing State to WAITING")
-	game.GameState.State = "WAITING"
-	game.GameState.GraduatedLine = line
-	game.GameState.Lines = nil
-	game.GameState.BoopMovement = nil
-	game.GameState.Booped = nil
-	game.GameState.Placed = Move{}
-	return nil
-}
-
-func (game *Game) handleMaxedOutGraduation(selection *NewMove) error {
-	log.Println("handleMaxedGrad: Called")
-	playerPieces := game.GameState.Board.getPlayerPiecePositions(game.GameState)
-	if !slices.Contains(playerPieces, selection.Position) {
-		log.Println("handleMaxedGrad: Position not found in player pieces")
-		return fmt.Errorf("invalid graduation selection: position is not a valid piece")
+	*game.GameState = next
+	if game.GameState.Winner != 0 {
+		log.Printf("Player %d has won game %s (%s)", game.GameState.Winner, game.ID, game.GameState.Result)
 	}
-
-	game.GameState.Board.graduatePiece(selection.Position, game.GameState)
-	log.Println("handleMaxedGrad: changing State to WAITING")
-	game.GameState.State = "WAITING"
-	game.GameState.GraduatedLine = []Position{selection.Position}
-	game.GameState.Lines = nil
-	game.GameState.BoopMovement = nil
-	game.GameState.Booped = nil
-	game.GameState.Placed = Move{}
-
-	return nil
+	return events, nil
 }
 
 func (game *Game) broadcastGameState() {
+	spectators := game.spectatorCount()
+	clock := game.clockState()
+	series := game.seriesScore()
+	users := game.seatUsers()
+
+	// writePump encodes the payload later, so it carries a copy taken under the lock rather
+	// than the live state
+	game.mutex.Lock()
+	game.GameState.Spectators = spectators
+	game.GameState.LegalMoves = LegalMoves(game.GameState)
+	game.GameState.Clock = clock
+	game.GameState.Series = series
+	game.GameState.Users = users
 	game.GameState.BroadcastSeq++
-	log.Printf("Broadcasting game state: %s", game.GameState.State)
+	snapshot := *game.GameState
+	game.mutex.Unlock()
+
+	log.Printf("Broadcasting game state: %s", snapshot.State)
 	stateMsg := Message{
 		Type:    "gameState",
 		GameID:  game.ID,
-		Payload: game.GameState,
-		State:   game.GameState.State,
+		Payload: snapshot,
+		State:   snapshot.State,
 	}
 
 	// Non-blocking send — if channel is full, log and skip
@@ -468,7 +400,7 @@ func (game *Game) broadcastGameState() {
 	}
 }
 
-func (s *Server) handlePlayerDisconnect(gameID string, playerID string) {
+func (s *Server) handlePlayerDisconnect(gameID string, playerID string, conn *websocket.Conn) {
 	s.serverMutex.Lock()
 	defer s.serverMutex.Unlock()
 
@@ -478,21 +410,49 @@ func (s *Server) handlePlayerDisconnect(gameID string, playerID string) {
 	}
 
 	game.mutex.Lock()
+	if game.Players[playerID] != conn {
+		// Seat has already been reclaimed by a newer connection
+		game.mutex.Unlock()
+		return
+	}
 	delete(game.Players, playerID)
 	remaining := len(game.Players)
 	game.mutex.Unlock()
 
-	if waitingGame, exists := s.waitingGames[gameID]; exists {
-		delete(waitingGame.Players, playerID)
-		delete(s.waitingGames, gameID)
+	_, waiting := s.waitingGames[gameID]
+	s.removeWaitingGame(game)
+
+	// Hold the seat open for an in-progress game so the player can rejoin with their token
+	if !waiting && !game.GameState.isOver() && s.reconnectGrace > 0 {
+		game.holdSeat(s, playerID)
+		return
 	}
 
-	// Clean up game when no players remain
+	// Clean up game when no players remain. Unfinished games stay persisted so they can be
+	// resumed, except a waiting game its creator left: there is nothing to come back to.
 	if remaining == 0 {
-		game.shutdown()
-		delete(s.games, gameID)
-		log.Printf("Game %s cleaned up (no players remaining)", gameID)
+		s.evictGame(game)
+		if waiting {
+			s.deleteGame(game.ID)
+		}
+	}
+}
+
+// evictGame stops a game's goroutines and removes it from memory. Caller must hold serverMutex.
+func (s *Server) evictGame(game *Game) {
+	game.mutex.Lock()
+	for playerID, timer := range game.graceTimers {
+		timer.Stop()
+		delete(game.graceTimers, playerID)
+	}
+	game.mutex.Unlock()
+	game.stopClock()
+	game.shutdown()
+	delete(s.games, game.ID)
+	if game.GameState.isOver() {
+		s.deleteGame(game.ID)
 	}
+	log.Printf("Game %s cleaned up (no players remaining)", game.ID)
 }
 
 func generateGameID() string {
@@ -510,7 +470,7 @@ func generateGameID() string {
 
 func enableCors(w *http.ResponseWriter) {
 	(*w).Header().Set("Access-Control-Allow-Origin", os.Getenv("ORIGIN_URL"))
-	(*w).Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	(*w).Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 }
 
 func main() {
@@ -518,7 +478,7 @@ func main() {
 	dbPath := os.Getenv("DB_PATH")
 	logDir := "/data"
 	if dbPath != "" {
-		logDir = dbPath[:len(dbPath)-len("/games.db")]
+		logDir = filepath.Dir(dbPath)
 	}
 	logFile, err := os.OpenFile(logDir+"/backend.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err == nil {
@@ -527,14 +487,54 @@ func main() {
 	}
 
 	server := NewServer()
+	if grace := os.Getenv("RECONNECT_GRACE"); grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			log.Fatalf("Invalid RECONNECT_GRACE %q: %v", grace, err)
+		}
+		server.reconnectGrace = d
+	}
+	if secret := os.Getenv("SESSION_SECRET"); secret != "" {
+		server.sessionSecret = []byte(secret)
+	} else {
+		log.Println("SESSION_SECRET not set, account sessions will end on restart")
+	}
+	if dbPath == "" {
+		log.Println("DB_PATH not set, games will not be persisted")
+	} else {
+		db, err := initDB(dbPath)
+		if err != nil {
+			log.Fatalf("Failed to open database %s: %v", dbPath, err)
+		}
+		defer db.Close()
+		server.db = db
+		if err := server.restoreGames(); err != nil {
+			log.Printf("Failed to restore games: %v", err)
+		}
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", server.handleConnection)
 	mux.HandleFunc("/getWaitingGame", server.handleGetWaitingGameID)
+	mux.HandleFunc("/lobby", server.handleLobby)
+	mux.HandleFunc("/lobby/feed", server.handleLobbyFeed)
+	mux.HandleFunc("/account/register", server.handleRegister)
+	mux.HandleFunc("/account/login", server.handleLogin)
+	mux.HandleFunc("/account/me", server.handleMe)
+	mux.HandleFunc("/ratings/leaderboard", server.handleLeaderboard)
+	mux.HandleFunc("/ratings/history", server.handleRatingHistory)
+	mux.HandleFunc("/archive/games", server.handleArchivedGames)
+	mux.HandleFunc("/archive/game", server.handleArchivedGame)
+	mux.HandleFunc("/archive/export", server.handleExportRecord)
+	mux.HandleFunc("/archive/import", server.handleImportRecord)
+	mux.HandleFunc("/archive/replay", server.handleReplayFrames)
 
 	httpServer := &http.Server{
 		Addr:    ":8080",
 		Handler: mux,
 	}
+	// Shutdown doesn't interrupt open lobby feeds; end them so it needn't wait out the timeout
+	httpServer.RegisterOnShutdown(server.lobby.close)
 
 	// Graceful shutdown on SIGTERM/SIGINT
 	go func() {
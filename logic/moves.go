@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// ActionType names the kind of action a player can take; each pending state accepts exactly one.
+type ActionType string
+
+const (
+	ActionPlace         ActionType = "place"         // WAITING: put a kitten or cat on an empty square
+	ActionGraduateLine  ActionType = "graduateLine"  // MULTIPLE_WAITING: pick a line by its middle position
+	ActionGraduatePiece ActionType = "graduatePiece" // MAX_WAITING: graduate one of your own pieces
+)
+
+type Action struct {
+	Type     ActionType `json:"type"`
+	Position Position   `json:"position"`
+	Piece    uint8      `json:"piece,omitempty"` // tile being placed; only set for ActionPlace
+}
+
+// LegalMoves lists every action available to the player to move. It is empty once the game is over.
+func LegalMoves(gs *GameState) []Action {
+	if gs.isOver() {
+		return nil
+	}
+
+	var actions []Action
+	switch gs.State {
+	case "WAITING":
+		hand, kitten, cat := gs.P1, P1Kitten, P1Cat
+		if !gs.isPlayer1() {
+			hand, kitten, cat = gs.P2, P2Kitten, P2Cat
+		}
+		size := gs.boardSize()
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if gs.Board[y][x] != 0 {
+					continue
+				}
+				position := Position{X: uint8(x), Y: uint8(y)}
+				if hand.Kittens > 0 {
+					actions = append(actions, Action{Type: ActionPlace, Position: position, Piece: kitten})
+				}
+				if hand.Cats > 0 {
+					actions = append(actions, Action{Type: ActionPlace, Position: position, Piece: cat})
+				}
+			}
+		}
+	case "MULTIPLE_WAITING":
+		for _, position := range gs.ThreeChoices {
+			actions = append(actions, Action{Type: ActionGraduateLine, Position: position})
+		}
+	case "MAX_WAITING":
+		for _, position := range gs.Board.getPlayerPiecePositions(gs) {
+			actions = append(actions, Action{Type: ActionGraduatePiece, Position: position})
+		}
+	}
+	return actions
+}
+
+// checkLegal reports whether action is one of LegalMoves(gs).
+func (gs *GameState) checkLegal(action Action) error {
+	if slices.Contains(LegalMoves(gs), action) {
+		return nil
+	}
+	return fmt.Errorf("illegal move: %s at (%d, %d) is not available in state %s",
+		action.Type, action.Position.X, action.Position.Y, gs.State)
+}
+
+// actionFromMove interprets a client frame in the context of the current state.
+// For placements, Piece 0 means kitten and anything else means cat.
+func actionFromMove(gs *GameState, move *NewMove) Action {
+	switch gs.State {
+	case "MULTIPLE_WAITING":
+		return Action{Type: ActionGraduateLine, Position: move.Position}
+	case "MAX_WAITING":
+		return Action{Type: ActionGraduatePiece, Position: move.Position}
+	}
+
+	kittenOrCat, _ := move.Piece.Int64()
+	tile := P1Kitten
+	switch {
+	case gs.isPlayer1() && kittenOrCat != 0:
+		tile = P1Cat
+	case !gs.isPlayer1() && kittenOrCat == 0:
+		tile = P2Kitten
+	case !gs.isPlayer1():
+		tile = P2Cat
+	}
+	return Action{Type: ActionPlace, Position: move.Position, Piece: tile}
+}
+
+// toNewMove converts an action back to the frame a client would send for it.
+func (action Action) toNewMove() NewMove {
+	piece := json.Number("0")
+	if isCat(action.Piece) {
+		piece = json.Number("1")
+	}
+	return NewMove{Position: action.Position, Piece: piece}
+}
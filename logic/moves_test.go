@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLegalMoves_EmptyBoardKittensOnly(t *testing.T) {
+	gs := newP1Turn()
+	moves := LegalMoves(gs)
+	if len(moves) != 36 {
+		t.Fatalf("expected 36 kitten placements on an empty board, got %d", len(moves))
+	}
+	for _, m := range moves {
+		if m.Type != ActionPlace || m.Piece != P1Kitten {
+			t.Fatalf("expected only P1 kitten placements, got %+v", m)
+		}
+	}
+}
+
+func TestLegalMoves_KittensAndCats(t *testing.T) {
+	gs := newP2Turn()
+	gs.P2.Cats = 2
+	place(gs, P1Kitten, 0, 0)
+	if got := len(LegalMoves(gs)); got != 70 {
+		t.Errorf("expected 35 squares x 2 piece types = 70 placements, got %d", got)
+	}
+}
+
+func TestLegalMoves_MultipleWaitingOffersThreeChoices(t *testing.T) {
+	gs := newP1Turn()
+	gs.Board[0][0], gs.Board[0][1], gs.Board[0][2] = P1Kitten, P1Kitten, P1Kitten
+	gs.Board[3][0], gs.Board[3][1], gs.Board[3][2] = P1Kitten, P1Kitten, P1Kitten
+	gs.Board.checkBoardForThreeInARows(gs)
+	gs.State = "MULTIPLE_WAITING"
+
+	moves := LegalMoves(gs)
+	if len(moves) != 2 {
+		t.Fatalf("expected 2 line choices, got %d", len(moves))
+	}
+	for _, m := range moves {
+		if m.Type != ActionGraduateLine {
+			t.Errorf("expected graduateLine actions, got %+v", m)
+		}
+	}
+}
+
+func TestLegalMoves_MaxWaitingOffersOwnPieces(t *testing.T) {
+	gs := newP2Turn()
+	place(gs, P2Kitten, 1, 1)
+	place(gs, P2Cat, 4, 4)
+	place(gs, P1Kitten, 2, 2)
+	gs.State = "MAX_WAITING"
+
+	moves := LegalMoves(gs)
+	if len(moves) != 2 {
+		t.Fatalf("expected one graduation per P2 piece, got %d", len(moves))
+	}
+	for _, m := range moves {
+		if m.Type != ActionGraduatePiece || tileOwner(gs.Board[m.Position.Y][m.Position.X]) != 2 {
+			t.Errorf("expected graduatePiece on a P2 piece, got %+v", m)
+		}
+	}
+}
+
+func TestLegalMoves_NoneAfterWin(t *testing.T) {
+	gs := newP1Turn()
+	gs.Winner = 1
+	if moves := LegalMoves(gs); len(moves) != 0 {
+		t.Errorf("expected no legal moves once the game is won, got %d", len(moves))
+	}
+}
+
+func TestCheckLegal(t *testing.T) {
+	gs := newP1Turn()
+	place(gs, P2Kitten, 3, 3)
+
+	if err := gs.checkLegal(Action{Type: ActionPlace, Position: Position{X: 0, Y: 0}, Piece: P1Kitten}); err != nil {
+		t.Errorf("expected empty square to be legal, got %v", err)
+	}
+	if err := gs.checkLegal(Action{Type: ActionPlace, Position: Position{X: 3, Y: 3}, Piece: P1Kitten}); err == nil {
+		t.Error("expected occupied square to be illegal")
+	}
+	if err := gs.checkLegal(Action{Type: ActionPlace, Position: Position{X: 0, Y: 0}, Piece: P1Cat}); err == nil {
+		t.Error("expected cat placement with no cats in hand to be illegal")
+	}
+	if err := gs.checkLegal(Action{Type: ActionGraduatePiece, Position: Position{X: 0, Y: 0}}); err == nil {
+		t.Error("expected graduation to be illegal in WAITING")
+	}
+}
+
+// Client frames map to typed actions for the player to move, and back again.
+func TestActionFromMove_RoundTrip(t *testing.T) {
+	gs := newP2Turn()
+	move := NewMove{Position: Position{X: 1, Y: 2}, Piece: json.Number("1")}
+
+	action := actionFromMove(gs, &move)
+	if action.Type != ActionPlace || action.Piece != P2Cat {
+		t.Errorf("expected P2 cat placement, got %+v", action)
+	}
+	if back := action.toNewMove(); back != move {
+		t.Errorf("expected round trip to %+v, got %+v", move, back)
+	}
+}
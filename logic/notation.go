@@ -0,0 +1,498 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Move notation: one token per action, a letter then a square. Files a, b, c... are X 0,
+// 1, 2... and ranks 1, 2, 3... are Y 0, 1, 2..., so a1 is Board[0][0]; the standard board
+// runs to f6 and the largest to h8.
+//
+//	K c3  place a kitten        L b2  graduate the line whose middle is b2 (MULTIPLE_WAITING)
+//	C d4  place a cat           G e5  graduate the piece on e5 (MAX_WAITING)
+const (
+	notationKitten   = "K"
+	notationCat      = "C"
+	notationLine     = "L"
+	notationGraduate = "G"
+	maxRecordSize    = 64 << 10
+)
+
+// Record results, as in PGN
+const (
+	RecordP1Wins     = "1-0"
+	RecordP2Wins     = "0-1"
+	RecordDraw       = "1/2-1/2"
+	RecordUnfinished = "*"
+)
+
+var (
+	tagPattern        = regexp.MustCompile(`^\[(\w+)\s+"((?:[^"\\]|\\.)*)"\]$`)
+	moveNumberPattern = regexp.MustCompile(`^\d+\.$`)
+)
+
+// RecordTag is one "[Name "value"]" header line.
+type RecordTag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// GameRecord is a game in the text record format: header tags, the actions in order, and
+// the result. Tags keep the order they were written in.
+type GameRecord struct {
+	Tags    []RecordTag
+	Actions []Action
+	Result  string
+}
+
+// Tag returns the value of the named tag, or "" if the record doesn't have it.
+func (rec *GameRecord) Tag(name string) string {
+	for _, tag := range rec.Tags {
+		if tag.Name == name {
+			return tag.Value
+		}
+	}
+	return ""
+}
+
+// SetTag replaces the named tag's value, adding it at the end if it is new.
+func (rec *GameRecord) SetTag(name, value string) {
+	for i := range rec.Tags {
+		if rec.Tags[i].Name == name {
+			rec.Tags[i].Value = value
+			return
+		}
+	}
+	rec.Tags = append(rec.Tags, RecordTag{Name: name, Value: value})
+}
+
+func formatSquare(p Position) string {
+	return fmt.Sprintf("%c%d", 'a'+p.X, p.Y+1)
+}
+
+// parseSquare reads a square on a board size squares a side.
+func parseSquare(square string, size int) (Position, error) {
+	if len(square) != 2 || square[0] < 'a' || int(square[0]-'a') >= size || square[1] < '1' || int(square[1]-'1') >= size {
+		return Position{}, fmt.Errorf("bad square %q", square)
+	}
+	return Position{X: square[0] - 'a', Y: square[1] - '1'}, nil
+}
+
+// FormatAction writes action in move notation.
+func FormatAction(action Action) string {
+	letter := notationKitten
+	switch {
+	case action.Type == ActionGraduateLine:
+		letter = notationLine
+	case action.Type == ActionGraduatePiece:
+		letter = notationGraduate
+	case isCat(action.Piece):
+		letter = notationCat
+	}
+	return letter + " " + formatSquare(action.Position)
+}
+
+// ParseAction reads a notation token for the player to move in gs. The letter and square
+// may also be written together ("Kc3").
+func ParseAction(gs *GameState, token string) (Action, error) {
+	token = strings.TrimSpace(token)
+	if len(token) < 3 {
+		return Action{}, fmt.Errorf("bad move %q", token)
+	}
+	pos, err := parseSquare(strings.TrimSpace(token[1:]), gs.boardSize())
+	if err != nil {
+		return Action{}, fmt.Errorf("bad move %q: %v", token, err)
+	}
+	p1 := moverNumber(gs) == 1
+	switch strings.ToUpper(token[:1]) {
+	case notationKitten:
+		tile := P2Kitten
+		if p1 {
+			tile = P1Kitten
+		}
+		return Action{Type: ActionPlace, Position: pos, Piece: tile}, nil
+	case notationCat:
+		tile := P2Cat
+		if p1 {
+			tile = P1Cat
+		}
+		return Action{Type: ActionPlace, Position: pos, Piece: tile}, nil
+	case notationLine:
+		return Action{Type: ActionGraduateLine, Position: pos}, nil
+	case notationGraduate:
+		return Action{Type: ActionGraduatePiece, Position: pos}, nil
+	}
+	return Action{}, fmt.Errorf("bad move %q: unknown piece or action", token)
+}
+
+// recordResult is the result token for a finished state, or "*" while it is in progress.
+func recordResult(gs *GameState) string {
+	switch {
+	case !gs.isOver():
+		return RecordUnfinished
+	case gs.Winner == 1:
+		return RecordP1Wins
+	case gs.Winner == 2:
+		return RecordP2Wins
+	}
+	return RecordDraw
+}
+
+// String writes the record: tags, a blank line, then the actions numbered by turn (a
+// selection shares its placement's number) and the result, wrapped at 80 columns.
+func (rec *GameRecord) String() string {
+	var b strings.Builder
+	for _, tag := range rec.Tags {
+		fmt.Fprintf(&b, "[%s %s]\n", tag.Name, strconv.Quote(tag.Value))
+	}
+	b.WriteString("\n")
+
+	var tokens []string
+	turn := 0
+	for _, action := range rec.Actions {
+		if action.Type == ActionPlace {
+			turn++
+			tokens = append(tokens, fmt.Sprintf("%d.", turn))
+		}
+		tokens = append(tokens, FormatAction(action))
+	}
+	result := rec.Result
+	if result == "" {
+		result = RecordUnfinished
+	}
+	tokens = append(tokens, result)
+
+	line := 0
+	for i, token := range tokens {
+		if line > 0 && line+1+len(token) > 80 {
+			b.WriteString("\n")
+			line = 0
+		} else if i > 0 {
+			b.WriteString(" ")
+			line++
+		}
+		b.WriteString(token)
+		line += len(token)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// ParseRecord reads a game record. Actions are only checked for syntax here; Replay checks
+// them against the rules. Text in braces is a comment.
+func ParseRecord(text string) (*GameRecord, error) {
+	rec := &GameRecord{}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			if len(rec.Tags) > 0 {
+				break
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "[") {
+			break
+		}
+		m := tagPattern.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: bad tag %q", i+1, line)
+		}
+		value, err := strconv.Unquote(`"` + m[2] + `"`)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad tag value: %v", i+1, err)
+		}
+		rec.Tags = append(rec.Tags, RecordTag{Name: m[1], Value: value})
+	}
+
+	movetext := strings.Join(lines[i:], " ")
+	for {
+		open := strings.Index(movetext, "{")
+		if open < 0 {
+			break
+		}
+		end := strings.Index(movetext[open:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated comment")
+		}
+		movetext = movetext[:open] + " " + movetext[open+end+1:]
+	}
+
+	// Squares are only checked against the largest board; Replay knows the record's size
+	syntax := &GameState{Rules: &Ruleset{BoardSize: maxBoardSize}}
+	fields := strings.Fields(movetext)
+	for n := 0; n < len(fields); n++ {
+		field := fields[n]
+		switch {
+		case moveNumberPattern.MatchString(field):
+			continue
+		case field == RecordP1Wins || field == RecordP2Wins || field == RecordDraw || field == RecordUnfinished:
+			if n != len(fields)-1 {
+				return nil, fmt.Errorf("moves after the result %s", field)
+			}
+			rec.Result = field
+			continue
+		}
+		// A letter and its square may be separate fields ("K c3") or one ("Kc3")
+		token := field
+		if len(field) == 1 && n+1 < len(fields) {
+			n++
+			token = field + " " + fields[n]
+		}
+		action, err := ParseAction(syntax, token)
+		if err != nil {
+			return nil, err
+		}
+		rec.Actions = append(rec.Actions, action)
+	}
+	if rec.Result == "" {
+		rec.Result = RecordUnfinished
+	}
+	if tagged := rec.Tag("Result"); tagged != "" && tagged != rec.Result {
+		return nil, fmt.Errorf("Result tag %s doesn't match the movetext result %s", tagged, rec.Result)
+	}
+	return rec, nil
+}
+
+// Replay plays the record through the engine from the start, or from the Position tag if
+// it has one, checking every action, and returns each step as played and the final state.
+// A result the board doesn't show (a resignation, timeout, abandonment or agreed draw) is
+// applied at the end from the Termination tag.
+func (rec *GameRecord) Replay() ([]ArchivedAction, *GameState, error) {
+	var firstMover uint8
+	switch first := rec.Tag("FirstMover"); first {
+	case "", "1":
+		firstMover = 1
+	case "2":
+		firstMover = 2
+	default:
+		return nil, nil, fmt.Errorf("bad FirstMover tag %q", first)
+	}
+	var rules *Ruleset
+	if tag := rec.Tag("Rules"); tag != "" {
+		query, err := url.ParseQuery(tag)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad Rules tag: %v", err)
+		}
+		parsed, err := parseRuleset(query)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad Rules tag: %v", err)
+		}
+		rules = &parsed
+	}
+	state, err := startState(rec.Tag("Position"), firstMover, rules)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad Position tag: %v", err)
+	}
+	if rec.Tag("FirstMover") != "" && state.firstMover() != firstMover {
+		return nil, nil, fmt.Errorf("FirstMover tag doesn't match the Position tag")
+	}
+
+	steps := make([]ArchivedAction, 0, len(rec.Actions))
+	for n, parsed := range rec.Actions {
+		if state.isOver() {
+			return nil, nil, fmt.Errorf("action %d (%s): the game is already over", n+1, FormatAction(parsed))
+		}
+		// Syntax was checked without knowing the mover or the board size; pick the tile for
+		// whoever moves now and check the square is on this board
+		action, err := ParseAction(state, FormatAction(parsed))
+		if err != nil {
+			return nil, nil, fmt.Errorf("action %d: %v", n+1, err)
+		}
+		seat := moverSeat(state)
+		next, events, err := Apply(*state, action)
+		if err != nil {
+			return nil, nil, fmt.Errorf("action %d (%s): %v", n+1, FormatAction(action), err)
+		}
+		if events == nil {
+			events = []Event{}
+		}
+		steps = append(steps, ArchivedAction{Seq: n, Seat: seat, Action: action, Events: events})
+		*state = next
+	}
+
+	onBoard := recordResult(state)
+	switch {
+	case onBoard == rec.Result:
+		if state.isOver() && rec.Tag("Termination") != "" && rec.Tag("Termination") != state.Result {
+			return nil, nil, fmt.Errorf("Termination %s doesn't match the %s on the board", rec.Tag("Termination"), state.Result)
+		}
+	case onBoard != RecordUnfinished:
+		return nil, nil, fmt.Errorf("the moves end %s but the record says %s", onBoard, rec.Result)
+	default:
+		if err := finishOffBoard(state, rec.Result, rec.Tag("Termination")); err != nil {
+			return nil, nil, err
+		}
+	}
+	state.LegalMoves = LegalMoves(state)
+	return steps, state, nil
+}
+
+// finishOffBoard ends a replayed game that stopped before a win on the board.
+func finishOffBoard(state *GameState, result string, termination string) error {
+	switch {
+	case result == RecordUnfinished:
+		return nil
+	case result == RecordDraw && (termination == "" || termination == ResultDraw):
+		state.finish(0, ResultDraw)
+		return nil
+	case result == RecordDraw:
+		return fmt.Errorf("a draw can't end by %q", termination)
+	}
+	switch termination {
+	case ResultResignation, ResultTimeout, ResultAbandonment:
+	case "":
+		termination = ResultResignation
+	default:
+		return fmt.Errorf("the moves don't reach a %s win", termination)
+	}
+	winner := uint8(1)
+	if result == RecordP2Wins {
+		winner = 2
+	}
+	state.finish(winner, termination)
+	return nil
+}
+
+// formatTimeControl writes a time control as PGN does: seconds, "+increment", and
+// "/per-move" seconds if there is a cap. "-" for untimed.
+func formatTimeControl(tc *TimeControl) string {
+	if tc == nil {
+		return "-"
+	}
+	out := strconv.FormatInt(tc.InitialMs/1000, 10)
+	if tc.IncrementMs > 0 {
+		out += "+" + strconv.FormatInt(tc.IncrementMs/1000, 10)
+	}
+	if tc.PerMoveMs > 0 {
+		out += "/" + strconv.FormatInt(tc.PerMoveMs/1000, 10)
+	}
+	return out
+}
+
+// seatName is how a seat is named in an exported record.
+func seatName(user *User, seat string, g ArchivedGame) string {
+	switch {
+	case user != nil:
+		return user.Username
+	case g.BotSeat == seat:
+		return "Bot (" + g.BotLevel + ")"
+	}
+	return "Anonymous"
+}
+
+// exportRecord builds the record of an archived game.
+func (s *Server) exportRecord(gameID string) (*GameRecord, error) {
+	row := s.db.QueryRow(`SELECT `+archivedGameColumns+archivedGameJoins+` WHERE f.id = ?`, gameID)
+	g, err := scanArchivedGame(row)
+	if err != nil {
+		return nil, err
+	}
+	moves, err := s.loadActions(gameID)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := s.loadArchivedRules(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &GameRecord{Result: recordResult(&GameState{State: "GAME_OVER", Winner: g.Winner})}
+	rec.SetTag("Event", "boop")
+	rec.SetTag("Site", siteName())
+	rec.SetTag("Game", g.ID)
+	rec.SetTag("Date", g.StartedAt.UTC().Format("2006.01.02"))
+	rec.SetTag("Player1", seatName(g.Players.P1, "player1", g))
+	rec.SetTag("Player2", seatName(g.Players.P2, "player2", g))
+	rec.SetTag("Variant", variantOf(rules))
+	if rules != nil && *rules != StandardRules {
+		rec.SetTag("Rules", rules.String())
+	}
+	rec.SetTag("FirstMover", strconv.Itoa(int(g.FirstMover)))
+	if g.Position != "" {
+		rec.SetTag("Position", g.Position)
+	}
+	rec.SetTag("TimeControl", formatTimeControl(g.TimeControl))
+	rec.SetTag("Rated", strconv.FormatBool(g.Rated))
+	rec.SetTag("Result", rec.Result)
+	rec.SetTag("Termination", g.Result)
+	for _, m := range moves {
+		rec.Actions = append(rec.Actions, m.Action)
+	}
+	return rec, nil
+}
+
+// siteName is the host in ORIGIN_URL, for the Site tag.
+func siteName() string {
+	if origin, err := url.Parse(os.Getenv("ORIGIN_URL")); err == nil && origin.Host != "" {
+		return origin.Host
+	}
+	return "?"
+}
+
+// --- HTTP ---
+
+// handleExportRecord returns an archived game as a text record.
+func (s *Server) handleExportRecord(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	if s.db == nil {
+		http.Error(w, "archive needs a database (DB_PATH)", http.StatusServiceUnavailable)
+		return
+	}
+	gameID := r.URL.Query().Get("id")
+	rec, err := s.exportRecord(gameID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "no such finished game", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("handleExportRecord: %v", err)
+		http.Error(w, "export failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="boop-%s.txt"`, gameID))
+	io.WriteString(w, rec.String())
+}
+
+// ImportedRecord is the response to /archive/import: the record replayed step by step.
+type ImportedRecord struct {
+	Tags       []RecordTag      `json:"tags"`
+	Result     string           `json:"result"`
+	Moves      []ArchivedAction `json:"moves"`
+	FinalState *GameState       `json:"finalState"`
+}
+
+// handleImportRecord validates a posted text record by replaying it through the engine.
+// Nothing is stored.
+func (s *Server) handleImportRecord(w http.ResponseWriter, r *http.Request) {
+	if !preflight(w, r, http.MethodPost) {
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRecordSize))
+	if err != nil {
+		http.Error(w, "record too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	rec, err := ParseRecord(string(body))
+	if err != nil {
+		http.Error(w, "unreadable record: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	moves, final, err := rec.Replay()
+	if err != nil {
+		http.Error(w, "invalid game: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusOK, ImportedRecord{Tags: rec.Tags, Result: rec.Result, Moves: moves, FinalState: final})
+}
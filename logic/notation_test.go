@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// --- Helpers ---
+
+// randomGame plays up to limit random legal actions from the start with a fixed seed.
+func randomGame(seed int64, limit int) ([]Action, GameState) {
+	rng := rand.New(rand.NewSource(seed))
+	state := *NewGameState()
+	var actions []Action
+	for len(actions) < limit && !state.isOver() {
+		legal := LegalMoves(&state)
+		action := legal[rng.Intn(len(legal))]
+		next, _, err := Apply(state, action)
+		if err != nil {
+			panic(err)
+		}
+		actions = append(actions, action)
+		state = next
+	}
+	return actions, state
+}
+
+// --- Notation ---
+
+func TestNotation_Squares(t *testing.T) {
+	if got := formatSquare(Position{X: 2, Y: 3}); got != "c4" {
+		t.Errorf("expected c4, got %s", got)
+	}
+	for _, square := range []string{"a1", "f6", "d2"} {
+		pos, err := parseSquare(square, 6)
+		if err != nil || formatSquare(pos) != square {
+			t.Errorf("expected %s to round-trip, got %+v (%v)", square, pos, err)
+		}
+	}
+	for _, bad := range []string{"g1", "a7", "a0", "A1", "a", "a10"} {
+		if _, err := parseSquare(bad, 6); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if pos, err := parseSquare("h8", 8); err != nil || pos != (Position{X: 7, Y: 7}) {
+		t.Errorf("expected h8 to be the far corner of an 8x8 board, got %+v (%v)", pos, err)
+	}
+}
+
+func TestNotation_Actions(t *testing.T) {
+	p2 := NewGameState()
+	p2.FirstMover = 2
+	cases := []struct {
+		state  *GameState
+		token  string
+		action Action
+	}{
+		{NewGameState(), "K c3", placeAction(P1Kitten, 2, 2)},
+		{p2, "C d4", placeAction(P2Cat, 3, 3)},
+		{NewGameState(), "L b2", Action{Type: ActionGraduateLine, Position: Position{X: 1, Y: 1}}},
+		{NewGameState(), "G e5", Action{Type: ActionGraduatePiece, Position: Position{X: 4, Y: 4}}},
+	}
+	for _, c := range cases {
+		got, err := ParseAction(c.state, c.token)
+		if err != nil || got != c.action {
+			t.Errorf("%s: expected %+v, got %+v (%v)", c.token, c.action, got, err)
+		}
+		if FormatAction(got) != c.token {
+			t.Errorf("expected %+v to be written %s, got %s", got, c.token, FormatAction(got))
+		}
+	}
+	if got, err := ParseAction(NewGameState(), "kc3"); err != nil || got != placeAction(P1Kitten, 2, 2) {
+		t.Errorf("expected the compact lower-case form to parse, got %+v (%v)", got, err)
+	}
+	if _, err := ParseAction(NewGameState(), "X c3"); err == nil {
+		t.Error("expected an unknown letter to be rejected")
+	}
+}
+
+// --- Records ---
+
+func TestRecord_RoundTripsThroughReplay(t *testing.T) {
+	actions, final := randomGame(7, 400)
+	rec := &GameRecord{Actions: actions, Result: recordResult(&final)}
+	rec.SetTag("Event", "boop")
+	rec.SetTag("Player1", `Mochi "the cat"`)
+	if rec.Result == RecordUnfinished {
+		rec.Result = RecordP2Wins
+		rec.SetTag("Termination", ResultResignation)
+	}
+	text := rec.String()
+	for _, line := range strings.Split(text, "\n") {
+		if len(line) > 80 {
+			t.Errorf("expected lines wrapped at 80 columns, got %q", line)
+		}
+	}
+
+	parsed, err := ParseRecord(text)
+	if err != nil {
+		t.Fatalf("failed to parse our own record: %v\n%s", err, text)
+	}
+	if parsed.Tag("Player1") != `Mochi "the cat"` || len(parsed.Actions) != len(actions) || parsed.Result != rec.Result {
+		t.Fatalf("expected tags, actions and result back, got %+v", parsed)
+	}
+	steps, replayed, err := parsed.Replay()
+	if err != nil {
+		t.Fatalf("unexpected replay error: %v", err)
+	}
+	if len(steps) != len(actions) || replayed.Board != final.Board {
+		t.Error("expected the replay to reach the same board")
+	}
+	for i, step := range steps {
+		if step.Action != actions[i] {
+			t.Fatalf("step %d: expected %+v, got %+v", i, actions[i], step.Action)
+		}
+	}
+	if !replayed.isOver() || recordResult(replayed) != rec.Result {
+		t.Errorf("expected the replay to finish %s, got %s (%s)", rec.Result, recordResult(replayed), replayed.Result)
+	}
+}
+
+func TestRecord_ParsesHandWrittenText(t *testing.T) {
+	text := `[Event "boop"]
+[FirstMover "2"]
+
+1. K c3 {opening} 2. Kd4
+3. K a1 1/2-1/2
+`
+	rec, err := ParseRecord(text)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	steps, final, err := rec.Replay()
+	if err != nil {
+		t.Fatalf("unexpected replay error: %v", err)
+	}
+	if len(steps) != 3 || steps[0].Seat != "player2" || steps[0].Action.Piece != P2Kitten || steps[2].Action.Piece != P2Kitten {
+		t.Errorf("expected player2 to move first, got %+v", steps)
+	}
+	if final.Result != ResultDraw || final.Winner != 0 {
+		t.Errorf("expected an agreed draw, got %s", final.Result)
+	}
+}
+
+func TestRecord_RejectsInvalidGames(t *testing.T) {
+	for text, want := range map[string]string{
+		"1. K a1 2. K a1 *":                          "action 2",
+		"1. K a1 1-0 2. K b2":                        "after the result",
+		"[Result \"0-1\"]\n\n1. K a1 1-0":            "doesn't match",
+		"[Termination \"threeCats\"]\n\n1. K a1 1-0": "don't reach",
+		"1. K a1 2. Q b2 *":                          "unknown",
+		"[FirstMover \"3\"]\n\n*":                    "FirstMover",
+		"1. L a1 *":                                  "action 1",
+		"1. K h8 *":                                  "bad square",
+	} {
+		rec, err := ParseRecord(text)
+		if err == nil {
+			_, _, err = rec.Replay()
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected an error mentioning %q, got %v", text, want, err)
+		}
+	}
+}
+
+// --- HTTP ---
+
+func TestRecord_ExportThenImport(t *testing.T) {
+	t.Setenv("ORIGIN_URL", "https://boop.test")
+	s := newTestServerWithDB(t)
+	game := newRatedGame(t, s, "Mochi", "Biscuit")
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	playAction(t, game, placeAction(P2Kitten, 2, 2))
+	game.resign(s, "player1")
+
+	rec := httptest.NewRecorder()
+	s.handleExportRecord(rec, httptest.NewRequest("GET", "/archive/export?id="+game.ID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export returned %d: %s", rec.Code, rec.Body.String())
+	}
+	text := rec.Body.String()
+	for _, want := range []string{`[Site "boop.test"]`, `[Player1 "Mochi"]`, `[Result "0-1"]`, `[Termination "resignation"]`, "1. K a1 2. K c3 0-1"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected the export to contain %s, got\n%s", want, text)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleImportRecord(rec, httptest.NewRequest("POST", "/archive/import", strings.NewReader(text)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import returned %d: %s", rec.Code, rec.Body.String())
+	}
+	var imported ImportedRecord
+	json.Unmarshal(rec.Body.Bytes(), &imported)
+	if len(imported.Moves) != 2 || imported.FinalState.Board != game.GameState.Board || imported.FinalState.Winner != 2 {
+		t.Errorf("expected the import to rebuild the game, got %+v", imported)
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleImportRecord(rec, httptest.NewRequest("POST", "/archive/import", strings.NewReader("1. K a1 2. K a1 *")))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected an illegal record to be unprocessable, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+)
+
+// Who moves first, as chosen by the creator with ?first=
+const (
+	FirstMe       = "me"       // creator (player1) moves first; the default
+	FirstOpponent = "opponent" // player2 moves first
+	FirstRandom   = "random"   // decided by a coin flip when the game is created
+)
+
+// GameOptions are chosen by the creator on /ws and fixed for the life of the game.
+type GameOptions struct {
+	TimeControl *TimeControl // nil for an untimed game
+	First       string       // one of FirstMe, FirstOpponent, FirstRandom
+	Visibility  string       // VisibilityPublic or VisibilityUnlisted
+	Passphrase  string       // asked of whoever takes the second seat; "" for none
+	Name        string       // creator's display name in the lobby
+	Variant     string       // VariantStandard, or VariantCustom when Rules is set
+	Rated       bool         // listed as rated rather than casual
+	Position    string       // position string to start from; "" for the empty board
+	Rules       *Ruleset     // nil for StandardRules
+}
+
+// parseGameOptions reads creation options from the /ws query string.
+func parseGameOptions(query url.Values) (GameOptions, error) {
+	var opts GameOptions
+	tc, err := parseTimeControl(query.Get("time"), query.Get("moveTime"))
+	if err != nil {
+		return opts, err
+	}
+	opts.TimeControl = tc
+	switch first := query.Get("first"); first {
+	case "", FirstMe:
+		opts.First = FirstMe
+	case FirstOpponent, FirstRandom:
+		opts.First = first
+	default:
+		return opts, fmt.Errorf("invalid first mover %q", first)
+	}
+	if err := parseVisibility(query, &opts); err != nil {
+		return opts, err
+	}
+	if err := parseLobbyOptions(query, &opts); err != nil {
+		return opts, err
+	}
+	if position := query.Get("position"); position != "" {
+		gs, err := parseStateUnder(position, orStandard(opts.Rules))
+		if err != nil {
+			return opts, fmt.Errorf("invalid position: %v", err)
+		}
+		switch {
+		case gs.isOver():
+			return opts, fmt.Errorf("position is already over")
+		case query.Get("first") != "":
+			return opts, fmt.Errorf("first can't be combined with position; the position says who is to act")
+		case opts.Rated:
+			return opts, fmt.Errorf("games from a position can't be rated")
+		}
+		opts.Position = FormatState(gs)
+	}
+	return opts, nil
+}
+
+func (game *Game) applyOptions(opts GameOptions) {
+	game.options = opts
+	if opts.TimeControl != nil {
+		game.clock = newGameClock(*opts.TimeControl)
+	}
+	switch {
+	case opts.Position != "":
+		// Checked by parseGameOptions
+		gs, _ := parseStateUnder(opts.Position, orStandard(opts.Rules))
+		*game.GameState = *gs
+	case opts.First == FirstOpponent:
+		game.GameState.FirstMover = 2
+	case opts.First == FirstRandom:
+		game.GameState.FirstMover = uint8(1 + rand.Intn(2))
+	default:
+		game.GameState.FirstMover = 1
+	}
+	game.GameState.withRules(opts.Rules, opts.Position != "")
+	if opts.Visibility == VisibilityUnlisted {
+		game.inviteCode = generateSeatToken()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+// --- Option parsing ---
+
+func TestParseGameOptions_First(t *testing.T) {
+	opts, err := parseGameOptions(url.Values{})
+	if err != nil || opts.First != FirstMe {
+		t.Errorf("expected the creator to move first by default, got %q (%v)", opts.First, err)
+	}
+	opts, err = parseGameOptions(url.Values{"first": {"opponent"}})
+	if err != nil || opts.First != FirstOpponent {
+		t.Errorf("expected first=opponent to be accepted, got %q (%v)", opts.First, err)
+	}
+	if _, err := parseGameOptions(url.Values{"first": {"whoever"}}); err == nil {
+		t.Error("expected an unknown first mover to be rejected")
+	}
+}
+
+func TestApplyOptions_FirstMover(t *testing.T) {
+	game := NewGame()
+	game.applyOptions(GameOptions{First: FirstOpponent})
+	if game.GameState.FirstMover != 2 || !game.isValidTurn("player2") || game.isValidTurn("player1") {
+		t.Error("expected player2 to move first")
+	}
+
+	seen := map[uint8]bool{}
+	for i := 0; i < 64; i++ {
+		game := NewGame()
+		game.applyOptions(GameOptions{First: FirstRandom})
+		seen[game.GameState.FirstMover] = true
+	}
+	if len(seen) != 2 || !seen[1] || !seen[2] {
+		t.Errorf("expected a random first mover to pick both seats, got %v", seen)
+	}
+}
+
+// --- Engine with player 2 moving first ---
+
+func TestFirstMover_Player2PlacesOnTurnZero(t *testing.T) {
+	gs := NewGameState()
+	gs.FirstMover = 2
+
+	if _, _, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 0, Y: 0}, Piece: P1Kitten}); err == nil {
+		t.Error("expected player1's kitten to be rejected on turn 0")
+	}
+	next, _, err := Apply(*gs, Action{Type: ActionPlace, Position: Position{X: 0, Y: 0}, Piece: P2Kitten})
+	if err != nil {
+		t.Fatalf("expected player2 to place on turn 0: %v", err)
+	}
+	if next.Board[0][0] != P2Kitten || !next.isPlayer1() {
+		t.Errorf("expected a player2 kitten and player1 to move next, got tile %d", next.Board[0][0])
+	}
+}
+
+func TestFirstMover_Player2LinesAndWins(t *testing.T) {
+	gs := NewGameState()
+	gs.FirstMover = 2
+	gs.P2.Cats = 1
+	gs.P2.Placed = 2
+	gs.Board[0][0] = P2Cat
+	gs.Board[0][1] = P2Cat
+
+	if err := gs.Board.move(Position{X: 2, Y: 0}, P2Cat, gs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gs.Lines) != 1 || gs.Winner != 2 {
+		t.Errorf("expected player2's line to be found and win, got %d lines, Winner=%d", len(gs.Lines), gs.Winner)
+	}
+}
+
+func TestFirstMover_LegacyStateStartsWithPlayer1(t *testing.T) {
+	gs := NewGameState()
+	gs.FirstMover = 0
+	if !gs.isPlayer1() {
+		t.Error("expected a state persisted without a first mover to start with player1")
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Position strings describe a whole GameState on one line, like FEN in chess. Six
+// space-separated fields:
+//
+//	board      ranks 6 down to 1 split by "/", files a-f within a rank: K/C are player1's
+//	           kitten/cat, k/c player2's, a digit is a run of empty squares. Other board
+//	           sizes have as many ranks, and squares in a rank, as squares along a side
+//	p1 hand    kittens,cats player1 has left to place
+//	p2 hand    kittens,cats player2 has left to place
+//	side       1 or 2, the player to act
+//	turn       TurnNumber
+//	pending    "-" in WAITING, "L:" and the lines to choose from in MULTIPLE_WAITING
+//	           (squares joined by "-", lines by ","), "G" in MAX_WAITING, or "#" and the
+//	           winner (0 for a draw) then ":" and the result once the game is over
+//
+// Placed isn't written: it is always the number of the player's pieces on the board. The
+// first mover follows from the side and turn. The board size is the only rule a position
+// carries; the rest are the game's. Animation fields (placed, boopMovement and
+// so on) aren't part of a position.
+const StartPosition = "6/6/6/6/6/6 8,0 8,0 1 0 -"
+
+const (
+	positionWaiting  = "-"
+	positionLines    = "L:"
+	positionGraduate = "G"
+	positionOver     = "#"
+)
+
+var positionLetters = map[uint8]byte{P1Kitten: 'K', P1Cat: 'C', P2Kitten: 'k', P2Cat: 'c'}
+
+// FormatState writes gs as a position string. ParseState(FormatState(gs)) plays on
+// exactly as gs does.
+func FormatState(gs *GameState) string {
+	var board strings.Builder
+	size := gs.boardSize()
+	for y := size - 1; y >= 0; y-- {
+		empty := 0
+		for _, tile := range gs.Board[y][:size] {
+			letter, ok := positionLetters[tile]
+			if !ok {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				board.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			board.WriteByte(letter)
+		}
+		if empty > 0 {
+			board.WriteString(strconv.Itoa(empty))
+		}
+		if y > 0 {
+			board.WriteByte('/')
+		}
+	}
+
+	var pending string
+	switch {
+	case gs.isOver():
+		pending = positionOver + strconv.Itoa(int(gs.Winner))
+		if gs.Result != "" {
+			pending += ":" + gs.Result
+		}
+	case gs.State == "MULTIPLE_WAITING":
+		lines := make([]string, len(gs.Lines))
+		for i, line := range gs.Lines {
+			squares := make([]string, len(line))
+			for j, pos := range line {
+				squares[j] = formatSquare(pos)
+			}
+			lines[i] = strings.Join(squares, "-")
+		}
+		pending = positionLines + strings.Join(lines, ",")
+	case gs.State == "MAX_WAITING":
+		pending = positionGraduate
+	default:
+		pending = positionWaiting
+	}
+
+	return strings.Join([]string{
+		board.String(),
+		formatHand(gs.P1),
+		formatHand(gs.P2),
+		strconv.Itoa(int(moverNumber(gs))),
+		strconv.Itoa(int(gs.TurnNumber)),
+		pending,
+	}, " ")
+}
+
+func formatHand(p Player) string {
+	return fmt.Sprintf("%d,%d", p.Kittens, p.Cats)
+}
+
+// ParseState reads a position string into a fresh GameState under the standard rules for
+// its board size, rejecting positions the engine could not be in: pieces that don't add up,
+// pending lines that aren't on the board, a MAX_WAITING player with pieces in hand, a win
+// the game didn't end on, and so on.
+func ParseState(text string) (*GameState, error) {
+	return parseStateUnder(text, nil)
+}
+
+// parseStateUnder is ParseState for a game played under rules, which must be for the
+// position's board size. ParseState passes nil for the standard rules at any size.
+func parseStateUnder(text string, rules *Ruleset) (*GameState, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("position needs 6 fields, got %d", len(fields))
+	}
+	gs := NewGameState()
+	size, err := parseBoard(fields[0], &gs.Board)
+	if err != nil {
+		return nil, err
+	}
+	if size != StandardRules.BoardSize {
+		sized := StandardRules
+		sized.BoardSize = size
+		gs.Rules = &sized
+	}
+	if rules != nil && !sameBoardSize(rules, gs.Rules) {
+		return nil, fmt.Errorf("position is for a %dx%d board; the rules set boardSize=%d", size, size, rules.BoardSize)
+	}
+	gs.withRules(rules, true)
+	if gs.P1, err = parseHand(fields[1]); err != nil {
+		return nil, fmt.Errorf("player1 hand: %v", err)
+	}
+	if gs.P2, err = parseHand(fields[2]); err != nil {
+		return nil, fmt.Errorf("player2 hand: %v", err)
+	}
+	for _, row := range gs.Board {
+		for _, tile := range row {
+			switch tileOwner(tile) {
+			case 1:
+				gs.P1.Placed++
+			case 2:
+				gs.P2.Placed++
+			}
+		}
+	}
+	gs.PreviousBoard = gs.Board
+
+	side, err := strconv.ParseUint(fields[3], 10, 8)
+	if err != nil || (side != 1 && side != 2) {
+		return nil, fmt.Errorf("side to move must be 1 or 2, got %q", fields[3])
+	}
+	turn, err := strconv.ParseUint(fields[4], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("bad turn number %q", fields[4])
+	}
+	gs.TurnNumber = uint8(turn)
+	// The first mover acts on even turns
+	gs.FirstMover = uint8(side)
+	if turn%2 == 1 {
+		gs.FirstMover = 3 - uint8(side)
+	}
+
+	if err := parsePending(fields[5], gs); err != nil {
+		return nil, err
+	}
+	if err := checkPosition(gs); err != nil {
+		return nil, err
+	}
+	return gs, nil
+}
+
+// checkPosition rejects what the rules can't reach once the position is read: a player
+// whose hand and board don't add up to the pieces dealt, a win for the player who just
+// moved that didn't end the game, or a player to act with nothing they can do. A line of
+// theirs that would graduate is allowed, as choosing between lines leaves the others.
+func checkPosition(gs *GameState) error {
+	pieces := int(gs.rules().Pieces)
+	for n, p := range []Player{gs.P1, gs.P2} {
+		if total := int(p.Kittens) + int(p.Cats) + int(p.Placed); total != pieces {
+			return fmt.Errorf("player%d has %d pieces, the rules deal %d", n+1, total, pieces)
+		}
+	}
+	if gs.isOver() {
+		return nil
+	}
+	if gs.State == "WAITING" {
+		last := *gs
+		last.FirstMover = 3 - gs.firstMover()
+		last.Board.checkBoardForThreeInARows(&last)
+		if last.Winner != 0 || last.Board.winCheckMaxCats(&last) {
+			return fmt.Errorf("player%d has already won on the board", moverNumber(&last))
+		}
+	}
+	if len(LegalMoves(gs)) == 0 {
+		return fmt.Errorf("player%d has no legal action", moverNumber(gs))
+	}
+	return nil
+}
+
+// parseBoard reads the board field into board and returns its size.
+func parseBoard(field string, board *Board) (uint8, error) {
+	ranks := strings.Split(field, "/")
+	size := len(ranks)
+	if size < minBoardSize || size > maxBoardSize {
+		return 0, fmt.Errorf("board needs %d to %d ranks, got %d", minBoardSize, maxBoardSize, size)
+	}
+	for i, rank := range ranks {
+		y := size - 1 - i
+		x := 0
+		for _, r := range rank {
+			if r >= '1' && r <= '9' {
+				x += int(r - '0')
+				continue
+			}
+			tile := uint8(0)
+			for t, letter := range positionLetters {
+				if rune(letter) == r {
+					tile = t
+				}
+			}
+			if tile == 0 {
+				return 0, fmt.Errorf("rank %d: unknown piece %q", y+1, r)
+			}
+			if x >= size {
+				return 0, fmt.Errorf("rank %d has more than %d squares", y+1, size)
+			}
+			board[y][x] = tile
+			x++
+		}
+		if x != size {
+			return 0, fmt.Errorf("rank %d needs %d squares", y+1, size)
+		}
+	}
+	return uint8(size), nil
+}
+
+func parseHand(field string) (Player, error) {
+	kittens, cats, ok := strings.Cut(field, ",")
+	k, err1 := strconv.ParseUint(kittens, 10, 8)
+	c, err2 := strconv.ParseUint(cats, 10, 8)
+	if !ok || err1 != nil || err2 != nil {
+		return Player{}, fmt.Errorf("expected kittens,cats, got %q", field)
+	}
+	return Player{Kittens: uint8(k), Cats: uint8(c)}, nil
+}
+
+func parsePending(field string, gs *GameState) error {
+	switch {
+	case field == positionWaiting:
+		return nil
+
+	case strings.HasPrefix(field, positionLines):
+		var lines [][]Position
+		for _, text := range strings.Split(strings.TrimPrefix(field, positionLines), ",") {
+			var line []Position
+			for _, square := range strings.Split(text, "-") {
+				pos, err := parseSquare(square, gs.boardSize())
+				if err != nil {
+					return err
+				}
+				line = append(line, pos)
+			}
+			lines = append(lines, line)
+		}
+		// The choice is exactly the mover's lines on the board, in the order the engine finds them
+		check := *gs
+		check.Board.checkBoardForThreeInARows(&check)
+		if len(check.Lines) < 2 || check.Winner != 0 || !slices.EqualFunc(lines, check.Lines, slices.Equal[[]Position]) {
+			return fmt.Errorf("pending lines don't match the mover's lines on the board")
+		}
+		gs.State = "MULTIPLE_WAITING"
+		gs.Lines = check.Lines
+		gs.ThreeChoices = check.ThreeChoices
+		return nil
+
+	case field == positionGraduate:
+		hand := gs.P1
+		if moverNumber(gs) == 2 {
+			hand = gs.P2
+		}
+		if hand.Kittens+hand.Cats > 0 {
+			return fmt.Errorf("MAX_WAITING needs the mover to have nothing left to place")
+		}
+		gs.State = "MAX_WAITING"
+		return nil
+
+	case strings.HasPrefix(field, positionOver):
+		winner, result, _ := strings.Cut(strings.TrimPrefix(field, positionOver), ":")
+		w, err := strconv.ParseUint(winner, 10, 8)
+		if err != nil || w > 2 {
+			return fmt.Errorf("winner must be 0, 1 or 2, got %q", winner)
+		}
+		switch result {
+		case ResultThreeCats, ResultLine, ResultEightCats, ResultResignation, ResultTimeout, ResultAbandonment:
+			if w == 0 {
+				return fmt.Errorf("a %s needs a winner", result)
+			}
+		case ResultDraw:
+			if w != 0 {
+				return fmt.Errorf("a draw has no winner")
+			}
+		case "":
+			// States saved before results were recorded only have a winner
+			if w == 0 {
+				return fmt.Errorf("a finished game needs a winner or a result")
+			}
+		default:
+			return fmt.Errorf("unknown result %q", result)
+		}
+		gs.finish(uint8(w), result)
+		return nil
+	}
+	return fmt.Errorf("unknown pending state %q", field)
+}
+
+// loadStartPosition returns the position a game was started from, or "" for the usual
+// empty board.
+func (s *Server) loadStartPosition(gameID string) (string, error) {
+	var position string
+	err := s.db.QueryRow(`SELECT position FROM game_positions WHERE game_id = ?`, gameID).Scan(&position)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return position, err
+}
+
+// startState is the state a game under rules begins in: position, or the empty board with
+// firstMover to act.
+func startState(position string, firstMover uint8, rules *Ruleset) (*GameState, error) {
+	gs := NewGameState()
+	gs.FirstMover = firstMover
+	if position != "" {
+		return parseStateUnder(position, orStandard(rules))
+	}
+	gs.withRules(rules, false)
+	return gs, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// --- Helpers ---
+
+func mustParseState(t *testing.T, position string) *GameState {
+	t.Helper()
+	gs, err := ParseState(position)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", position, err)
+	}
+	return gs
+}
+
+// Two player1 kittens on a1 and b1; a kitten on c1 makes a line
+const lineInOnePosition = "5k/6/6/6/6/KK4 6,0 7,0 1 6 -"
+
+// --- Position strings ---
+
+func TestPosition_Start(t *testing.T) {
+	if got := FormatState(NewGameState()); got != StartPosition {
+		t.Errorf("expected the new game to be %q, got %q", StartPosition, got)
+	}
+	gs := mustParseState(t, "6/6/6/6/6/6 8,0 8,0 2 0 -")
+	if gs.FirstMover != 2 || moverNumber(gs) != 2 || LegalMoves(gs)[0].Piece != P2Kitten {
+		t.Errorf("expected player2 to open, got first mover %d", gs.FirstMover)
+	}
+}
+
+func TestPosition_Squares(t *testing.T) {
+	gs := mustParseState(t, "c5/6/6/6/6/K1C2k 5,1 5,1 2 3 -")
+	if gs.Board[0][0] != P1Kitten || gs.Board[0][2] != P1Cat || gs.Board[0][5] != P2Kitten || gs.Board[5][0] != P2Cat {
+		t.Errorf("expected rank 1 last and file a first, got %v", gs.Board)
+	}
+	if gs.P1.Placed != 2 || gs.P2.Placed != 2 || gs.P1.Kittens != 5 || gs.P2.Kittens != 5 || gs.P2.Cats != 1 {
+		t.Errorf("expected hands as written and Placed counted from the board, got %+v %+v", gs.P1, gs.P2)
+	}
+	if gs.TurnNumber != 3 || moverNumber(gs) != 2 || gs.firstMover() != 1 {
+		t.Errorf("expected player2 to act on turn 3 after player1 opened, got turn %d first %d", gs.TurnNumber, gs.FirstMover)
+	}
+}
+
+func TestPosition_RoundTripsThroughPlay(t *testing.T) {
+	seen := map[string]bool{}
+	for seed := int64(1); seed <= 20; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		state := *NewGameState()
+		state.FirstMover = uint8(1 + seed%2)
+		for n := 0; n < 400 && !state.isOver(); n++ {
+			text := FormatState(&state)
+			parsed := mustParseState(t, text)
+			if FormatState(parsed) != text {
+				t.Fatalf("expected %q to round-trip, got %q", text, FormatState(parsed))
+			}
+			if parsed.Board != state.Board || parsed.P1 != state.P1 || parsed.P2 != state.P2 || parsed.State != state.State ||
+				parsed.TurnNumber != state.TurnNumber || parsed.firstMover() != state.firstMover() {
+				t.Fatalf("expected %q to restore the state, got %+v", text, parsed)
+			}
+			if state.State == "MULTIPLE_WAITING" && !slices.Equal(parsed.ThreeChoices, state.ThreeChoices) {
+				t.Fatalf("expected %q to restore the line choices", text)
+			}
+			seen[state.State] = true
+
+			// Both play on identically
+			legal := LegalMoves(&state)
+			if !slices.Equal(LegalMoves(parsed), legal) {
+				t.Fatalf("expected %q to have the same legal moves", text)
+			}
+			action := legal[rng.Intn(len(legal))]
+			next, _, err := Apply(state, action)
+			fromParsed, _, err2 := Apply(*parsed, action)
+			if err != nil || err2 != nil || FormatState(&next) != FormatState(&fromParsed) {
+				t.Fatalf("expected %q and its copy to agree after %+v", text, action)
+			}
+			state = next
+		}
+		if state.isOver() {
+			text := FormatState(&state)
+			if FormatState(mustParseState(t, text)) != text {
+				t.Errorf("expected the finished position %q to round-trip", text)
+			}
+			seen["GAME_OVER"] = true
+		}
+	}
+	for _, state := range []string{"WAITING", "MULTIPLE_WAITING", "MAX_WAITING", "GAME_OVER"} {
+		if !seen[state] {
+			t.Errorf("expected the random games to reach %s", state)
+		}
+	}
+}
+
+func TestPosition_RejectsImpossiblePositions(t *testing.T) {
+	for text, want := range map[string]string{
+		"6/6/6 8,0 8,0 1 0 -":                            "4 to 8 ranks",
+		"6/6/6/6/6 8,0 8,0 1 0 -":                        "needs 5 squares",
+		"7/6/6/6/6/6 8,0 8,0 1 0 -":                      "needs 6 squares",
+		"KKKKKKK/6/6/6/6/6 1,0 8,0 1 0 -":                "more than 6",
+		"x5/6/6/6/6/6 8,0 8,0 1 0 -":                     "unknown piece",
+		"6/6/6/6/6/6 8 8,0 1 0 -":                        "kittens,cats",
+		"6/6/6/6/6/6 8,0 8,0 3 0 -":                      "1 or 2",
+		"6/6/6/6/6/6 8,0 8,0 1 0":                        "6 fields",
+		"6/6/6/6/6/KKK3 5,0 8,0 1 1 L:a1-b1-c1,a1-b1-c1": "don't match",
+		"6/6/6/6/6/KK4 6,0 8,0 1 0 G":                    "nothing left",
+		"6/6/6/6/6/6 8,0 8,0 1 0 #0:threeCats":           "needs a winner",
+		"6/6/6/6/6/6 8,0 8,0 1 0 ?":                      "pending",
+		"6/6/6/6/6/6 0,0 8,0 1 0 -":                      "player1 has 0 pieces",
+		"6/6/6/6/6/6 200,0 8,0 1 0 -":                    "player1 has 200 pieces",
+		"6/6/6/6/6/K5 7,1 8,0 2 1 -":                     "player1 has 9 pieces",
+		"CCC3/6/6/6/6/6 5,0 8,0 2 1 -":                   "player1 has already won",
+		"KKkk/kkKK/KKkk/kkKK 0,0 0,0 1 1 -":              "no legal action",
+	} {
+		if _, err := ParseState(text); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected an error mentioning %q, got %v", text, want, err)
+		}
+	}
+}
+
+// Choosing one of several lines leaves the others on the board for later.
+func TestPosition_AllowsLeftoverLine(t *testing.T) {
+	gs := mustParseState(t, "4k1/6/5C/4K1/k1kC2/5k 2,3 1,3 2 35 -")
+	last := *gs
+	last.FirstMover = 3 - gs.firstMover()
+	last.Board.checkBoardForThreeInARows(&last)
+	if len(last.Lines) != 1 {
+		t.Errorf("expected player1's d2-e3-f4 line to be left on the board, got %v", last.Lines)
+	}
+}
+
+// --- Games from a position ---
+
+func TestPosition_GameOption(t *testing.T) {
+	opts, err := parseGameOptions(url.Values{"position": {"5k/6/6/6/6/KK4  6,0 7,0 1 6 -"}})
+	if err != nil || opts.Position != lineInOnePosition {
+		t.Fatalf("expected the position to be stored canonically, got %q (%v)", opts.Position, err)
+	}
+	for _, bad := range []url.Values{
+		{"position": {"nonsense"}},
+		{"position": {"6/6/6/6/6/6 8,0 8,0 1 0 #1:resignation"}},
+		{"position": {lineInOnePosition}, "first": {"opponent"}},
+		{"position": {lineInOnePosition}, "rated": {"true"}},
+		{"position": {StartPosition}, "pieces": {"6"}},
+	} {
+		if _, err := parseGameOptions(bad); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+
+	if _, err := parseGameOptions(url.Values{"position": {"6/6/6/6/6/6 6,0 6,0 1 0 -"}, "pieces": {"6"}}); err != nil {
+		t.Errorf("expected a position dealing the game's pieces to be accepted, got %v", err)
+	}
+
+	s := NewServer()
+	game := s.createGame(nil, opts, nil)
+	if FormatState(game.GameState) != lineInOnePosition {
+		t.Errorf("expected the game to start from the position, got %q", FormatState(game.GameState))
+	}
+	if lg := game.lobbyEntry(); lg.Position != lineInOnePosition {
+		t.Errorf("expected the lobby to show the position, got %+v", lg)
+	}
+}
+
+func TestPosition_ArchivedGameReplaysFromPosition(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newSeatedGame(s)
+	game.applyOptions(GameOptions{Position: lineInOnePosition})
+	s.saveNewGame(game)
+	playAction(t, game, placeAction(P1Kitten, 2, 0))
+	if got := FormatState(game.GameState); got != "5k/6/6/6/6/6 5,3 7,0 2 7 -" {
+		t.Fatalf("expected the line to graduate, got %q", got)
+	}
+	game.resign(s, "player2")
+
+	replay, err := s.loadReplay(game.ID)
+	if err != nil || replay.Game.Position != lineInOnePosition || FormatState(&replay.States[0]) != lineInOnePosition {
+		t.Fatalf("expected the replay to start from the position, got %+v (%v)", replay, err)
+	}
+	if replay.States[1].Board != game.GameState.Board {
+		t.Error("expected the replay to reach the final board")
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleExportRecord(rec, httptest.NewRequest("GET", "/archive/export?id="+game.ID, nil))
+	if !strings.Contains(rec.Body.String(), `[Position "`+lineInOnePosition+`"]`) {
+		t.Fatalf("expected the export to carry the position, got\n%s", rec.Body.String())
+	}
+	rec2 := httptest.NewRecorder()
+	s.handleImportRecord(rec2, httptest.NewRequest("POST", "/archive/import", strings.NewReader(rec.Body.String())))
+	if rec2.Code != http.StatusOK {
+		t.Errorf("expected the record to import from its position, got %d: %s", rec2.Code, rec2.Body.String())
+	}
+
+	if position, _ := s.loadStartPosition(game.ID); position != lineInOnePosition {
+		t.Errorf("expected the start position to be saved, got %q", position)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"unicode/utf8"
+)
+
+// Who can find a waiting game, as chosen by the creator with ?visibility=
+const (
+	VisibilityPublic   = "public"   // listed by /getWaitingGame; the default
+	VisibilityUnlisted = "unlisted" // joinable only with the invite code
+)
+
+const maxPassphraseLength = 64
+
+var (
+	errBadInvite     = errors.New("invalid invite code")
+	errBadPassphrase = errors.New("wrong passphrase")
+)
+
+// Invite is how the creator of an unlisted game brings in an opponent. Sent on their "joined" only.
+type Invite struct {
+	Code string `json:"code"`
+	URL  string `json:"url"` // ORIGIN_URL/?gameID=...&invite=..., relative when ORIGIN_URL is unset
+}
+
+// parseVisibility reads the visibility and passphrase options.
+func parseVisibility(query url.Values, opts *GameOptions) error {
+	switch visibility := query.Get("visibility"); visibility {
+	case "", VisibilityPublic:
+		opts.Visibility = VisibilityPublic
+	case VisibilityUnlisted:
+		opts.Visibility = visibility
+	default:
+		return fmt.Errorf("invalid visibility %q", visibility)
+	}
+	passphrase := query.Get("passphrase")
+	if utf8.RuneCountInString(passphrase) > maxPassphraseLength {
+		return fmt.Errorf("passphrase is longer than %d characters", maxPassphraseLength)
+	}
+	opts.Passphrase = passphrase
+	return nil
+}
+
+// listed reports whether the game shows up in the public lobby.
+func (game *Game) listed() bool {
+	return game.options.Visibility != VisibilityUnlisted
+}
+
+// invite is the creator's invite for an unlisted game, or nil for a public one.
+func (game *Game) invite() *Invite {
+	if game.inviteCode == "" {
+		return nil
+	}
+	query := url.Values{"gameID": {game.ID}, "invite": {game.inviteCode}}
+	return &Invite{
+		Code: game.inviteCode,
+		URL:  os.Getenv("ORIGIN_URL") + "/?" + query.Encode(),
+	}
+}
+
+// admits checks an invite code and passphrase offered for the second seat.
+func (game *Game) admits(invite string, passphrase string) error {
+	if game.inviteCode != "" && subtle.ConstantTimeCompare([]byte(game.inviteCode), []byte(invite)) != 1 {
+		return errBadInvite
+	}
+	if game.options.Passphrase != "" {
+		// Hash first so the comparison doesn't leak the passphrase length
+		want := sha256.Sum256([]byte(game.options.Passphrase))
+		got := sha256.Sum256([]byte(passphrase))
+		if subtle.ConstantTimeCompare(want[:], got[:]) != 1 {
+			return errBadPassphrase
+		}
+	}
+	return nil
+}
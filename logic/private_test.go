@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// --- Helpers ---
+
+func waitingGameIDs(t *testing.T, s *Server) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleGetWaitingGameID(rec, httptest.NewRequest("GET", "/getWaitingGame", nil))
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unreadable lobby response: %v", err)
+	}
+	return body.IDs
+}
+
+// --- Option parsing ---
+
+func TestParseGameOptions_Visibility(t *testing.T) {
+	opts, err := parseGameOptions(url.Values{})
+	if err != nil || opts.Visibility != VisibilityPublic || opts.Passphrase != "" {
+		t.Errorf("expected a public game without a passphrase by default, got %+v (%v)", opts, err)
+	}
+	opts, err = parseGameOptions(url.Values{"visibility": {"unlisted"}, "passphrase": {"open sesame"}})
+	if err != nil || opts.Visibility != VisibilityUnlisted || opts.Passphrase != "open sesame" {
+		t.Errorf("expected an unlisted game with a passphrase, got %+v (%v)", opts, err)
+	}
+	if _, err := parseGameOptions(url.Values{"visibility": {"secret"}}); err == nil {
+		t.Error("expected an unknown visibility to be rejected")
+	}
+	if _, err := parseGameOptions(url.Values{"passphrase": {strings.Repeat("x", maxPassphraseLength+1)}}); err == nil {
+		t.Error("expected an overlong passphrase to be rejected")
+	}
+}
+
+// --- Lobby and joining ---
+
+func TestUnlistedGame_HiddenFromLobby(t *testing.T) {
+	s := NewServer()
+	public := s.createGame(nil, GameOptions{Visibility: VisibilityPublic}, nil)
+	unlisted := s.createGame(nil, GameOptions{Visibility: VisibilityUnlisted}, nil)
+
+	ids := waitingGameIDs(t, s)
+	if len(ids) != 1 || ids[0] != public.ID {
+		t.Errorf("expected only %s in the lobby, got %v (unlisted %s)", public.ID, ids, unlisted.ID)
+	}
+	if public.invite() != nil {
+		t.Error("expected no invite for a public game")
+	}
+}
+
+func TestUnlistedGame_JoinNeedsInvite(t *testing.T) {
+	s := NewServer()
+	game := s.createGame(nil, GameOptions{Visibility: VisibilityUnlisted}, nil)
+
+	if _, err := s.joinGame(nil, game.ID, "", "", nil); !errors.Is(err, errBadInvite) {
+		t.Errorf("expected a join without the invite code to be refused, got %v", err)
+	}
+	invite := game.invite()
+	if invite == nil || !strings.Contains(invite.URL, "gameID="+game.ID) || !strings.Contains(invite.URL, "invite="+invite.Code) {
+		t.Fatalf("expected an invite URL carrying the game ID and code, got %+v", invite)
+	}
+	joined, err := s.joinGame(nil, game.ID, invite.Code, "", nil)
+	if err != nil || joined != game {
+		t.Errorf("expected the invite code to seat player2, got %v", err)
+	}
+}
+
+func TestPassphrase_VerifiedOnJoin(t *testing.T) {
+	s := NewServer()
+	game := s.createGame(nil, GameOptions{Visibility: VisibilityPublic, Passphrase: "hunter2"}, nil)
+
+	if _, err := s.joinGame(nil, game.ID, "", "hunter3", nil); !errors.Is(err, errBadPassphrase) {
+		t.Errorf("expected a wrong passphrase to be refused, got %v", err)
+	}
+	if _, waiting := s.waitingGames[game.ID]; !waiting {
+		t.Error("expected the game to keep waiting after a refused join")
+	}
+	if _, err := s.joinGame(nil, game.ID, "", "hunter2", nil); err != nil {
+		t.Errorf("expected the right passphrase to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Protocol versions. v1 is the original untyped frame ({position, piece}, with piece 99 as a
+// pong); typed frames are accepted too. v2 requires every inbound frame to carry a type.
+// Clients ask for a version with ?v=N on /ws; "joined" carries the version the server will speak.
+const (
+	protocolV1      = 1
+	protocolV2      = 2
+	protocolVersion = protocolV2 // newest version this server speaks
+)
+
+// Inbound frame types
+const (
+	frameMove             = "move"          // WAITING: position + piece (0 kitten, 1 cat)
+	frameGraduateLine     = "graduateLine"  // MULTIPLE_WAITING: middle position of the chosen line
+	frameGraduatePiece    = "graduatePiece" // MAX_WAITING: position of the piece to graduate
+	framePong             = "pong"
+	frameResign           = "resign"
+	frameChat             = "chat" // text
+	frameTakebackRequest  = "takebackRequest"
+	frameTakebackResponse = "takebackResponse" // accept
+	frameDrawOffer        = "drawOffer"
+	frameDrawResponse     = "drawResponse" // accept
+	frameRematchRequest   = "rematchRequest"
+	frameRematchResponse  = "rematchResponse" // accept
+	frameQueueCancel      = "queueCancel"     // leave the matchmaking queue before being paired
+	frameReplayForward    = "replayForward"   // replay viewers only: the next ply, animated
+	frameReplayBack       = "replayBack"      // replay viewers only: the previous ply
+	frameReplaySeek       = "replaySeek"      // replay viewers only: jump to ply
+)
+
+// Error codes carried in Message.Code so clients don't have to match on text.
+const (
+	CodeBadFrame           = "bad_frame"
+	CodeUnknownType        = "unknown_type"
+	CodeNotYourTurn        = "not_your_turn"
+	CodeIllegalMove        = "illegal_move"
+	CodeGameOver           = "game_over"
+	CodeTakeback           = "takeback_unavailable"
+	CodeDraw               = "draw_unavailable"
+	CodeRematch            = "rematch_unavailable"
+	CodeBadChat            = "bad_chat"
+	CodeJoinFailed         = "join_failed"
+	CodeBadPassphrase      = "bad_passphrase"
+	CodeRejoinFailed       = "rejoin_failed"
+	CodeSpectateFailed     = "spectate_failed"
+	CodeBotUnavailable     = "bot_unavailable"
+	CodeBadOptions         = "bad_options"
+	CodeBadSession         = "bad_session"
+	CodeQueued             = "queued"
+	CodeReplay             = "replay_unavailable"
+	CodeUnsupportedVersion = "unsupported_version"
+)
+
+const maxChatLength = 200 // runes
+
+// Inbound is every frame a client can send. Fields other than Type depend on the type.
+type Inbound struct {
+	Type     string      `json:"type"`
+	Position Position    `json:"position"`
+	Piece    json.Number `json:"piece,omitempty"`
+	Accept   bool        `json:"accept,omitempty"`
+	Text     string      `json:"text,omitempty"`
+	Ply      int         `json:"ply,omitempty"`
+}
+
+type ChatMessage struct {
+	Seat string `json:"seat"`
+	Text string `json:"text"`
+}
+
+// ProtocolError is a rejected frame, reported back to the sender with its code.
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string { return e.Message }
+
+var errDisconnected = errors.New("disconnected")
+
+// parseVersion picks the protocol version for a connection from the ?v= query value.
+// No value means v1; anything newer than this server is answered with protocolVersion.
+func parseVersion(raw string) (int, error) {
+	if raw == "" {
+		return protocolV1, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < protocolV1 {
+		return 0, fmt.Errorf("unsupported protocol version %q", raw)
+	}
+	return min(v, protocolVersion), nil
+}
+
+// decodeFrame parses one inbound frame. Untyped v1 frames keep an empty Type, meaning
+// "whatever the current state expects", except piece 99 which is a pong.
+func decodeFrame(data []byte, version int) (Inbound, error) {
+	var frame Inbound
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return frame, &ProtocolError{Code: CodeBadFrame, Message: "Malformed frame"}
+	}
+	if frame.Type != "" {
+		return frame, nil
+	}
+	if version >= protocolV2 {
+		return frame, &ProtocolError{Code: CodeBadFrame, Message: "Frame has no type"}
+	}
+	if piece, _ := frame.Piece.Int64(); piece == 99 {
+		frame.Type = framePong
+	}
+	return frame, nil
+}
+
+// expectedFrame is the move frame type the given state accepts.
+func expectedFrame(state string) string {
+	switch state {
+	case "MULTIPLE_WAITING":
+		return frameGraduateLine
+	case "MAX_WAITING":
+		return frameGraduatePiece
+	}
+	return frameMove
+}
+
+// readFrame blocks for the next frame from a player. It returns errDisconnected once the
+// connection is gone; a frame that can't be decoded is reported to the sender and skipped.
+func (game *Game) readFrame(conn *websocket.Conn, playerID string, version int) (Inbound, error) {
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		if websocket.IsCloseError(err, websocket.CloseAbnormalClosure, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+			log.Printf("WebSocket closed for %s: %v", playerID, err)
+		} else if websocket.IsUnexpectedCloseError(err) {
+			log.Printf("Unexpected WebSocket close for %s: %v", playerID, err)
+		} else {
+			log.Printf("Read error from %s: %v", playerID, err)
+		}
+		return Inbound{}, errDisconnected
+	}
+	return decodeFrame(data, version)
+}
+
+// handleFrame acts on one decoded frame from playerID.
+func (game *Game) handleFrame(s *Server, conn *websocket.Conn, playerID string, frame Inbound) error {
+	switch frame.Type {
+	case framePong:
+		log.Printf("Pong received from %s", playerID)
+		if err := conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+			log.Printf("Failed to set read deadline for %s: %v", playerID, err)
+			return errDisconnected
+		}
+		return nil
+	case "", frameMove, frameGraduateLine, frameGraduatePiece:
+		return game.handleMove(s, playerID, frame)
+	case frameResign:
+		return game.resign(s, playerID)
+	case frameChat:
+		return game.relayChat(playerID, frame.Text)
+	case frameTakebackRequest:
+		if err := game.requestTakeback(s, playerID); err != nil {
+			return &ProtocolError{Code: CodeTakeback, Message: err.Error()}
+		}
+		return nil
+	case frameTakebackResponse:
+		if err := game.answerTakeback(s, playerID, frame.Accept); err != nil {
+			return &ProtocolError{Code: CodeTakeback, Message: err.Error()}
+		}
+		return nil
+	case frameDrawOffer:
+		if err := game.offerDraw(s, playerID); err != nil {
+			return &ProtocolError{Code: CodeDraw, Message: err.Error()}
+		}
+		return nil
+	case frameDrawResponse:
+		if err := game.answerDraw(s, playerID, frame.Accept); err != nil {
+			return &ProtocolError{Code: CodeDraw, Message: err.Error()}
+		}
+		return nil
+	case frameRematchRequest:
+		if err := game.requestRematch(s, playerID); err != nil {
+			return &ProtocolError{Code: CodeRematch, Message: err.Error()}
+		}
+		return nil
+	case frameRematchResponse:
+		if err := game.answerRematch(s, playerID, frame.Accept); err != nil {
+			return &ProtocolError{Code: CodeRematch, Message: err.Error()}
+		}
+		return nil
+	}
+	return &ProtocolError{Code: CodeUnknownType, Message: fmt.Sprintf("Unknown frame type %q", frame.Type)}
+}
+
+// handleMove runs a move or selection frame through the engine and commits it. The seat
+// and frame are checked in the same critical section that applies the move, so a
+// takeback or bot move landing first can't let playerID act out of turn.
+func (game *Game) handleMove(s *Server, playerID string, frame Inbound) error {
+	move := &NewMove{Position: frame.Position, Piece: frame.Piece}
+	if move.Piece == "" {
+		move.Piece = "0"
+	}
+
+	game.mutex.Lock()
+	perr := game.checkMove(playerID, frame.Type)
+	if perr == nil {
+		if _, err := game.applyAction(actionFromMove(game.GameState, move)); err != nil {
+			perr = &ProtocolError{Code: CodeIllegalMove, Message: err.Error()}
+		}
+	}
+	game.mutex.Unlock()
+	if perr != nil {
+		return perr
+	}
+	game.commitTurn(s)
+	return nil
+}
+
+// checkMove rejects a move frame from playerID that the game can't take right now.
+// Caller must hold game.mutex.
+func (game *Game) checkMove(playerID string, frameType string) *ProtocolError {
+	if game.GameState.isOver() {
+		return &ProtocolError{Code: CodeGameOver, Message: "Game is over"}
+	}
+	if !game.isValidTurn(playerID) {
+		log.Printf("Not your turn %s", playerID)
+		return &ProtocolError{Code: CodeNotYourTurn, Message: "Not your turn"}
+	}
+	state := game.GameState.State
+	if expected := expectedFrame(state); frameType != "" && frameType != expected {
+		return &ProtocolError{Code: CodeIllegalMove, Message: fmt.Sprintf("Expected %s in state %s", expected, state)}
+	}
+	return nil
+}
+
+// relayChat passes a short text message from a player to everyone in the game.
+func (game *Game) relayChat(playerID string, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" || utf8.RuneCountInString(text) > maxChatLength {
+		return &ProtocolError{Code: CodeBadChat, Message: fmt.Sprintf("Chat must be 1-%d characters", maxChatLength)}
+	}
+	msg := Message{
+		Type:     "chat",
+		GameID:   game.ID,
+		PlayerID: playerID,
+		State:    game.GameState.State,
+		Payload:  ChatMessage{Seat: playerID, Text: text},
+	}
+	select {
+	case game.send <- msg:
+	default:
+		log.Printf("Send channel full, dropping chat for game %s", game.ID)
+	}
+	return nil
+}
+
+// sendError reports a rejected frame to the seat that sent it.
+func (game *Game) sendError(playerID string, err *ProtocolError) {
+	msg := Message{
+		Type:     "error",
+		GameID:   game.ID,
+		PlayerID: playerID,
+		State:    game.GameState.State,
+		Code:     err.Code,
+		Payload:  err.Message,
+		To:       playerID,
+	}
+	select {
+	case game.send <- msg:
+	default:
+		log.Printf("Send channel full, dropping %s error for game %s", err.Code, game.ID)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// --- Helpers ---
+
+func expectCode(t *testing.T, err error, code string) {
+	t.Helper()
+	var perr *ProtocolError
+	if !errors.As(err, &perr) || perr.Code != code {
+		t.Errorf("expected %s error, got %v", code, err)
+	}
+}
+
+// --- Version negotiation ---
+
+func TestParseVersion(t *testing.T) {
+	if v, err := parseVersion(""); err != nil || v != protocolV1 {
+		t.Errorf("expected v1 when no version is asked for, got %d (%v)", v, err)
+	}
+	if v, err := parseVersion("2"); err != nil || v != protocolV2 {
+		t.Errorf("expected v2, got %d (%v)", v, err)
+	}
+	if v, err := parseVersion("99"); err != nil || v != protocolVersion {
+		t.Errorf("expected newer clients to get protocolVersion, got %d (%v)", v, err)
+	}
+	if _, err := parseVersion("0"); err == nil {
+		t.Error("expected version 0 to be rejected")
+	}
+	if _, err := parseVersion("two"); err == nil {
+		t.Error("expected a non-numeric version to be rejected")
+	}
+}
+
+// --- Frame decoding ---
+
+func TestDecodeFrame_LegacyFrames(t *testing.T) {
+	frame, err := decodeFrame([]byte(`{"position":{"x":0,"y":0},"piece":99}`), protocolV1)
+	if err != nil || frame.Type != framePong {
+		t.Errorf("expected piece 99 to decode as a pong, got %q (%v)", frame.Type, err)
+	}
+	frame, err = decodeFrame([]byte(`{"position":{"x":2,"y":3},"piece":1}`), protocolV1)
+	if err != nil || frame.Type != "" || frame.Position != (Position{X: 2, Y: 3}) {
+		t.Errorf("expected an untyped move, got %+v (%v)", frame, err)
+	}
+}
+
+func TestDecodeFrame_V2RequiresType(t *testing.T) {
+	_, err := decodeFrame([]byte(`{"position":{"x":2,"y":3},"piece":1}`), protocolV2)
+	expectCode(t, err, CodeBadFrame)
+
+	frame, err := decodeFrame([]byte(`{"type":"move","position":{"x":2,"y":3},"piece":1}`), protocolV2)
+	if err != nil || frame.Type != frameMove {
+		t.Errorf("expected a typed move, got %+v (%v)", frame, err)
+	}
+}
+
+func TestDecodeFrame_Malformed(t *testing.T) {
+	_, err := decodeFrame([]byte(`{"type":`), protocolV2)
+	expectCode(t, err, CodeBadFrame)
+}
+
+// --- Frame handling ---
+
+func TestHandleFrame_NotYourTurn(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	err := game.handleFrame(s, nil, "player2", Inbound{Type: frameMove, Piece: "0"})
+	expectCode(t, err, CodeNotYourTurn)
+}
+
+func TestHandleFrame_WrongPhase(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	err := game.handleFrame(s, nil, "player1", Inbound{Type: frameGraduateLine})
+	expectCode(t, err, CodeIllegalMove)
+}
+
+func TestHandleFrame_TypedMoveApplied(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	if err := game.handleFrame(s, nil, "player1", Inbound{Type: frameMove, Position: Position{X: 1, Y: 1}, Piece: "0"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if game.GameState.Board[1][1] != P1Kitten || game.GameState.TurnNumber != 1 {
+		t.Error("expected the move to be applied and the turn to pass")
+	}
+}
+
+func TestHandleFrame_IllegalMove(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	place(game.GameState, P2Kitten, 1, 1)
+	err := game.handleFrame(s, nil, "player1", Inbound{Type: frameMove, Position: Position{X: 1, Y: 1}, Piece: "0"})
+	expectCode(t, err, CodeIllegalMove)
+}
+
+func TestHandleFrame_UnknownType(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	err := game.handleFrame(s, nil, "player1", Inbound{Type: "dance"})
+	expectCode(t, err, CodeUnknownType)
+}
+
+func TestHandleFrame_Resign(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	if err := game.handleFrame(s, nil, "player1", Inbound{Type: frameResign}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if game.GameState.Winner != 2 {
+		t.Errorf("expected player2 to win by resignation, got winner %d", game.GameState.Winner)
+	}
+	err := game.handleFrame(s, nil, "player2", Inbound{Type: frameResign})
+	expectCode(t, err, CodeGameOver)
+}
+
+func TestHandleFrame_Chat(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	if err := game.handleFrame(s, nil, "player2", Inbound{Type: frameChat, Text: " gg "}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg := <-game.send
+	chat, ok := msg.Payload.(ChatMessage)
+	if msg.Type != "chat" || !ok || chat.Seat != "player2" || chat.Text != "gg" {
+		t.Errorf("expected a trimmed chat from player2, got %+v", msg)
+	}
+
+	err := game.handleFrame(s, nil, "player2", Inbound{Type: frameChat, Text: "   "})
+	expectCode(t, err, CodeBadChat)
+}
+
+// --- Addressing ---
+
+func TestSendError_PrivateToSender(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	game.sendError("player2", &ProtocolError{Code: CodeNotYourTurn, Message: "Not your turn"})
+
+	msg := <-game.send
+	if msg.To != "player2" || msg.Code != CodeNotYourTurn {
+		t.Fatalf("expected a not_your_turn error addressed to player2, got %+v", msg)
+	}
+	if msg.deliversTo("player1") || !msg.deliversTo("player2") {
+		t.Error("expected the error to reach player2 only")
+	}
+}
+
+func TestDeliversTo_BroadcastByDefault(t *testing.T) {
+	msg := Message{Type: "gameState"}
+	if !msg.deliversTo("player1") || !msg.deliversTo("player2") {
+		t.Error("expected an unaddressed message to reach both seats")
+	}
+}
+
+// The queued broadcast is a copy, so later moves can't change it before writePump encodes it.
+func TestBroadcastGameState_SendsSnapshot(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	game.broadcastGameState()
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+
+	msg := <-game.send
+	state, ok := msg.Payload.(GameState)
+	if !ok || state.TurnNumber != 0 || state.Board[0][0] != 0 || state.BroadcastSeq != 1 {
+		t.Errorf("expected the broadcast to keep the state it was sent with, got %+v", msg.Payload)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Matchmaking queues, picked with /ws?queue=
+const (
+	QueueCasual = "casual"
+	QueueRated  = "rated"
+)
+
+const (
+	defaultRating     = 1500.0
+	queueWindow       = 100.0 // rating gap a rated pairing accepts straight away
+	queueWindowGrowth = 10.0  // points the gap widens by per second waited
+	maxQueueWindow    = 1000.0
+	queueSweep        = 2 * time.Second // how often waiting clients are re-paired as windows widen
+)
+
+var errQueueCancelled = errors.New("cancelled")
+
+// QueueStatus is the payload of "queued", sent when a client enters a queue.
+type QueueStatus struct {
+	Queue       string       `json:"queue"`
+	TimeControl *TimeControl `json:"timeControl,omitempty"`
+	Waiting     int          `json:"waiting"` // clients in the queue, including this one
+}
+
+// queueEntry is a client waiting in a matchmaking queue.
+type queueEntry struct {
+	conn    *websocket.Conn
+	queue   string
+	opts    GameOptions
+	user    *User // nil for anonymous players
+	version int
+	rating  float64
+	since   time.Time
+	matched chan queueMatch // receives once when paired
+}
+
+type queueMatch struct {
+	game *Game
+	seat string
+}
+
+// matchmaker holds the queued clients, oldest first. Lock order: matchmaker.mutex before
+// serverMutex. While a client is queued, writes to its connection happen under this mutex;
+// once paired only the game's writePump writes.
+type matchmaker struct {
+	mutex    sync.Mutex
+	entries  []*queueEntry
+	sweeping bool
+	lastPing time.Time
+}
+
+// parseQueue reads ?queue=, returning "" when the client isn't queueing.
+func parseQueue(queue string) (string, error) {
+	switch queue {
+	case "", QueueCasual, QueueRated:
+		return queue, nil
+	default:
+		return "", fmt.Errorf("unknown queue %q", queue)
+	}
+}
+
+// window is the rating gap entry accepts after waiting until now.
+func (entry *queueEntry) window(now time.Time) float64 {
+	return min(maxQueueWindow, queueWindow+queueWindowGrowth*now.Sub(entry.since).Seconds())
+}
+
+func sameTimeControl(a, b *TimeControl) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// compatible reports whether a and b can be paired now: same queue, time control and rules,
+// and for rated play two different accounts with ratings within both players' windows.
+func compatible(a, b *queueEntry, now time.Time) bool {
+	if a.queue != b.queue || !sameTimeControl(a.opts.TimeControl, b.opts.TimeControl) || !sameRules(a.opts.Rules, b.opts.Rules) {
+		return false
+	}
+	if a.user != nil && b.user != nil && a.user.ID == b.user.ID && a.queue == QueueRated {
+		return false
+	}
+	return a.queue != QueueRated || math.Abs(a.rating-b.rating) <= min(a.window(now), b.window(now))
+}
+
+// enqueue adds entry to the queue and pairs it straight away if it can.
+func (s *Server) enqueue(entry *queueEntry) {
+	mm := &s.matchmaker
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+
+	mm.entries = append(mm.entries, entry)
+	waiting := 0
+	for _, e := range mm.entries {
+		if e.queue == entry.queue && sameTimeControl(e.opts.TimeControl, entry.opts.TimeControl) {
+			waiting++
+		}
+	}
+	entry.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	entry.conn.WriteJSON(Message{
+		Type:    "queued",
+		Version: entry.version,
+		Payload: QueueStatus{Queue: entry.queue, TimeControl: entry.opts.TimeControl, Waiting: waiting},
+	})
+	s.matchQueue(time.Now())
+	if !mm.sweeping && len(mm.entries) > 0 {
+		mm.sweeping = true
+		go s.sweepQueue()
+	}
+}
+
+// leaveQueue removes entry, reporting false if it had already been paired.
+func (s *Server) leaveQueue(entry *queueEntry) bool {
+	mm := &s.matchmaker
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	for i, e := range mm.entries {
+		if e == entry {
+			mm.entries = append(mm.entries[:i], mm.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// matchQueue pairs every compatible couple, longest-waiting first. Caller must hold matchmaker.mutex.
+func (s *Server) matchQueue(now time.Time) {
+	mm := &s.matchmaker
+	for i := 0; i < len(mm.entries); i++ {
+		for j := i + 1; j < len(mm.entries); j++ {
+			a, b := mm.entries[i], mm.entries[j]
+			if !compatible(a, b, now) {
+				continue
+			}
+			mm.entries = append(mm.entries[:j], mm.entries[j+1:]...)
+			mm.entries = append(mm.entries[:i], mm.entries[i+1:]...)
+			s.startMatch(a, b)
+			i--
+			break
+		}
+	}
+}
+
+// sweepQueue re-pairs waiting clients as their rating windows widen and pings them so dead
+// connections are noticed. It exits once the queue is empty.
+func (s *Server) sweepQueue() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in sweepQueue: %v", r)
+		}
+	}()
+	mm := &s.matchmaker
+	ticker := time.NewTicker(queueSweep)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		mm.mutex.Lock()
+		if len(mm.entries) == 0 {
+			mm.sweeping = false
+			mm.mutex.Unlock()
+			return
+		}
+		s.matchQueue(now)
+		if now.Sub(mm.lastPing) >= pingPeriod {
+			mm.lastPing = now
+			for _, entry := range mm.entries {
+				entry.conn.SetWriteDeadline(now.Add(writeWait))
+				if err := entry.conn.WriteJSON(Message{Type: "ping"}); err != nil {
+					log.Printf("Failed to write ping to queued client: %v", err)
+				}
+			}
+		}
+		mm.mutex.Unlock()
+	}
+}
+
+// startMatch creates a game for a and b with both seats filled, the longer-waiting client as
+// player1 and a coin flip for who moves first. Caller must hold matchmaker.mutex.
+func (s *Server) startMatch(a, b *queueEntry) {
+	opts := a.opts
+	opts.Rated = a.queue == QueueRated
+	opts.First = FirstRandom
+	opts.Visibility = VisibilityPublic
+	opts.Passphrase = ""
+
+	s.serverMutex.Lock()
+	game := NewGame()
+	for _, exists := s.games[game.ID]; exists; _, exists = s.games[game.ID] {
+		game.ID = generateGameID()
+	}
+	game.applyOptions(opts)
+	game.Players["player1"] = a.conn
+	game.Players["player2"] = b.conn
+	for seat, user := range map[string]*User{"player1": a.user, "player2": b.user} {
+		if user != nil {
+			game.users[seat] = user
+		}
+	}
+	s.games[game.ID] = game
+	s.saveNewGame(game)
+	s.serverMutex.Unlock()
+	log.Printf("Matched %s queue into game %s", a.queue, game.ID)
+
+	// Each seat gets its "joined" through the writePump, ahead of the first broadcast. The
+	// writePump encodes it later, so it carries a copy of the state rather than the live one.
+	initial := *game.GameState
+	for seat, entry := range map[string]*queueEntry{"player1": a, "player2": b} {
+		game.send <- Message{
+			Type:     "joined",
+			GameID:   game.ID,
+			PlayerID: seat,
+			Token:    game.tokens[seat],
+			Version:  entry.version,
+			State:    game.GameState.State,
+			Payload:  initial,
+			To:       seat,
+		}
+		// The client is already being read; hold it to the game's ping schedule from now
+		entry.conn.SetReadDeadline(time.Now().Add(pongWait))
+		entry.matched <- queueMatch{game: game, seat: seat}
+	}
+
+	var wpWg sync.WaitGroup
+	wpWg.Add(1)
+	go game.writePump(s, &wpWg)
+	game.tickClock(s)
+	game.broadcastGameState()
+}
+
+// handleQueue waits in a matchmaking queue on conn, then plays the matched game. Until it is
+// paired the client may only answer pings or send queueCancel; leaving cancels the wait.
+func (s *Server) handleQueue(conn *websocket.Conn, queue string, opts GameOptions, user *User, version int) {
+	entry := &queueEntry{
+		conn:    conn,
+		queue:   queue,
+		opts:    opts,
+		user:    user,
+		version: version,
+		rating:  s.queueRating(user),
+		since:   time.Now(),
+		matched: make(chan queueMatch, 1),
+	}
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.enqueue(entry)
+
+	for {
+		frame, err := readQueuedFrame(conn, version)
+		select {
+		case m := <-entry.matched:
+			// Paired while this frame was in flight; it belongs to the game now
+			if err == nil {
+				err = m.game.handleFrame(s, conn, m.seat, frame)
+			}
+			var perr *ProtocolError
+			if errors.As(err, &perr) {
+				m.game.sendError(m.seat, perr)
+			}
+			s.handleGameLoop(conn, m.game, m.seat, version)
+			return
+		default:
+		}
+
+		if err == nil && frame.Type == frameQueueCancel {
+			err = errQueueCancelled
+		}
+		if err == errDisconnected || err == errQueueCancelled {
+			if !s.leaveQueue(entry) {
+				// Paired at the last moment: leave through the game so the opponent is told
+				m := <-entry.matched
+				s.handleGameLoop(conn, m.game, m.seat, version)
+				return
+			}
+			log.Printf("Client left the %s queue: %v", queue, err)
+			if err == errQueueCancelled {
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				conn.WriteJSON(Message{Type: "queueCancelled"})
+			}
+			conn.Close()
+			return
+		}
+		if err == nil && frame.Type == framePong {
+			conn.SetReadDeadline(time.Now().Add(pongWait))
+			continue
+		}
+		if err == nil {
+			err = &ProtocolError{Code: CodeQueued, Message: "Still waiting for an opponent"}
+		}
+		s.replyQueued(entry, err)
+	}
+}
+
+// replyQueued sends a queued client an error, unless it has just been paired and the
+// game's writePump owns the connection.
+func (s *Server) replyQueued(entry *queueEntry, err error) {
+	var perr *ProtocolError
+	if !errors.As(err, &perr) {
+		return
+	}
+	mm := &s.matchmaker
+	mm.mutex.Lock()
+	defer mm.mutex.Unlock()
+	for _, e := range mm.entries {
+		if e == entry {
+			entry.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			entry.conn.WriteJSON(Message{Type: "error", Code: perr.Code, Payload: perr.Message})
+			return
+		}
+	}
+}
+
+// readQueuedFrame reads and decodes one frame from a client that has no game yet.
+func readQueuedFrame(conn *websocket.Conn, version int) (Inbound, error) {
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return Inbound{}, errDisconnected
+	}
+	return decodeFrame(data, version)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- Helpers ---
+
+// dialServer connects a client to s.handleConnection with the given query string.
+func dialServer(t *testing.T, s *Server, query string) *websocket.Conn {
+	t.Helper()
+	t.Setenv("ORIGIN_URL", "http://boop.test")
+	srv := httptest.NewServer(http.HandlerFunc(s.handleConnection))
+	t.Cleanup(srv.Close)
+	header := http.Header{"Origin": {"http://boop.test"}}
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?"+query, header)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func queueLength(s *Server) int {
+	s.matchmaker.mutex.Lock()
+	defer s.matchmaker.mutex.Unlock()
+	return len(s.matchmaker.entries)
+}
+
+func waitForQueueLength(t *testing.T, s *Server, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for queueLength(s) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d queued clients, got %d", n, queueLength(s))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// --- Pairing rules ---
+
+func TestQueue_Compatible(t *testing.T) {
+	now := time.Now()
+	blitz := &TimeControl{InitialMs: 180_000, IncrementMs: 2_000}
+	entry := func(queue string, tc *TimeControl, rating float64) *queueEntry {
+		return &queueEntry{queue: queue, opts: GameOptions{TimeControl: tc, Variant: VariantStandard}, rating: rating, since: now}
+	}
+
+	if !compatible(entry(QueueCasual, nil, 1500), entry(QueueCasual, nil, 2400), now) {
+		t.Error("expected casual play to ignore ratings")
+	}
+	if compatible(entry(QueueCasual, nil, 1500), entry(QueueRated, nil, 1500), now) {
+		t.Error("expected different queues not to pair")
+	}
+	if compatible(entry(QueueCasual, blitz, 1500), entry(QueueCasual, &TimeControl{InitialMs: 180_000}, 1500), now) {
+		t.Error("expected different time controls not to pair")
+	}
+	if !compatible(entry(QueueCasual, blitz, 1500), entry(QueueCasual, &TimeControl{InitialMs: 180_000, IncrementMs: 2_000}, 1500), now) {
+		t.Error("expected equal time controls to pair")
+	}
+
+	a, b := entry(QueueRated, nil, 1500), entry(QueueRated, nil, 1700)
+	if compatible(a, b, now) {
+		t.Error("expected a 200 point gap to be too wide at first")
+	}
+	if !compatible(a, b, now.Add(15*time.Second)) {
+		t.Error("expected the window to widen enough after waiting")
+	}
+}
+
+// --- Queue over the wire ---
+
+func TestQueue_PairsTwoClients(t *testing.T) {
+	s := NewServer()
+	first := dialServer(t, s, "queue=casual&time=3%2B2")
+	if msg := readUntil(t, first, "queued"); msg["payload"].(map[string]interface{})["queue"] != "casual" {
+		t.Errorf("expected to be told which queue we're in, got %+v", msg)
+	}
+	waitForQueueLength(t, s, 1)
+
+	// A different time control waits rather than pairing
+	untimed := dialServer(t, s, "queue=casual")
+	readUntil(t, untimed, "queued")
+
+	second := dialServer(t, s, "queue=casual&time=3%2B2")
+	joined1 := readUntil(t, first, "joined")
+	joined2 := readUntil(t, second, "joined")
+	if joined1["playerID"] != "player1" || joined2["playerID"] != "player2" || joined1["gameID"] != joined2["gameID"] {
+		t.Fatalf("expected both clients seated in one game, got %+v and %+v", joined1, joined2)
+	}
+	if joined1["token"] == "" || joined1["token"] == joined2["token"] {
+		t.Error("expected each seat to get its own rejoin token")
+	}
+	readUntil(t, first, "gameState")
+	readUntil(t, second, "gameState")
+	waitForQueueLength(t, s, 1)
+
+	s.serverMutex.Lock()
+	defer s.serverMutex.Unlock()
+	game := s.games[joined1["gameID"].(string)]
+	if game == nil {
+		t.Fatal("expected the matched game to be registered")
+	}
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if len(game.Players) != 2 || game.clock == nil || game.options.Rated {
+		t.Error("expected a live casual timed game with both seats filled")
+	}
+	if _, listed := s.waitingGames[game.ID]; listed {
+		t.Error("expected a matched game not to wait in the lobby")
+	}
+}
+
+func TestQueue_CancelAndDisconnect(t *testing.T) {
+	s := NewServer()
+	client := dialServer(t, s, "queue=casual")
+	readUntil(t, client, "queued")
+	waitForQueueLength(t, s, 1)
+
+	client.WriteJSON(map[string]string{"type": "queueCancel"})
+	readUntil(t, client, "queueCancelled")
+	waitForQueueLength(t, s, 0)
+
+	dropped := dialServer(t, s, "queue=casual")
+	readUntil(t, dropped, "queued")
+	waitForQueueLength(t, s, 1)
+	dropped.Close()
+	waitForQueueLength(t, s, 0)
+}
+
+func TestQueue_RejectsBadQueue(t *testing.T) {
+	s := NewServer()
+	client := dialServer(t, s, "queue=ranked")
+	if msg := readUntil(t, client, "error"); msg["code"] != CodeBadOptions {
+		t.Errorf("expected bad_options for an unknown queue, got %+v", msg)
+	}
+}
+
+// The rated queue only makes standard games; custom rules are only for the casual queue.
+func TestQueue_RatedRejectsCustomRules(t *testing.T) {
+	s := newTestServerWithDB(t)
+	mochi := registerSession(t, s, "Mochi")
+	rated := dialServer(t, s, "queue=rated&pieces=4&session="+url.QueryEscape(mochi.Token))
+	if msg := readUntil(t, rated, "error"); msg["code"] != CodeBadOptions {
+		t.Errorf("expected bad_options for custom rules in the rated queue, got %+v", msg)
+	}
+	casual := dialServer(t, s, "queue=casual&pieces=4")
+	readUntil(t, casual, "queued")
+}
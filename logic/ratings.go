@@ -0,0 +1,399 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Glicko-2 constants. Each rated game is its own rating period.
+const (
+	glickoScale       = 173.7178 // converts between the Glicko and Glicko-2 scales
+	defaultDeviation  = 350.0
+	defaultVolatility = 0.06
+	glickoTau         = 0.5  // how far volatility may move per period
+	provisionalRD     = 110  // ratings less certain than this are flagged provisional
+	glickoEpsilon     = 1e-6 // convergence tolerance for the volatility iteration
+	leaderboardLimit  = 50
+	maxLeaderboard    = 200
+	historyLimit      = 100
+)
+
+var (
+	errRatedNeedsAccount = errors.New("rated games need an account")
+	errRatedSelf         = errors.New("can't play a rated game against yourself")
+)
+
+// Rating is a player's Glicko-2 rating on the familiar 1500-centred scale.
+type Rating struct {
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+}
+
+// RatingChange is one seat's rating before and after a rated game.
+type RatingChange struct {
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Change float64 `json:"change"`
+	// Deviation after the game
+	Deviation float64 `json:"deviation"`
+}
+
+// RatingChanges is set on the game-over broadcast of a rated game.
+type RatingChanges struct {
+	P1 RatingChange `json:"p1"`
+	P2 RatingChange `json:"p2"`
+}
+
+// glickoResult is one game against an opponent: score is 1 for a win, 0.5 a draw, 0 a loss.
+type glickoResult struct {
+	opponent Rating
+	score    float64
+}
+
+func newRating() Rating {
+	return Rating{Rating: defaultRating, Deviation: defaultDeviation, Volatility: defaultVolatility}
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+func glickoE(mu, muJ, phiJ float64) float64 {
+	return 1 / (1 + math.Exp(-glickoG(phiJ)*(mu-muJ)))
+}
+
+// glicko2 rates player after one rating period with results, following Glickman's
+// "Example of the Glicko-2 system". With no results only the deviation grows.
+func glicko2(player Rating, results []glickoResult) Rating {
+	mu := (player.Rating - defaultRating) / glickoScale
+	phi := player.Deviation / glickoScale
+	sigma := player.Volatility
+	if len(results) == 0 {
+		phi = math.Sqrt(phi*phi + sigma*sigma)
+		return Rating{Rating: player.Rating, Deviation: min(defaultDeviation, phi*glickoScale), Volatility: sigma}
+	}
+
+	// Estimated variance and improvement from the period's results
+	var vInv, sum float64
+	for _, res := range results {
+		muJ := (res.opponent.Rating - defaultRating) / glickoScale
+		phiJ := res.opponent.Deviation / glickoScale
+		g, e := glickoG(phiJ), glickoE(mu, muJ, phiJ)
+		vInv += g * g * e * (1 - e)
+		sum += g * (res.score - e)
+	}
+	v := 1 / vInv
+	delta := v * sum
+
+	// New volatility by the Illinois algorithm
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+	lo, hi := a, 0.0
+	if delta*delta > phi*phi+v {
+		hi = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		hi = a - k*glickoTau
+	}
+	fLo, fHi := f(lo), f(hi)
+	for math.Abs(hi-lo) > glickoEpsilon {
+		c := lo + (lo-hi)*fLo/(fHi-fLo)
+		fC := f(c)
+		if fC*fHi <= 0 {
+			lo, fLo = hi, fHi
+		} else {
+			fLo /= 2
+		}
+		hi, fHi = c, fC
+	}
+	sigma = math.Exp(lo / 2)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * sum
+	return Rating{
+		Rating:     mu*glickoScale + defaultRating,
+		Deviation:  min(defaultDeviation, phi*glickoScale),
+		Volatility: sigma,
+	}
+}
+
+// loadRating returns a player's current rating, or the starting rating if they have never
+// played a rated game. q is the database or a transaction.
+func loadRating(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, userID int64) (Rating, error) {
+	var r Rating
+	err := q.QueryRow(`SELECT rating, deviation, volatility FROM ratings WHERE user_id = ?`, userID).Scan(&r.Rating, &r.Deviation, &r.Volatility)
+	if err == sql.ErrNoRows {
+		return newRating(), nil
+	}
+	return r, err
+}
+
+// admitsUser checks user may take the second seat: a rated game needs an account other
+// than the creator's.
+func (game *Game) admitsUser(user *User) error {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if !game.options.Rated {
+		return nil
+	}
+	if user == nil {
+		return errRatedNeedsAccount
+	}
+	if creator := game.users["player1"]; creator != nil && creator.ID == user.ID {
+		return errRatedSelf
+	}
+	return nil
+}
+
+// queueRating is the rating a user is paired by in the rated queue.
+func (s *Server) queueRating(user *User) float64 {
+	if user == nil || s.db == nil {
+		return defaultRating
+	}
+	r, err := loadRating(s.db, user.ID)
+	if err != nil {
+		log.Printf("queueRating: failed to load rating for %s: %v", user.Username, err)
+		return defaultRating
+	}
+	return r.Rating
+}
+
+// rateGame updates both players' ratings once a rated game between two accounts is over
+// and records the change on the game state for the game-over broadcast. It runs once per game.
+func (s *Server) rateGame(game *Game) {
+	if s.db == nil {
+		return
+	}
+	game.mutex.Lock()
+	p1, p2 := game.users["player1"], game.users["player2"]
+	gs := game.GameState
+	if !game.options.Rated || !gs.isOver() || game.rated || p1 == nil || p2 == nil || p1.ID == p2.ID {
+		game.mutex.Unlock()
+		return
+	}
+	game.rated = true
+	score := 0.5
+	switch gs.Winner {
+	case 1:
+		score = 1
+	case 2:
+		score = 0
+	}
+	game.mutex.Unlock()
+
+	changes, err := s.recordRatings(game.ID, p1, p2, score)
+	if err != nil {
+		log.Printf("rateGame: failed to rate game %s: %v", game.ID, err)
+		return
+	}
+	game.mutex.Lock()
+	game.GameState.Ratings = changes
+	game.mutex.Unlock()
+	log.Printf("Rated game %s: %s %+.0f, %s %+.0f", game.ID, p1.Username, changes.P1.Change, p2.Username, changes.P2.Change)
+}
+
+// recordRatings applies one game's result (score from player1's side) to both players and
+// appends it to their history, in one transaction.
+func (s *Server) recordRatings(gameID string, p1, p2 *User, score float64) (*RatingChanges, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	r1, err := loadRating(tx, p1.ID)
+	if err != nil {
+		return nil, err
+	}
+	r2, err := loadRating(tx, p2.ID)
+	if err != nil {
+		return nil, err
+	}
+	n1 := glicko2(r1, []glickoResult{{opponent: r2, score: score}})
+	n2 := glicko2(r2, []glickoResult{{opponent: r1, score: 1 - score}})
+
+	for _, row := range []struct {
+		user     *User
+		opponent *User
+		before   Rating
+		after    Rating
+		score    float64
+	}{{p1, p2, r1, n1, score}, {p2, p1, r2, n2, 1 - score}} {
+		_, err = tx.Exec(`
+			INSERT INTO ratings (user_id, rating, deviation, volatility, games, updated_at)
+			VALUES (?, ?, ?, ?, 1, CURRENT_TIMESTAMP)
+			ON CONFLICT(user_id) DO UPDATE SET rating = excluded.rating, deviation = excluded.deviation,
+				volatility = excluded.volatility, games = games + 1, updated_at = excluded.updated_at
+		`, row.user.ID, row.after.Rating, row.after.Deviation, row.after.Volatility)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO rating_history (user_id, game_id, opponent_id, score, rating_before, rating, deviation)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, row.user.ID, gameID, row.opponent.ID, row.score, row.before.Rating, row.after.Rating, row.after.Deviation)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &RatingChanges{
+		P1: RatingChange{Before: r1.Rating, After: n1.Rating, Change: n1.Rating - r1.Rating, Deviation: n1.Deviation},
+		P2: RatingChange{Before: r2.Rating, After: n2.Rating, Change: n2.Rating - r2.Rating, Deviation: n2.Deviation},
+	}, nil
+}
+
+// --- HTTP ---
+
+// LeaderboardEntry is one row of /ratings/leaderboard.
+type LeaderboardEntry struct {
+	Rank        int     `json:"rank"`
+	User        User    `json:"user"`
+	Rating      float64 `json:"rating"`
+	Deviation   float64 `json:"deviation"`
+	Games       int     `json:"games"`
+	Provisional bool    `json:"provisional"` // deviation is still above 110
+}
+
+// RatingHistoryEntry is one rated game in /ratings/history, newest first.
+type RatingHistoryEntry struct {
+	GameID    string    `json:"gameID"`
+	Opponent  User      `json:"opponent"`
+	Score     float64   `json:"score"` // 1 win, 0.5 draw, 0 loss
+	Before    float64   `json:"before"`
+	Rating    float64   `json:"rating"`
+	Change    float64   `json:"change"`
+	Deviation float64   `json:"deviation"`
+	PlayedAt  time.Time `json:"playedAt"`
+}
+
+// parseLimit reads ?limit=, defaulting to def and capped at max.
+func parseLimit(value string, def, max int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("limit must be a positive number")
+	}
+	return min(n, max), nil
+}
+
+// handleLeaderboard lists rated players, highest first: {"players": [...]}. ?limit= (default 50, max 200).
+func (s *Server) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	if s.db == nil {
+		http.Error(w, errAccountsDisabled.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	limit, err := parseLimit(r.URL.Query().Get("limit"), leaderboardLimit, maxLeaderboard)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rows, err := s.db.Query(`
+		SELECT users.id, users.username, ratings.rating, ratings.deviation, ratings.games
+		FROM ratings JOIN users ON users.id = ratings.user_id
+		ORDER BY ratings.rating DESC, users.username LIMIT ?
+	`, limit)
+	if err != nil {
+		log.Printf("handleLeaderboard: %v", err)
+		http.Error(w, "leaderboard unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	players := []LeaderboardEntry{}
+	for rows.Next() {
+		entry := LeaderboardEntry{Rank: len(players) + 1}
+		if err := rows.Scan(&entry.User.ID, &entry.User.Username, &entry.Rating, &entry.Deviation, &entry.Games); err != nil {
+			log.Printf("handleLeaderboard: %v", err)
+			http.Error(w, "leaderboard unavailable", http.StatusInternalServerError)
+			return
+		}
+		entry.Provisional = entry.Deviation > provisionalRD
+		players = append(players, entry)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Players []LeaderboardEntry `json:"players"`
+	}{players})
+}
+
+// handleRatingHistory returns ?user='s current rating and their rated games, newest first.
+func (s *Server) handleRatingHistory(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	if s.db == nil {
+		http.Error(w, errAccountsDisabled.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	limit, err := parseLimit(r.URL.Query().Get("limit"), historyLimit, historyLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var user User
+	err = s.db.QueryRow(`SELECT id, username FROM users WHERE username = ?`, r.URL.Query().Get("user")).Scan(&user.ID, &user.Username)
+	if err == sql.ErrNoRows {
+		http.Error(w, "no such user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("handleRatingHistory: %v", err)
+		http.Error(w, "history unavailable", http.StatusInternalServerError)
+		return
+	}
+	current, err := loadRating(s.db, user.ID)
+	if err != nil {
+		log.Printf("handleRatingHistory: %v", err)
+		http.Error(w, "history unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT h.game_id, o.id, o.username, h.score, h.rating_before, h.rating, h.deviation, h.created_at
+		FROM rating_history h JOIN users o ON o.id = h.opponent_id
+		WHERE h.user_id = ? ORDER BY h.id DESC LIMIT ?
+	`, user.ID, limit)
+	if err != nil {
+		log.Printf("handleRatingHistory: %v", err)
+		http.Error(w, "history unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []RatingHistoryEntry{}
+	for rows.Next() {
+		var h RatingHistoryEntry
+		if err := rows.Scan(&h.GameID, &h.Opponent.ID, &h.Opponent.Username, &h.Score, &h.Before, &h.Rating, &h.Deviation, &h.PlayedAt); err != nil {
+			log.Printf("handleRatingHistory: %v", err)
+			http.Error(w, "history unavailable", http.StatusInternalServerError)
+			return
+		}
+		h.Change = h.Rating - h.Before
+		history = append(history, h)
+	}
+	writeJSON(w, http.StatusOK, struct {
+		User    User                 `json:"user"`
+		Rating  Rating               `json:"rating"`
+		History []RatingHistoryEntry `json:"history"`
+	}{user, current, history})
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// --- Helpers ---
+
+// newRatedGame seats two registered players in a rated game in progress.
+func newRatedGame(t *testing.T, s *Server, p1, p2 string) *Game {
+	t.Helper()
+	game := newSeatedGame(s)
+	game.options.Rated = true
+	first, second := registerSession(t, s, p1), registerSession(t, s, p2)
+	game.bindUser("player1", &first.User)
+	game.bindUser("player2", &second.User)
+	return game
+}
+
+func getJSON(t *testing.T, handler http.HandlerFunc, target string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", target, nil))
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("unreadable response from %s: %v", target, err)
+		}
+	}
+	return rec.Code
+}
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+// --- Glicko-2 ---
+
+func TestGlicko2_PaperExample(t *testing.T) {
+	// Glickman, "Example of the Glicko-2 system", section 3
+	player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	got := glicko2(player, []glickoResult{
+		{opponent: Rating{Rating: 1400, Deviation: 30}, score: 1},
+		{opponent: Rating{Rating: 1550, Deviation: 100}, score: 0},
+		{opponent: Rating{Rating: 1700, Deviation: 300}, score: 0},
+	})
+	if !near(got.Rating, 1464.06, 0.01) || !near(got.Deviation, 151.52, 0.01) || !near(got.Volatility, 0.05999, 0.00001) {
+		t.Errorf("expected 1464.06 / 151.52 / 0.05999, got %+v", got)
+	}
+}
+
+func TestGlicko2_SingleGame(t *testing.T) {
+	a, b := newRating(), newRating()
+	winner := glicko2(a, []glickoResult{{opponent: b, score: 1}})
+	loser := glicko2(b, []glickoResult{{opponent: a, score: 0}})
+	if winner.Rating <= 1500 || loser.Rating >= 1500 || !near(winner.Rating-1500, 1500-loser.Rating, 0.001) {
+		t.Errorf("expected equal and opposite changes between new players, got %.2f and %.2f", winner.Rating, loser.Rating)
+	}
+	if winner.Deviation >= defaultDeviation {
+		t.Errorf("expected a game to make the rating more certain, got deviation %.2f", winner.Deviation)
+	}
+	drawn := glicko2(a, []glickoResult{{opponent: b, score: 0.5}})
+	if !near(drawn.Rating, 1500, 0.001) {
+		t.Errorf("expected a draw between equals to leave the rating alone, got %.2f", drawn.Rating)
+	}
+	if idle := glicko2(Rating{Rating: 1600, Deviation: 349.9, Volatility: 0.06}, nil); idle.Rating != 1600 || idle.Deviation != defaultDeviation {
+		t.Errorf("expected an idle period only to widen the deviation up to the cap, got %+v", idle)
+	}
+}
+
+// --- Rated games ---
+
+func TestRateGame_ResignationUpdatesBothPlayers(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newRatedGame(t, s, "Mochi", "Biscuit")
+	if err := game.resign(s, "player2"); err != nil {
+		t.Fatalf("unexpected resign error: %v", err)
+	}
+
+	changes := game.GameState.Ratings
+	if changes == nil {
+		t.Fatal("expected the game-over state to carry rating changes")
+	}
+	if changes.P1.Before != defaultRating || changes.P1.Change <= 0 || changes.P2.Change >= 0 || changes.P1.After != changes.P1.Before+changes.P1.Change {
+		t.Errorf("expected the winner up and the loser down from 1500, got %+v", changes)
+	}
+
+	// Rating again (e.g. the abandonment path racing a resignation) changes nothing
+	s.rateGame(game)
+	var board struct {
+		Players []LeaderboardEntry `json:"players"`
+	}
+	if code := getJSON(t, s.handleLeaderboard, "/ratings/leaderboard", &board); code != http.StatusOK {
+		t.Fatalf("leaderboard returned %d", code)
+	}
+	if len(board.Players) != 2 || board.Players[0].User.Username != "Mochi" || board.Players[0].Rank != 1 || board.Players[0].Games != 1 || !board.Players[0].Provisional {
+		t.Errorf("expected Mochi top of the leaderboard after one provisional game, got %+v", board.Players)
+	}
+	if !near(board.Players[0].Rating, changes.P1.After, 0.001) {
+		t.Errorf("expected the leaderboard rating %.2f to match the broadcast %.2f", board.Players[0].Rating, changes.P1.After)
+	}
+
+	var history struct {
+		User    User                 `json:"user"`
+		Rating  Rating               `json:"rating"`
+		History []RatingHistoryEntry `json:"history"`
+	}
+	if code := getJSON(t, s.handleRatingHistory, "/ratings/history?user=biscuit", &history); code != http.StatusOK {
+		t.Fatalf("history returned %d", code)
+	}
+	if len(history.History) != 1 {
+		t.Fatalf("expected one rated game in Biscuit's history, got %+v", history.History)
+	}
+	h := history.History[0]
+	if h.GameID != game.ID || h.Opponent.Username != "Mochi" || h.Score != 0 || !near(h.Change, changes.P2.Change, 0.001) || h.PlayedAt.IsZero() {
+		t.Errorf("expected the loss to Mochi in the history, got %+v", h)
+	}
+	if !near(history.Rating.Rating, changes.P2.After, 0.001) {
+		t.Errorf("expected the current rating to be %.2f, got %+v", changes.P2.After, history.Rating)
+	}
+	if code := getJSON(t, s.handleRatingHistory, "/ratings/history?user=Nobody", &history); code != http.StatusNotFound {
+		t.Errorf("expected an unknown user to be not found, got %d", code)
+	}
+}
+
+// A rated game finished after a restart is still rated.
+func TestRateGame_RestoredGameStaysRated(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newRatedGame(t, s, "Mochi", "Biscuit")
+	s.saveNewGame(game)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	s.saveGame(game)
+
+	restarted := NewServer()
+	restarted.db = s.db
+	if err := restarted.restoreGames(); err != nil {
+		t.Fatalf("unexpected restore error: %v", err)
+	}
+	restored := restarted.games[game.ID]
+	if restored == nil || !restored.options.Rated {
+		t.Fatal("expected the restored game to still be rated")
+	}
+	defer restored.shutdown()
+	if err := restored.resign(restarted, "player2"); err != nil {
+		t.Fatalf("unexpected resign error: %v", err)
+	}
+	if changes := restored.GameState.Ratings; changes == nil || changes.P1.Change <= 0 || changes.P2.Change >= 0 {
+		t.Errorf("expected the resignation to move both ratings, got %+v", changes)
+	}
+	var rated bool
+	if err := s.db.QueryRow(`SELECT rated FROM finished_games WHERE id = ?`, game.ID).Scan(&rated); err != nil || !rated {
+		t.Errorf("expected the game to be archived as rated, got %v (%v)", rated, err)
+	}
+}
+
+func TestRateGame_SkipsCasualGames(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newRatedGame(t, s, "Mochi", "Biscuit")
+	game.options.Rated = false
+	game.resign(s, "player1")
+	if game.GameState.Ratings != nil {
+		t.Error("expected a casual game not to be rated")
+	}
+}
+
+func TestRatedGames_NeedAccounts(t *testing.T) {
+	s := newTestServerWithDB(t)
+	mochi := registerSession(t, s, "Mochi")
+	game := s.createGame(nil, GameOptions{Rated: true}, &mochi.User)
+
+	if _, err := s.joinGame(nil, game.ID, "", "", nil); !errors.Is(err, errRatedNeedsAccount) {
+		t.Errorf("expected an anonymous player to be refused, got %v", err)
+	}
+	if _, err := s.joinGame(nil, game.ID, "", "", &mochi.User); !errors.Is(err, errRatedSelf) {
+		t.Errorf("expected the creator's own account to be refused, got %v", err)
+	}
+
+	client := dialServer(t, s, "rated=true")
+	if msg := readUntil(t, client, "error"); msg["code"] != CodeBadSession {
+		t.Errorf("expected an anonymous rated game to need an account, got %+v", msg)
+	}
+	queued := dialServer(t, s, "queue=rated")
+	if msg := readUntil(t, queued, "error"); msg["code"] != CodeBadSession {
+		t.Errorf("expected the rated queue to need an account, got %+v", msg)
+	}
+}
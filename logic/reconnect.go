@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Default time a dropped player has to rejoin before their seat is forfeited.
+// Override with the RECONNECT_GRACE env var (Go duration, e.g. "90s"; "0" disables).
+const defaultReconnectGrace = 60 * time.Second
+
+type SeatNotice struct {
+	Seat         string `json:"seat"`
+	GraceSeconds int    `json:"graceSeconds,omitempty"`
+}
+
+func generateSeatToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed")
+	}
+	return hex.EncodeToString(b)
+}
+
+// seatForToken returns the seat the token belongs to, or "" if it matches neither.
+func (game *Game) seatForToken(token string) string {
+	for seat, seatToken := range game.tokens {
+		if subtle.ConstantTimeCompare([]byte(seatToken), []byte(token)) == 1 {
+			return seat
+		}
+	}
+	return ""
+}
+
+// rejoinGame reattaches a connection to the seat matching token in an unfinished game.
+// Games that were evicted from memory are reloaded from the database on demand.
+// A stale connection still holding the seat is closed and replaced.
+func (server *Server) rejoinGame(conn *websocket.Conn, requestedGameID string, token string) (*Game, string) {
+	server.serverMutex.Lock()
+	defer server.serverMutex.Unlock()
+
+	game, exists := server.games[requestedGameID]
+	if !exists {
+		game = server.reloadGame(requestedGameID)
+		if game == nil {
+			return nil, ""
+		}
+	}
+	_, waiting := server.waitingGames[game.ID]
+
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+
+	if game.GameState.isOver() {
+		return nil, ""
+	}
+	playerID := game.seatForToken(token)
+	// Only the creator can come back to a game still waiting for its second player
+	if playerID == "" || waiting && playerID != "player1" {
+		return nil, ""
+	}
+	if old, taken := game.Players[playerID]; taken {
+		log.Printf("Replacing stale connection for %s in game %s", playerID, game.ID)
+		old.Close()
+	}
+	game.Players[playerID] = conn
+	if timer, pending := game.graceTimers[playerID]; pending {
+		timer.Stop()
+		delete(game.graceTimers, playerID)
+	}
+	log.Printf("Player %s rejoined game %s", playerID, game.ID)
+	return game, playerID
+}
+
+// resumeAfterRejoin restarts what a restored game holds back until its players return:
+// the clock, once the player to move is seated (or is the bot), and the bot. A waiting
+// game's clock starts when its second player joins instead.
+func (game *Game) resumeAfterRejoin(s *Server) {
+	s.serverMutex.Lock()
+	_, waiting := s.waitingGames[game.ID]
+	s.serverMutex.Unlock()
+	if waiting {
+		return
+	}
+
+	game.mutex.Lock()
+	mover := moverSeat(game.GameState)
+	_, present := game.Players[mover]
+	present = present || game.bot != nil && game.bot.Seat == mover
+	game.mutex.Unlock()
+
+	if present {
+		game.tickClock(s)
+	}
+	game.wakeBot()
+}
+
+// holdSeat keeps a disconnected seat open for the grace period and tells the opponent.
+// Caller must hold serverMutex.
+func (game *Game) holdSeat(s *Server, playerID string) {
+	game.mutex.Lock()
+	if timer, pending := game.graceTimers[playerID]; pending {
+		timer.Stop()
+	}
+	game.graceTimers[playerID] = time.AfterFunc(s.reconnectGrace, func() {
+		s.abandonSeat(game, playerID)
+	})
+	game.mutex.Unlock()
+
+	log.Printf("Holding seat %s in game %s for %v", playerID, game.ID, s.reconnectGrace)
+	game.notifySeat("playerReconnecting", playerID, &SeatNotice{
+		Seat:         playerID,
+		GraceSeconds: int(s.reconnectGrace / time.Second),
+	})
+}
+
+// abandonSeat runs when a held seat's grace period expires. If the opponent is still
+// connected they win by abandonment; if nobody is left the game is evicted from memory
+// (it stays persisted, so either token can still resume it later).
+func (s *Server) abandonSeat(game *Game, playerID string) {
+	s.serverMutex.Lock()
+	defer s.serverMutex.Unlock()
+
+	if s.games[game.ID] != game {
+		// Already evicted (and possibly reloaded as a fresh Game)
+		return
+	}
+
+	game.mutex.Lock()
+	delete(game.graceTimers, playerID)
+	if _, back := game.Players[playerID]; back {
+		game.mutex.Unlock()
+		return
+	}
+	remaining := len(game.Players)
+	if remaining > 0 && !game.GameState.isOver() {
+		game.endGame(seatNumber(otherSeat(playerID)), ResultAbandonment)
+	}
+	game.mutex.Unlock()
+
+	if remaining == 0 {
+		// A restored waiting game whose creator never came back is dropped for good
+		_, waiting := s.waitingGames[game.ID]
+		s.removeWaitingGame(game)
+		s.evictGame(game)
+		if waiting {
+			s.deleteGame(game.ID)
+		}
+		return
+	}
+
+	log.Printf("Seat %s in game %s abandoned", playerID, game.ID)
+	game.tickClock(s)
+	s.rateGame(game)
+	game.broadcastGameState()
+	s.saveGame(game)
+}
+
+// notifySeat tells everyone in the game that playerID has dropped or come back.
+func (game *Game) notifySeat(msgType string, playerID string, notice *SeatNotice) {
+	if notice == nil {
+		notice = &SeatNotice{Seat: playerID}
+	}
+	msg := Message{
+		Type:     msgType,
+		GameID:   game.ID,
+		PlayerID: playerID,
+		State:    game.GameState.State,
+		Payload:  notice,
+	}
+	select {
+	case game.send <- msg:
+	default:
+		log.Printf("Send channel full, dropping %s notice for game %s", msgType, game.ID)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// --- Helpers ---
+
+// newSeatedGame registers an in-progress game with both seats filled by placeholder connections.
+func newSeatedGame(s *Server) *Game {
+	game := NewGame()
+	game.Players["player1"] = nil
+	game.Players["player2"] = nil
+	s.games[game.ID] = game
+	return game
+}
+
+// --- Seat tokens ---
+
+func TestSeatTokens_DistinctPerSeat(t *testing.T) {
+	game := NewGame()
+	if game.tokens["player1"] == "" || game.tokens["player1"] == game.tokens["player2"] {
+		t.Error("expected distinct non-empty tokens for each seat")
+	}
+	if game.seatForToken(game.tokens["player2"]) != "player2" {
+		t.Error("expected player2 token to map to player2")
+	}
+	if game.seatForToken("not-a-token") != "" {
+		t.Error("expected unknown token to match no seat")
+	}
+}
+
+// --- Rejoin ---
+
+func TestRejoin_ReclaimsHeldSeat(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	s.handlePlayerDisconnect(game.ID, "player2", nil)
+
+	if _, seated := game.Players["player2"]; seated {
+		t.Fatal("expected player2 seat to be vacated on disconnect")
+	}
+	if _, held := game.graceTimers["player2"]; !held {
+		t.Fatal("expected player2 seat to be held during grace period")
+	}
+
+	rejoined, playerID := s.rejoinGame(nil, game.ID, game.tokens["player2"])
+	if rejoined != game || playerID != "player2" {
+		t.Fatalf("expected to rejoin as player2, got %q", playerID)
+	}
+	if _, held := game.graceTimers["player2"]; held {
+		t.Error("expected grace timer to be cancelled on rejoin")
+	}
+}
+
+func TestRejoin_WrongTokenRejected(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	s.handlePlayerDisconnect(game.ID, "player2", nil)
+	defer s.evictGame(game)
+
+	if rejoined, _ := s.rejoinGame(nil, game.ID, "bogus"); rejoined != nil {
+		t.Error("expected rejoin with bogus token to fail")
+	}
+}
+
+func TestRejoin_WaitingGameRejected(t *testing.T) {
+	s := NewServer()
+	game := s.createGame(nil, GameOptions{}, nil)
+	defer game.shutdown()
+
+	if rejoined, _ := s.rejoinGame(nil, game.ID, game.tokens["player2"]); rejoined != nil {
+		t.Error("expected rejoin of a game still waiting for an opponent to fail")
+	}
+}
+
+// --- Abandonment ---
+
+// The player who stays wins once the grace period expires.
+func TestGracePeriod_ExpiryAwardsWinToRemainingPlayer(t *testing.T) {
+	s := NewServer()
+	s.reconnectGrace = 10 * time.Millisecond
+	game := newSeatedGame(s)
+
+	s.handlePlayerDisconnect(game.ID, "player1", nil)
+	time.Sleep(50 * time.Millisecond)
+
+	s.serverMutex.Lock()
+	winner, result := game.GameState.Winner, game.GameState.Result
+	s.serverMutex.Unlock()
+	if winner != 2 || result != ResultAbandonment {
+		t.Errorf("expected player2 to win by abandonment, got Winner=%d Result=%q", winner, result)
+	}
+}
+
+// With nobody left, expiry evicts the game instead of declaring a winner.
+func TestGracePeriod_ExpiryEvictsEmptyGame(t *testing.T) {
+	s := NewServer()
+	s.reconnectGrace = 10 * time.Millisecond
+	game := newSeatedGame(s)
+
+	s.handlePlayerDisconnect(game.ID, "player1", nil)
+	s.handlePlayerDisconnect(game.ID, "player2", nil)
+	time.Sleep(50 * time.Millisecond)
+
+	s.serverMutex.Lock()
+	_, exists := s.games[game.ID]
+	s.serverMutex.Unlock()
+	if exists {
+		t.Error("expected empty game to be evicted after grace period")
+	}
+	if game.GameState.Winner != 0 {
+		t.Errorf("expected no winner for an evicted game, got %d", game.GameState.Winner)
+	}
+}
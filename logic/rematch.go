@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// series is the running score across a chain of rematches. Shared by every game in the chain.
+type series struct {
+	wins  [2]int // by side; side 0 sat in player1 for the first game
+	draws int
+	games int // finished games already counted
+}
+
+// SeriesScore is the series as seen from this game's seats.
+type SeriesScore struct {
+	Game   int `json:"game"` // this game's number in the series, from 1
+	P1Wins int `json:"p1Wins"`
+	P2Wins int `json:"p2Wins"`
+	Draws  int `json:"draws"`
+}
+
+// RematchNotice is the payload of rematchRequested / rematchDeclined.
+type RematchNotice struct {
+	Seat string `json:"seat"` // seat that asked for the rematch
+}
+
+// RematchStarted is the payload of "rematch", sent to each player as they are moved.
+type RematchStarted struct {
+	PreviousGameID string `json:"previousGameID"`
+}
+
+// side is the series side sitting in seat for this game.
+func (game *Game) side(seat string) int {
+	if seat == "player1" {
+		return game.p1Side
+	}
+	return 1 - game.p1Side
+}
+
+// seriesScore is the series including this game once it is over, or nil outside a series.
+func (game *Game) seriesScore() *SeriesScore {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if game.series == nil {
+		return nil
+	}
+	score := *game.series
+	if game.GameState.isOver() {
+		game.recordResult(&score)
+	}
+	return &SeriesScore{
+		Game:   game.series.games + 1,
+		P1Wins: score.wins[game.p1Side],
+		P2Wins: score.wins[1-game.p1Side],
+		Draws:  score.draws,
+	}
+}
+
+// recordResult adds this finished game to sr. Caller must hold game.mutex.
+func (game *Game) recordResult(sr *series) {
+	if game.GameState.Winner == 0 {
+		sr.draws++
+	} else {
+		sr.wins[game.side(fmt.Sprintf("player%d", game.GameState.Winner))]++
+	}
+	sr.games++
+}
+
+// follow returns where a connection seated as playerID in game sits now, walking any
+// rematches since. Each rematch swaps the seats.
+func (game *Game) follow(playerID string) (*Game, string) {
+	for {
+		game.mutex.Lock()
+		next := game.successor
+		game.mutex.Unlock()
+		if next == nil {
+			return game, playerID
+		}
+		game, playerID = next, otherSeat(playerID)
+	}
+}
+
+// requestRematch records seat's request after the game is over and asks the other seat.
+// Asking while the opponent's request is pending accepts it. A bot opponent accepts straight away.
+func (game *Game) requestRematch(s *Server, seat string) error {
+	game.mutex.Lock()
+	if !game.GameState.isOver() {
+		game.mutex.Unlock()
+		return fmt.Errorf("game is not over")
+	}
+	if _, seated := game.Players[otherSeat(seat)]; !seated && game.bot == nil {
+		game.mutex.Unlock()
+		return fmt.Errorf("opponent has left")
+	}
+	switch game.rematchFrom {
+	case otherSeat(seat):
+		game.mutex.Unlock()
+		return game.answerRematch(s, seat, true)
+	case seat:
+		game.mutex.Unlock()
+		return fmt.Errorf("rematch already requested")
+	}
+	game.rematchFrom = seat
+	game.mutex.Unlock()
+
+	log.Printf("Player %s requested a rematch in game %s", seat, game.ID)
+	if game.bot != nil {
+		return game.answerRematch(s, game.bot.Seat, true)
+	}
+	game.sendRematchNotice("rematchRequested", otherSeat(seat), seat)
+	return nil
+}
+
+// answerRematch resolves a pending request. seat must be the opponent of the requester.
+func (game *Game) answerRematch(s *Server, seat string, accept bool) error {
+	game.mutex.Lock()
+	requester := game.rematchFrom
+	if requester == "" || requester == seat || game.successor != nil {
+		game.mutex.Unlock()
+		return fmt.Errorf("no rematch to answer")
+	}
+	game.rematchFrom = ""
+	game.mutex.Unlock()
+
+	if !accept {
+		game.sendRematchNotice("rematchDeclined", requester, requester)
+		return nil
+	}
+	next, err := s.startRematch(game)
+	if err != nil {
+		return err
+	}
+	next.commitTurn(s)
+	return nil
+}
+
+// startRematch creates the next game in the series with the same options, moves both
+// connections into it with the seats swapped (so the other player moves first, even when
+// the first mover was a coin flip), and retires the finished game.
+func (s *Server) startRematch(game *Game) (*Game, error) {
+	s.serverMutex.Lock()
+	defer s.serverMutex.Unlock()
+
+	if s.games[game.ID] != game {
+		return nil, fmt.Errorf("game is no longer active")
+	}
+
+	next := NewGame()
+	for _, exists := s.games[next.ID]; exists; _, exists = s.games[next.ID] {
+		next.ID = generateGameID()
+	}
+	next.applyOptions(game.options)
+
+	game.mutex.Lock()
+	for seat, conn := range game.Players {
+		next.Players[otherSeat(seat)] = conn
+	}
+	for seat, user := range game.users {
+		next.users[otherSeat(seat)] = user
+	}
+	if game.bot != nil {
+		next.bot = newBot(game.bot.Level, otherSeat(game.bot.Seat))
+	}
+	// The same seat number moves first, so with the seats swapped the other player does
+	next.GameState.FirstMover = game.GameState.firstMover()
+	if game.series == nil {
+		game.series = &series{}
+	}
+	game.recordResult(game.series)
+	next.series = game.series
+	next.p1Side = 1 - game.p1Side
+	game.successor = next
+	// The connections belong to the new game now; leaving the old one must not touch them
+	game.Players = make(map[string]*websocket.Conn)
+	game.mutex.Unlock()
+
+	s.games[next.ID] = next
+	s.saveNewGame(next)
+	s.evictGame(game)
+	log.Printf("Rematch of game %s started as %s", game.ID, next.ID)
+
+	var wpWg sync.WaitGroup
+	wpWg.Add(1)
+	go next.writePump(s, &wpWg)
+	if next.bot != nil {
+		go next.botPump(s)
+	}
+
+	// Each player learns their new seat and rejoin token, as in "joined"
+	for seat := range next.Players {
+		msg := Message{
+			Type:     "rematch",
+			GameID:   next.ID,
+			PlayerID: seat,
+			Token:    next.tokens[seat],
+			State:    next.GameState.State,
+			Payload:  RematchStarted{PreviousGameID: game.ID},
+			To:       seat,
+		}
+		select {
+		case next.send <- msg:
+		default:
+			log.Printf("Send channel full, dropping rematch notice for game %s", next.ID)
+		}
+	}
+	return next, nil
+}
+
+// sendRematchNotice sends msgType privately to seat to; the payload names the requester.
+func (game *Game) sendRematchNotice(msgType string, to string, requester string) {
+	msg := Message{
+		Type:     msgType,
+		GameID:   game.ID,
+		PlayerID: to,
+		State:    game.GameState.State,
+		Payload:  RematchNotice{Seat: requester},
+		To:       to,
+	}
+	select {
+	case game.send <- msg:
+	default:
+		log.Printf("Send channel full, dropping %s for game %s", msgType, game.ID)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- Helpers ---
+
+// newTestConn returns the server side of a live websocket and the client side to read from.
+func newTestConn(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	upgraded := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err == nil {
+			upgraded <- conn
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial test server: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return <-upgraded, client
+}
+
+// readUntil reads messages from client until one of type msgType arrives.
+func readUntil(t *testing.T, client *websocket.Conn, msgType string) map[string]interface{} {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg map[string]interface{}
+		if err := client.ReadJSON(&msg); err != nil {
+			t.Fatalf("no %s message: %v", msgType, err)
+		}
+		if msg["type"] == msgType {
+			return msg
+		}
+	}
+}
+
+func finishedGame(t *testing.T, s *Server) (*Game, *websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	game := newSeatedGame(s)
+	conn1, client1 := newTestConn(t)
+	conn2, client2 := newTestConn(t)
+	game.Players["player1"] = conn1
+	game.Players["player2"] = conn2
+	game.resign(s, "player1")
+	return game, client1, client2
+}
+
+// --- Rematch ---
+
+func TestRematch_NotBeforeGameOver(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	if err := game.requestRematch(s, "player1"); err == nil {
+		t.Error("expected a rematch request to be refused mid-game")
+	}
+}
+
+func TestRematch_AcceptedSwapsSeatsIntoNewGame(t *testing.T) {
+	s := NewServer()
+	game, client1, client2 := finishedGame(t, s)
+	conn2 := game.Players["player2"]
+	game.GameState.FirstMover = 2
+
+	if err := game.requestRematch(s, "player1"); err != nil {
+		t.Fatalf("unexpected error requesting rematch: %v", err)
+	}
+	if err := game.answerRematch(s, "player2", true); err != nil {
+		t.Fatalf("unexpected error accepting rematch: %v", err)
+	}
+
+	next, seat := game.follow("player2")
+	if next == game || seat != "player1" {
+		t.Fatalf("expected player2 to follow into the new game as player1, got %q", seat)
+	}
+	defer func() {
+		s.serverMutex.Lock()
+		s.evictGame(next)
+		s.serverMutex.Unlock()
+	}()
+	if _, exists := s.games[game.ID]; exists {
+		t.Error("expected the finished game to be retired")
+	}
+	if s.games[next.ID] != next || next.Players["player1"] != conn2 {
+		t.Error("expected the old player2 connection in the new game's player1 seat")
+	}
+	if next.GameState.TurnNumber != 0 || next.GameState.State != "WAITING" {
+		t.Errorf("expected a fresh game, got %s on turn %d", next.GameState.State, next.GameState.TurnNumber)
+	}
+	if next.GameState.FirstMover != 2 {
+		t.Errorf("expected the same seat to move first so the other player does, got %d", next.GameState.FirstMover)
+	}
+
+	score := next.seriesScore()
+	if score == nil || score.Game != 2 || score.P1Wins != 1 || score.P2Wins != 0 || score.Draws != 0 {
+		t.Errorf("expected game 2 with the new player1 one win up, got %+v", score)
+	}
+
+	msg := readUntil(t, client2, "rematch")
+	if msg["gameID"] != next.ID || msg["playerID"] != "player1" || msg["token"] != next.tokens["player1"] {
+		t.Errorf("expected player2 to be told its new seat and token, got %+v", msg)
+	}
+	msg = readUntil(t, client1, "rematch")
+	if msg["playerID"] != "player2" {
+		t.Errorf("expected player1 to be moved to player2, got %+v", msg)
+	}
+}
+
+func TestRematch_Declined(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	game.resign(s, "player2")
+	for len(game.send) > 0 {
+		<-game.send
+	}
+
+	game.requestRematch(s, "player1")
+	msg := <-game.send
+	if msg.Type != "rematchRequested" || msg.To != "player2" {
+		t.Errorf("expected rematchRequested addressed to player2, got %+v", msg)
+	}
+	if err := game.answerRematch(s, "player2", false); err != nil {
+		t.Fatalf("unexpected error declining rematch: %v", err)
+	}
+	msg = <-game.send
+	if msg.Type != "rematchDeclined" || msg.To != "player1" {
+		t.Errorf("expected rematchDeclined addressed to player1, got %+v", msg)
+	}
+	if game.successor != nil {
+		t.Error("expected no new game after a decline")
+	}
+}
+
+func TestRematch_BotAcceptsAndSwitchesSeat(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	delete(game.Players, "player2")
+	game.bot = newBot(BotRandom, "player2")
+	game.resign(s, "player1")
+
+	if err := game.requestRematch(s, "player1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next, seat := game.follow("player1")
+	defer func() {
+		s.serverMutex.Lock()
+		s.evictGame(next)
+		s.serverMutex.Unlock()
+	}()
+	if next == game || seat != "player2" || next.bot == nil || next.bot.Seat != "player1" {
+		t.Error("expected the bot to accept and move first in the new game")
+	}
+}
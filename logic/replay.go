@@ -0,0 +1,316 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const replayBuffer = 16 // per-viewer queue of outgoing messages
+
+// Replay is a finished game rebuilt from its move list for review. States[n] is the
+// gameState broadcast after the game's nth action, with the move's animation fields
+// (placed, boopMovement, booped, graduatedLine, previousBoard) as they were at the time;
+// States[0] is the starting position.
+type Replay struct {
+	Game   ArchivedGame
+	States []GameState
+}
+
+// ReplayPosition says where a replay is: ply n is the position after n actions.
+type ReplayPosition struct {
+	Ply   int `json:"ply"`
+	Plies int `json:"plies"` // the last ply, i.e. the number of actions
+}
+
+// ReplayFrame is one ply of a replay over HTTP.
+type ReplayFrame struct {
+	ReplayPosition
+	State GameState `json:"state"`
+}
+
+var errNoReplay = errors.New("no such finished game")
+
+// loadReplay replays an archived game's actions through the engine. A game that ended off
+// the board (resignation, timeout, abandonment, agreed draw) ends on its last ply.
+func (s *Server) loadReplay(gameID string) (*Replay, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("archive needs a database (DB_PATH)")
+	}
+	row := s.db.QueryRow(`SELECT `+archivedGameColumns+archivedGameJoins+` WHERE f.id = ?`, gameID)
+	g, err := scanArchivedGame(row)
+	if err == sql.ErrNoRows {
+		return nil, errNoReplay
+	}
+	if err != nil {
+		return nil, err
+	}
+	moves, err := s.loadActions(gameID)
+	if err != nil {
+		return nil, err
+	}
+
+	var users *SeatUsers
+	if g.Players.P1 != nil || g.Players.P2 != nil {
+		players := g.Players
+		users = &players
+	}
+	rules, err := s.loadArchivedRules(gameID)
+	if err != nil {
+		return nil, err
+	}
+	start, err := startState(g.Position, g.FirstMover, rules)
+	if err != nil {
+		return nil, fmt.Errorf("start position of game %s: %v", gameID, err)
+	}
+	state := *start
+	states := make([]GameState, 0, len(moves)+1)
+	states = append(states, state)
+	for _, m := range moves {
+		next, _, err := Apply(state, m.Action)
+		if err != nil {
+			return nil, fmt.Errorf("action %d of game %s: %v", m.Seq, gameID, err)
+		}
+		state = next
+		states = append(states, state)
+	}
+	last := &states[len(states)-1]
+	if !last.isOver() {
+		last.finish(g.Winner, g.Result)
+	}
+	for i := range states {
+		states[i].LegalMoves = LegalMoves(&states[i])
+		states[i].Users = users
+		states[i].BroadcastSeq = uint32(i + 1)
+	}
+	return &Replay{Game: g, States: states}, nil
+}
+
+func (r *Replay) plies() int { return len(r.States) - 1 }
+
+// frame is the gameState for ply. Without animate the move fields are cleared, as after a
+// takeback, so a client jumping to ply redraws the board instead of replaying the move.
+func (r *Replay) frame(ply int, animate bool) GameState {
+	state := r.States[ply]
+	if !animate {
+		state.Placed = Move{}
+		state.BoopMovement = nil
+		state.Booped = nil
+		state.Lines = nil
+		state.GraduatedLine = nil
+	}
+	return state
+}
+
+// replayViewer is a /ws connection stepping through a replay. It has no game; the read
+// loop moves the cursor and queues states for its own writer goroutine.
+type replayViewer struct {
+	replay    *Replay
+	ply       int
+	conn      *websocket.Conn
+	send      chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (v *replayViewer) close() {
+	v.closeOnce.Do(func() {
+		close(v.done)
+	})
+}
+
+// show queues ply as a gameState and moves the cursor there.
+func (v *replayViewer) show(ply int, animate bool) {
+	v.ply = ply
+	msg := Message{
+		Type:     "gameState",
+		GameID:   v.replay.Game.ID,
+		PlayerID: "replay",
+		State:    v.replay.States[ply].State,
+		Replay:   &ReplayPosition{Ply: ply, Plies: v.replay.plies()},
+		Payload:  v.replay.frame(ply, animate),
+	}
+	select {
+	case v.send <- msg:
+	case <-v.done:
+	}
+}
+
+// sendError reports a rejected frame to the viewer.
+func (v *replayViewer) sendError(err *ProtocolError) {
+	msg := Message{
+		Type:     "error",
+		GameID:   v.replay.Game.ID,
+		PlayerID: "replay",
+		State:    v.replay.States[v.ply].State,
+		Code:     err.Code,
+		Payload:  err.Message,
+	}
+	select {
+	case v.send <- msg:
+	case <-v.done:
+	}
+}
+
+// handleFrame acts on one control frame. Stepping forward animates the move as it was
+// played; stepping back and seeking redraw the board.
+func (v *replayViewer) handleFrame(frame Inbound) error {
+	switch frame.Type {
+	case framePong:
+		if err := v.conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+			return errDisconnected
+		}
+		return nil
+	case frameReplayForward:
+		if v.ply == v.replay.plies() {
+			return &ProtocolError{Code: CodeReplay, Message: "Already at the last ply"}
+		}
+		v.show(v.ply+1, true)
+		return nil
+	case frameReplayBack:
+		if v.ply == 0 {
+			return &ProtocolError{Code: CodeReplay, Message: "Already at the start"}
+		}
+		v.show(v.ply-1, false)
+		return nil
+	case frameReplaySeek:
+		if frame.Ply < 0 || frame.Ply > v.replay.plies() {
+			return &ProtocolError{Code: CodeReplay, Message: fmt.Sprintf("Ply must be 0-%d", v.replay.plies())}
+		}
+		v.show(frame.Ply, false)
+		return nil
+	}
+	return &ProtocolError{Code: CodeUnknownType, Message: fmt.Sprintf("Unknown frame type %q", frame.Type)}
+}
+
+// writePump drains the viewer's queue and keeps the connection alive with pings.
+func (v *replayViewer) writePump() {
+	defer v.close()
+	defer v.conn.Close()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-v.done:
+			return
+		case msg := <-v.send:
+			v.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := v.conn.WriteJSON(msg); err != nil {
+				log.Printf("Failed to write to replay viewer of game %s: %v", v.replay.Game.ID, err)
+				return
+			}
+		case <-ticker.C:
+			v.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := v.conn.WriteJSON(Message{Type: "ping"}); err != nil {
+				log.Printf("Failed to ping replay viewer of game %s: %v", v.replay.Game.ID, err)
+				return
+			}
+		}
+	}
+}
+
+// handleReplay serves /ws?gameID=X&role=replay: "joined" carries ply 0, then each
+// replayForward, replayBack or replaySeek frame is answered with a gameState.
+func (s *Server) handleReplay(conn *websocket.Conn, gameID string, version int) {
+	replay, err := s.loadReplay(gameID)
+	if err != nil {
+		if err != errNoReplay {
+			log.Printf("Replay of game %s failed: %v", gameID, err)
+		}
+		conn.WriteJSON(Message{Type: "error", Code: CodeReplay, Payload: "Could not replay game: " + err.Error()})
+		conn.Close()
+		return
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteJSON(Message{
+		Type:     "joined",
+		GameID:   replay.Game.ID,
+		PlayerID: "replay",
+		Version:  version,
+		State:    replay.States[0].State,
+		Replay:   &ReplayPosition{Ply: 0, Plies: replay.plies()},
+		Payload:  replay.frame(0, false),
+	}); err != nil {
+		log.Printf("Error sending replay of game %s: %v", gameID, err)
+		conn.Close()
+		return
+	}
+
+	v := &replayViewer{
+		replay: replay,
+		conn:   conn,
+		send:   make(chan Message, replayBuffer),
+		done:   make(chan struct{}),
+	}
+	go v.writePump()
+	defer v.close()
+
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		frame, err := decodeFrame(data, version)
+		if err == nil {
+			err = v.handleFrame(frame)
+		}
+		var perr *ProtocolError
+		if errors.As(err, &perr) {
+			v.sendError(perr)
+		} else if err != nil {
+			return
+		}
+	}
+}
+
+// --- HTTP ---
+
+// handleReplayFrames serves /archive/replay?id=X: {plies, frames: [{ply, plies, state}]}
+// for every ply, or with &ply=N just that ply's frame.
+func (s *Server) handleReplayFrames(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+	if s.db == nil {
+		http.Error(w, "archive needs a database (DB_PATH)", http.StatusServiceUnavailable)
+		return
+	}
+	replay, err := s.loadReplay(r.URL.Query().Get("id"))
+	if err == errNoReplay {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("handleReplayFrames: %v", err)
+		http.Error(w, "archive unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	plies := replay.plies()
+	if value := r.URL.Query().Get("ply"); value != "" {
+		ply, err := strconv.Atoi(value)
+		if err != nil || ply < 0 || ply > plies {
+			http.Error(w, fmt.Sprintf("ply must be 0-%d", plies), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, ReplayFrame{ReplayPosition{Ply: ply, Plies: plies}, replay.frame(ply, true)})
+		return
+	}
+	frames := make([]ReplayFrame, 0, plies+1)
+	for ply := range replay.States {
+		frames = append(frames, ReplayFrame{ReplayPosition{Ply: ply, Plies: plies}, replay.frame(ply, true)})
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Plies  int           `json:"plies"`
+		Frames []ReplayFrame `json:"frames"`
+	}{plies, frames})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// --- Helpers ---
+
+// finishedReplayGame archives a two-move game player1 resigns, where player2's kitten
+// boops player1's off the corner.
+func finishedReplayGame(t *testing.T, s *Server) *Game {
+	t.Helper()
+	game := newSeatedGame(s)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	playAction(t, game, placeAction(P2Kitten, 1, 1))
+	if err := game.resign(s, "player1"); err != nil {
+		t.Fatalf("unexpected resign error: %v", err)
+	}
+	return game
+}
+
+// --- Replay ---
+
+func TestReplay_RebuildsEveryPly(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := finishedReplayGame(t, s)
+
+	replay, err := s.loadReplay(game.ID)
+	if err != nil {
+		t.Fatalf("unexpected replay error: %v", err)
+	}
+	if replay.plies() != 2 || replay.States[0].Board != NewGameState().Board {
+		t.Fatalf("expected the empty board and two plies, got %d", replay.plies())
+	}
+	second := replay.States[2]
+	if second.Placed.Piece != P2Kitten || len(second.Booped) != 1 || second.PreviousBoard[0][0] != P1Kitten {
+		t.Errorf("expected the second ply to carry the boop off the corner, got %+v", second)
+	}
+	if second.Board != game.GameState.Board || second.Winner != 2 || second.Result != ResultResignation {
+		t.Errorf("expected the last ply to end as the game did, got %s (%d)", second.Result, second.Winner)
+	}
+	if cleared := replay.frame(2, false); cleared.Placed != (Move{}) || cleared.Booped != nil || cleared.Board != second.Board {
+		t.Errorf("expected a jump to clear only the move fields, got %+v", cleared)
+	}
+	if _, err := s.loadReplay("NOPE"); err != errNoReplay {
+		t.Errorf("expected an unknown game to have no replay, got %v", err)
+	}
+}
+
+func TestReplay_HTTPFrames(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := finishedReplayGame(t, s)
+
+	var all struct {
+		Plies  int           `json:"plies"`
+		Frames []ReplayFrame `json:"frames"`
+	}
+	if code := getJSON(t, s.handleReplayFrames, "/archive/replay?id="+game.ID, &all); code != http.StatusOK {
+		t.Fatalf("replay returned %d", code)
+	}
+	if all.Plies != 2 || len(all.Frames) != 3 || all.Frames[1].Ply != 1 || all.Frames[1].State.Placed.Piece != P1Kitten {
+		t.Errorf("expected a frame per ply from the empty board, got %+v", all)
+	}
+	if all.Frames[2].State.BroadcastSeq <= all.Frames[1].State.BroadcastSeq {
+		t.Error("expected each frame to have a new broadcastSeq so the client animates it")
+	}
+
+	var one ReplayFrame
+	if code := getJSON(t, s.handleReplayFrames, "/archive/replay?id="+game.ID+"&ply=2", &one); code != http.StatusOK {
+		t.Fatalf("replay ply returned %d", code)
+	}
+	if one.Ply != 2 || one.Plies != 2 || len(one.State.BoopMovement)+len(one.State.Booped) == 0 || one.State.State != "GAME_OVER" {
+		t.Errorf("expected the last ply with its boop, got %+v", one)
+	}
+	if code := getJSON(t, s.handleReplayFrames, "/archive/replay?id="+game.ID+"&ply=3", &one); code != http.StatusBadRequest {
+		t.Errorf("expected a ply past the end to be refused, got %d", code)
+	}
+	if code := getJSON(t, s.handleReplayFrames, "/archive/replay?id=NOPE", &one); code != http.StatusNotFound {
+		t.Errorf("expected an unknown game to be not found, got %d", code)
+	}
+}
+
+func TestReplay_WebSocketControls(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := finishedReplayGame(t, s)
+
+	client := dialServer(t, s, "v=2&role=replay&gameID="+game.ID)
+	joined := readUntil(t, client, "joined")
+	if joined["playerID"] != "replay" || joined["replay"].(map[string]interface{})["plies"] != float64(2) {
+		t.Fatalf("expected joined at ply 0 of 2, got %+v", joined)
+	}
+
+	client.WriteJSON(map[string]interface{}{"type": frameReplayForward})
+	msg := readUntil(t, client, "gameState")
+	placed := msg["payload"].(map[string]interface{})["placed"].(map[string]interface{})
+	if msg["replay"].(map[string]interface{})["ply"] != float64(1) || placed["piece"] != float64(P1Kitten) {
+		t.Errorf("expected stepping forward to animate the first placement, got %+v", msg)
+	}
+
+	client.WriteJSON(map[string]interface{}{"type": frameReplaySeek, "ply": 2})
+	msg = readUntil(t, client, "gameState")
+	payload := msg["payload"].(map[string]interface{})
+	if msg["replay"].(map[string]interface{})["ply"] != float64(2) || payload["booped"] != nil || payload["state"] != "GAME_OVER" {
+		t.Errorf("expected a seek to the end with nothing to animate, got %+v", msg)
+	}
+
+	client.WriteJSON(map[string]interface{}{"type": frameReplayForward})
+	if msg := readUntil(t, client, "error"); msg["code"] != CodeReplay {
+		t.Errorf("expected stepping past the end to be refused, got %+v", msg)
+	}
+	client.WriteJSON(map[string]interface{}{"type": frameReplayBack})
+	if msg := readUntil(t, client, "gameState"); msg["replay"].(map[string]interface{})["ply"] != float64(1) {
+		t.Errorf("expected stepping back to reach ply 1, got %+v", msg)
+	}
+
+	missing := dialServer(t, s, "v=2&role=replay&gameID=NOPE")
+	if msg := readUntil(t, missing, "error"); msg["code"] != CodeReplay {
+		t.Errorf("expected an unknown game to be refused, got %+v", msg)
+	}
+}
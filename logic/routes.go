@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
@@ -16,50 +18,175 @@ func (s *Server) handleConnection(w http.ResponseWriter, r *http.Request) {
 	}
 	conn.SetReadLimit(4096) // valid moves are tiny JSON; prevent memory exhaustion
 
+	version, err := parseVersion(r.URL.Query().Get("v"))
+	if err != nil {
+		conn.WriteJSON(Message{Type: "error", Code: CodeUnsupportedVersion, Payload: err.Error()})
+		conn.Close()
+		return
+	}
+
 	gameID := r.URL.Query().Get("gameID")
+	token := r.URL.Query().Get("token")
 	var game *Game
 	var playerID string
+	rejoined := false
+
+	if r.URL.Query().Get("role") == "spectator" {
+		s.handleSpectator(conn, gameID, version)
+		return
+	}
+	if r.URL.Query().Get("role") == "replay" {
+		s.handleReplay(conn, gameID, version)
+		return
+	}
+
+	// Players may connect anonymously; a session token binds their seat to an account
+	var user *User
+	if session := r.URL.Query().Get("session"); session != "" {
+		user, err = s.verifySession(session, time.Now())
+		if err != nil {
+			conn.WriteJSON(Message{Type: "error", Code: CodeBadSession, Payload: "Could not sign in: " + err.Error()})
+			conn.Close()
+			return
+		}
+	}
 
+	var opts GameOptions
 	if gameID == "" {
-		game = s.createGame(conn)
+		opts, err = parseGameOptions(r.URL.Query())
+		if err != nil {
+			conn.WriteJSON(Message{Type: "error", Code: CodeBadOptions, Payload: "Could not create game: " + err.Error()})
+			conn.Close()
+			return
+		}
+		if user != nil {
+			opts.Name = user.Username
+		}
+		if opts.Rated && user == nil {
+			conn.WriteJSON(Message{Type: "error", Code: CodeBadSession, Payload: "Could not create game: " + errRatedNeedsAccount.Error()})
+			conn.Close()
+			return
+		}
+	}
+
+	queue, err := parseQueue(r.URL.Query().Get("queue"))
+	if err == nil && queue != "" && (gameID != "" || r.URL.Query().Get("opponent") == "bot" || opts.Position != "") {
+		err = fmt.Errorf("queue can't be combined with gameID, opponent or position")
+	}
+	if err == nil && queue == QueueRated && opts.Rules != nil {
+		err = fmt.Errorf("rated games use the standard rules")
+	}
+	if err != nil {
+		conn.WriteJSON(Message{Type: "error", Code: CodeBadOptions, Payload: "Could not queue: " + err.Error()})
+		conn.Close()
+		return
+	}
+	if queue == QueueRated && user == nil {
+		conn.WriteJSON(Message{Type: "error", Code: CodeBadSession, Payload: "Could not queue: " + errRatedNeedsAccount.Error()})
+		conn.Close()
+		return
+	}
+	if queue != "" {
+		s.handleQueue(conn, queue, opts, user, version)
+		return
+	}
+
+	if gameID == "" && r.URL.Query().Get("opponent") == "bot" && opts.Rated {
+		conn.WriteJSON(Message{Type: "error", Code: CodeBadOptions, Payload: "Could not create bot game: bot games can't be rated"})
+		conn.Close()
+		return
+	}
+	if gameID == "" && r.URL.Query().Get("opponent") == "bot" {
+		game, err = s.createBotGame(conn, r.URL.Query().Get("level"), opts)
+		if err != nil {
+			conn.WriteJSON(Message{Type: "error", Code: CodeBotUnavailable, Payload: "Could not create bot game: " + err.Error()})
+			conn.Close()
+			return
+		}
+		playerID = "player1"
+
+		var wpWg sync.WaitGroup
+		wpWg.Add(1)
+		go game.writePump(s, &wpWg)
+		go game.botPump(s)
+	} else if gameID == "" {
+		game = s.createGame(conn, opts, user)
 		playerID = "player1"
 
 		// First player starts the writePump for this game
 		var wpWg sync.WaitGroup
 		wpWg.Add(1)
 		go game.writePump(s, &wpWg)
+	} else if token != "" {
+		game, playerID = s.rejoinGame(conn, gameID, token)
+		if game == nil {
+			conn.WriteJSON(Message{Type: "error", Code: CodeRejoinFailed, Payload: "Could not rejoin game"})
+			conn.Close()
+			return
+		}
+		rejoined = true
 	} else {
-		game = s.joinGame(conn, gameID)
+		game, err = s.joinGame(conn, gameID, r.URL.Query().Get("invite"), r.URL.Query().Get("passphrase"), user)
 		playerID = "player2"
-		if game == nil {
-			conn.WriteJSON(Message{Type: "error", Payload: "Could not join game"})
+		if errors.Is(err, errBadPassphrase) {
+			conn.WriteJSON(Message{Type: "error", Code: CodeBadPassphrase, Payload: "Could not join game: wrong passphrase"})
+			conn.Close()
+			return
+		}
+		if errors.Is(err, errRatedNeedsAccount) || errors.Is(err, errRatedSelf) {
+			conn.WriteJSON(Message{Type: "error", Code: CodeJoinFailed, Payload: "Could not join game: " + err.Error()})
+			conn.Close()
+			return
+		}
+		if err != nil {
+			log.Printf("Join of game %s refused: %v", gameID, err)
+			conn.WriteJSON(Message{Type: "error", Code: CodeJoinFailed, Payload: "Could not join game"})
 			conn.Close()
 			return
 		}
 	}
 
+	game.bindUser(playerID, user)
+	s.saveGameUser(game, playerID)
+
 	// Send initial game state
-	conn.SetWriteDeadline(time.Now().Add(writeWait))
-	if err := conn.WriteJSON(Message{
+	joined := Message{
 		Type:     "joined",
 		GameID:   game.ID,
 		PlayerID: playerID,
+		Token:    game.tokens[playerID],
+		Version:  version,
 		Payload:  game.GameState,
-	}); err != nil {
+	}
+	if playerID == "player1" && game.bot == nil {
+		joined.Invite = game.invite()
+	}
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteJSON(joined); err != nil {
 		log.Printf("Error sending initial game state: %v", err)
-		s.handlePlayerDisconnect(game.ID, playerID)
+		s.handlePlayerDisconnect(game.ID, playerID, conn)
 		return
 	}
 
-	// Notify all players when a second player joins
-	if playerID == "player2" {
+	// Notify all players when a second player joins or a seat is reclaimed.
+	// A rejoining player already has the full state from "joined"; re-broadcasting
+	// gameState would replay the last move's animations for the opponent.
+	if rejoined {
+		game.notifySeat("playerReconnected", playerID, nil)
+		game.resumeAfterRejoin(s)
+	} else if playerID == "player2" || game.bot != nil {
+		// A bot opponent is seated immediately, so the game starts straight away
+		game.tickClock(s)
 		game.broadcastGameState()
+		game.wakeBot()
 	}
 
 	log.Printf("Player %s joined game %s", playerID, game.ID)
-	s.handleGameLoop(conn, game, playerID)
+	s.handleGameLoop(conn, game, playerID, version)
 }
 
+// handleGetWaitingGameID is the original lobby, kept for older clients: just the IDs of
+// listed waiting games, newest first. New clients use /lobby.
 func (s *Server) handleGetWaitingGameID(w http.ResponseWriter, r *http.Request) {
 	enableCors(&w)
 	s.serverMutex.Lock()
@@ -68,13 +195,9 @@ func (s *Server) handleGetWaitingGameID(w http.ResponseWriter, r *http.Request)
 	type GameIDs struct {
 		IDs []string `json:"ids"`
 	}
-	var ids GameIDs
-	if len(s.waitingGames) != 0 {
-		for id := range s.waitingGames {
-			ids.IDs = append(ids.IDs, id)
-		}
-	} else {
-		ids.IDs = append(ids.IDs, "No games waiting")
+	ids := GameIDs{IDs: []string{}}
+	for _, lg := range s.listLobby(lobbyFilter{}) {
+		ids.IDs = append(ids.IDs, lg.ID)
 	}
 	jsonID, _ := json.Marshal(ids)
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Ruleset is the rules a game is played under, chosen by the creator and fixed for the
+// life of the game. It travels in GameState as "rules" so the engine, persistence and
+// clients all see it; a state without one is played under StandardRules.
+type Ruleset struct {
+	BoardSize       uint8 `json:"boardSize"`       // squares along each side of the board
+	Pieces          uint8 `json:"pieces"`          // kittens each player starts with, and the hand size that forces a graduation
+	WinLength       uint8 `json:"winLength"`       // cats in a row that win
+	EightCatsWin    bool  `json:"eightCatsWin"`    // having every piece on the board as a cat wins
+	KittensBoopCats bool  `json:"kittensBoopCats"` // kittens push cats as well as kittens
+	MixedLines      bool  `json:"mixedLines"`      // lines mixing kittens and cats graduate
+}
+
+// StandardRules are the published rules of boop.
+var StandardRules = Ruleset{BoardSize: 6, Pieces: 8, WinLength: 3, EightCatsWin: true, KittensBoopCats: false, MixedLines: true}
+
+const (
+	minPieces = 3
+	maxPieces = 16
+)
+
+// UnmarshalJSON reads rules saved before boards could be resized as played on the
+// standard board.
+func (r *Ruleset) UnmarshalJSON(data []byte) error {
+	type plain Ruleset
+	rules := plain{BoardSize: StandardRules.BoardSize}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return err
+	}
+	*r = Ruleset(rules)
+	return nil
+}
+
+// rules returns the ruleset gs is played under.
+func (gs *GameState) rules() Ruleset {
+	if gs.Rules == nil {
+		return StandardRules
+	}
+	return *gs.Rules
+}
+
+// boardSize is the number of squares along each side of gs's board.
+func (gs *GameState) boardSize() int {
+	return int(gs.rules().BoardSize)
+}
+
+// parseRuleset reads rule overrides from a query string: boardSize, pieces, winLength,
+// eightCatsWin, kittensBoopCats and mixedLines. Anything not given keeps its standard value.
+func parseRuleset(query url.Values) (Ruleset, error) {
+	rules := StandardRules
+	for _, param := range []struct {
+		name string
+		dest *uint8
+		min  uint8
+		max  uint8
+	}{
+		{"boardSize", &rules.BoardSize, minBoardSize, maxBoardSize},
+		{"pieces", &rules.Pieces, minPieces, maxPieces},
+		{"winLength", &rules.WinLength, 3, maxBoardSize},
+	} {
+		if value := query.Get(param.name); value != "" {
+			n, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || uint8(n) < param.min || uint8(n) > param.max {
+				return rules, fmt.Errorf("%s must be %d-%d", param.name, param.min, param.max)
+			}
+			*param.dest = uint8(n)
+		}
+	}
+	for _, param := range []struct {
+		name string
+		dest *bool
+	}{
+		{"eightCatsWin", &rules.EightCatsWin},
+		{"kittensBoopCats", &rules.KittensBoopCats},
+		{"mixedLines", &rules.MixedLines},
+	} {
+		if value := query.Get(param.name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return rules, fmt.Errorf("invalid %s flag %q", param.name, value)
+			}
+			*param.dest = b
+		}
+	}
+	// Both hands on the board at once must leave the mover somewhere to place
+	size := int(rules.BoardSize)
+	if rules.WinLength > rules.BoardSize {
+		return rules, fmt.Errorf("winLength can't be more than the board size %d", size)
+	}
+	if 2*int(rules.Pieces) > size*size {
+		return rules, fmt.Errorf("a %dx%d board has room for at most %d pieces each", size, size, size*size/2)
+	}
+	return rules, nil
+}
+
+// String writes the rules that differ from StandardRules in the form parseRuleset reads,
+// e.g. "pieces=6&winLength=4". It is "" for the standard rules.
+func (r Ruleset) String() string {
+	query := url.Values{}
+	if r.BoardSize != StandardRules.BoardSize {
+		query.Set("boardSize", strconv.Itoa(int(r.BoardSize)))
+	}
+	if r.Pieces != StandardRules.Pieces {
+		query.Set("pieces", strconv.Itoa(int(r.Pieces)))
+	}
+	if r.WinLength != StandardRules.WinLength {
+		query.Set("winLength", strconv.Itoa(int(r.WinLength)))
+	}
+	for _, flag := range []struct {
+		name     string
+		value    bool
+		standard bool
+	}{
+		{"eightCatsWin", r.EightCatsWin, StandardRules.EightCatsWin},
+		{"kittensBoopCats", r.KittensBoopCats, StandardRules.KittensBoopCats},
+		{"mixedLines", r.MixedLines, StandardRules.MixedLines},
+	} {
+		if flag.value != flag.standard {
+			query.Set(flag.name, strconv.FormatBool(flag.value))
+		}
+	}
+	return query.Encode()
+}
+
+// variantOf names a ruleset in the lobby and in records.
+func variantOf(rules *Ruleset) string {
+	if rules == nil || *rules == StandardRules {
+		return VariantStandard
+	}
+	return VariantCustom
+}
+
+func sameRules(a, b *Ruleset) bool {
+	if variantOf(a) == VariantStandard || variantOf(b) == VariantStandard {
+		return variantOf(a) == variantOf(b)
+	}
+	return *a == *b
+}
+
+// sameBoardSize reports whether a and b are played on the same size of board.
+func sameBoardSize(a, b *Ruleset) bool {
+	size := func(r *Ruleset) uint8 {
+		if r == nil {
+			return StandardRules.BoardSize
+		}
+		return r.BoardSize
+	}
+	return size(a) == size(b)
+}
+
+// orStandard is rules, or the standard rules for a game that has none of its own.
+func orStandard(rules *Ruleset) *Ruleset {
+	if rules == nil {
+		return &StandardRules
+	}
+	return rules
+}
+
+// withRules starts gs with rules: it records them and, unless gs came from a position,
+// deals each player the ruleset's pieces.
+func (gs *GameState) withRules(rules *Ruleset, fromPosition bool) {
+	if rules == nil || *rules == StandardRules {
+		return
+	}
+	gs.Rules = rules
+	if !fromPosition {
+		gs.P1 = Player{Kittens: rules.Pieces}
+		gs.P2 = Player{Kittens: rules.Pieces}
+	}
+}
+
+// loadArchivedRules returns the rules a finished game was played under, from its final
+// state, or nil for the standard rules.
+func (s *Server) loadArchivedRules(gameID string) (*Ruleset, error) {
+	var finalState string
+	if err := s.db.QueryRow(`SELECT final_state FROM finished_games WHERE id = ?`, gameID).Scan(&finalState); err != nil {
+		return nil, err
+	}
+	var state struct {
+		Rules *Ruleset `json:"rules"`
+	}
+	if err := json.Unmarshal([]byte(finalState), &state); err != nil {
+		return nil, err
+	}
+	return state.Rules, nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// --- Helpers ---
+
+// newRulesTurn is player1 to move under rules with hand in their hand.
+func newRulesTurn(rules Ruleset, hand Player) *GameState {
+	gs := newP1Turn()
+	gs.Rules = &rules
+	gs.P1 = hand
+	return gs
+}
+
+func applyPlace(t *testing.T, gs *GameState, tile uint8, x, y uint8) GameState {
+	t.Helper()
+	next, _, err := Apply(*gs, placeAction(tile, x, y))
+	if err != nil {
+		t.Fatalf("unexpected error placing %d at %d,%d: %v", tile, x, y, err)
+	}
+	return next
+}
+
+// --- Parsing ---
+
+func TestRuleset_Parse(t *testing.T) {
+	rules, err := parseRuleset(url.Values{})
+	if err != nil || rules != StandardRules || rules.String() != "" {
+		t.Errorf("expected no parameters to mean the standard rules, got %+v (%v)", rules, err)
+	}
+	rules, err = parseRuleset(url.Values{"pieces": {"6"}, "winLength": {"4"}, "eightCatsWin": {"false"}, "kittensBoopCats": {"true"}, "mixedLines": {"false"}})
+	want := Ruleset{BoardSize: 6, Pieces: 6, WinLength: 4, KittensBoopCats: true}
+	if err != nil || rules != want {
+		t.Fatalf("expected %+v, got %+v (%v)", want, rules, err)
+	}
+	query, _ := url.ParseQuery(rules.String())
+	if again, err := parseRuleset(query); err != nil || again != rules {
+		t.Errorf("expected %q to read back as the same rules, got %+v (%v)", rules.String(), again, err)
+	}
+	for _, bad := range []url.Values{
+		{"pieces": {"2"}},
+		{"pieces": {"17"}},
+		{"winLength": {"7"}},
+		{"mixedLines": {"sometimes"}},
+	} {
+		if _, err := parseRuleset(bad); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+// --- Engine ---
+
+func TestRuleset_Pieces(t *testing.T) {
+	game := NewGame()
+	game.applyOptions(GameOptions{Rules: &Ruleset{BoardSize: 6, Pieces: 5, WinLength: 3, EightCatsWin: true, MixedLines: true}})
+	gs := game.GameState
+	if gs.P1.Kittens != 5 || gs.P2.Kittens != 5 {
+		t.Fatalf("expected five kittens each, got %+v %+v", gs.P1, gs.P2)
+	}
+	for _, pos := range []Position{{0, 0}, {2, 0}, {4, 0}, {0, 2}} {
+		place(gs, P1Kitten, pos.X, pos.Y)
+	}
+	if next := applyPlace(t, gs, P1Kitten, 4, 2); next.State != "MAX_WAITING" {
+		t.Errorf("expected the fifth piece on the board to force a graduation, got %s", next.State)
+	}
+}
+
+func TestRuleset_EightCatsWinDisabled(t *testing.T) {
+	rules := Ruleset{BoardSize: 6, Pieces: 3, WinLength: 3, MixedLines: true}
+	gs := newRulesTurn(rules, Player{Cats: 3})
+	place(gs, P1Cat, 0, 0)
+	place(gs, P1Cat, 2, 0)
+	if next := applyPlace(t, gs, P1Cat, 4, 0); next.isOver() || next.State != "MAX_WAITING" {
+		t.Errorf("expected a full board of cats not to win, got %s (%s)", next.State, next.Result)
+	}
+	rules.EightCatsWin = true
+	gs = newRulesTurn(rules, Player{Cats: 3})
+	place(gs, P1Cat, 0, 0)
+	place(gs, P1Cat, 2, 0)
+	if next := applyPlace(t, gs, P1Cat, 4, 0); next.Result != ResultEightCats {
+		t.Errorf("expected every piece on the board as a cat to win, got %s", next.Result)
+	}
+}
+
+func TestRuleset_KittensBoopCats(t *testing.T) {
+	for _, pushes := range []bool{false, true} {
+		rules := StandardRules
+		rules.KittensBoopCats = pushes
+		gs := newRulesTurn(rules, Player{Kittens: 8})
+		gs.P2 = Player{Cats: 1}
+		place(gs, P2Cat, 2, 2)
+		next := applyPlace(t, gs, P1Kitten, 2, 1)
+		if moved := next.Board[3][2] == P2Cat; moved != pushes {
+			t.Errorf("kittensBoopCats=%v: expected the cat to move %v, got board %v", pushes, pushes, next.Board)
+		}
+	}
+}
+
+func TestRuleset_MixedLines(t *testing.T) {
+	for _, mixed := range []bool{true, false} {
+		rules := StandardRules
+		rules.MixedLines = mixed
+		gs := newRulesTurn(rules, Player{Kittens: 6, Cats: 1})
+		place(gs, P1Kitten, 0, 0)
+		place(gs, P1Cat, 1, 0)
+		next := applyPlace(t, gs, P1Kitten, 2, 0)
+		if graduated := next.Board[0][1] == 0; graduated != mixed {
+			t.Errorf("mixedLines=%v: expected a kitten-cat-kitten line to graduate %v, got board %v", mixed, mixed, next.Board)
+		}
+	}
+	gs := newRulesTurn(Ruleset{BoardSize: 6, Pieces: 8, WinLength: 3, EightCatsWin: true}, Player{Kittens: 6})
+	place(gs, P1Kitten, 0, 0)
+	place(gs, P1Kitten, 1, 0)
+	if next := applyPlace(t, gs, P1Kitten, 2, 0); next.P1.Cats != 3 {
+		t.Errorf("expected three kittens to graduate without mixed lines, got %+v", next.P1)
+	}
+}
+
+func TestRuleset_WinLength(t *testing.T) {
+	rules := StandardRules
+	rules.WinLength = 4
+	gs := newRulesTurn(rules, Player{Kittens: 5, Cats: 3})
+	place(gs, P1Cat, 0, 0)
+	place(gs, P1Cat, 1, 0)
+	next := applyPlace(t, gs, P1Cat, 2, 0)
+	if next.isOver() || next.P1.Cats != 3 {
+		t.Errorf("expected three cats in a row to graduate without winning, got %s with %+v", next.Result, next.P1)
+	}
+
+	gs = newRulesTurn(rules, Player{Kittens: 4, Cats: 4})
+	for x := uint8(0); x < 3; x++ {
+		place(gs, P1Cat, x, 0)
+	}
+	if next := applyPlace(t, gs, P1Cat, 3, 0); next.Winner != 1 || next.Result != ResultLine {
+		t.Errorf("expected four cats in a row to win, got winner %d (%s)", next.Winner, next.Result)
+	}
+	if next, err := NewBitState(gs).Apply(placeAction(P1Cat, 3, 0)); err != nil || next.Winner != 1 || next.Result != ResultLine {
+		t.Errorf("expected the bitboard to agree, got winner %d (%s) %v", next.Winner, next.Result, err)
+	}
+}
+
+// --- Board sizes ---
+
+func TestRuleset_BoardSizeParse(t *testing.T) {
+	rules, err := parseRuleset(url.Values{"boardSize": {"5"}})
+	if err != nil || rules.BoardSize != 5 || rules.String() != "boardSize=5" {
+		t.Errorf("expected a 5x5 board, got %+v %q (%v)", rules, rules.String(), err)
+	}
+	for _, bad := range []url.Values{
+		{"boardSize": {"3"}},
+		{"boardSize": {"9"}},
+		{"boardSize": {"4"}, "winLength": {"5"}},
+		{"boardSize": {"4"}, "pieces": {"9"}},
+	} {
+		if _, err := parseRuleset(bad); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+
+	var saved Ruleset
+	if err := json.Unmarshal([]byte(`{"pieces":6,"winLength":3}`), &saved); err != nil || saved.BoardSize != 6 {
+		t.Errorf("expected rules saved without a board size to be 6x6, got %+v (%v)", saved, err)
+	}
+}
+
+func TestRuleset_BoardSizeEdges(t *testing.T) {
+	small := StandardRules
+	small.BoardSize = 4
+	gs := newRulesTurn(small, Player{Kittens: 8})
+	gs.P2 = Player{Kittens: 8}
+	place(gs, P2Kitten, 3, 2)
+	next := applyPlace(t, gs, P1Kitten, 2, 2)
+	if len(next.Booped) != 1 || next.P2.Kittens != 8 || next.Board[2][4] != 0 {
+		t.Errorf("expected the kitten on the edge of a 4x4 board to be booped off, got %+v", next.P2)
+	}
+	if _, _, err := Apply(next, placeAction(P2Kitten, 4, 0)); err == nil {
+		t.Error("expected a square past the edge of a 4x4 board to be refused")
+	}
+
+	large := StandardRules
+	large.BoardSize = 8
+	gs = newRulesTurn(large, Player{Kittens: 6})
+	place(gs, P1Kitten, 5, 7)
+	place(gs, P1Kitten, 6, 7)
+	if next := applyPlace(t, gs, P1Kitten, 7, 7); next.P1.Cats != 3 {
+		t.Errorf("expected a line in the far corner of an 8x8 board to graduate, got %+v", next.P1)
+	}
+}
+
+func TestRuleset_BoardSizeJSON(t *testing.T) {
+	rows := func(gs *GameState) [][]uint8 {
+		data, err := json.Marshal(gs)
+		if err != nil {
+			t.Fatalf("unexpected marshal error: %v", err)
+		}
+		var decoded struct {
+			Board [][]uint8 `json:"board"`
+		}
+		json.Unmarshal(data, &decoded)
+		var back GameState
+		if err := json.Unmarshal(data, &back); err != nil || back.Board != gs.Board || back.boardSize() != gs.boardSize() {
+			t.Errorf("expected the state to decode as it was, got %v (%v)", back.Board, err)
+		}
+		return decoded.Board
+	}
+
+	gs := NewGameState()
+	place(gs, P2Cat, 4, 4)
+	if board := rows(gs); len(board) != 6 || len(board[5]) != 6 || board[4][4] != P2Cat {
+		t.Errorf("expected a standard board to encode as 6x6, got %v", board)
+	}
+	gs.Rules = &Ruleset{BoardSize: 5, Pieces: 8, WinLength: 3, EightCatsWin: true, MixedLines: true}
+	if board := rows(gs); len(board) != 5 || len(board[0]) != 5 {
+		t.Errorf("expected a 5x5 board to encode as 5x5, got %v", board)
+	}
+}
+
+func TestRuleset_BoardSizesPlayOut(t *testing.T) {
+	for _, size := range []uint8{4, 5, 7, 8} {
+		rules := StandardRules
+		rules.BoardSize = size
+		for seed := int64(1); seed <= 5; seed++ {
+			rng := rand.New(rand.NewSource(seed))
+			state := *NewGameState()
+			state.withRules(&rules, false)
+			for n := 0; n < 400 && !state.isOver(); n++ {
+				text := FormatState(&state)
+				if parsed := mustParseState(t, text); parsed.Board != state.Board || parsed.boardSize() != int(size) {
+					t.Fatalf("expected %q to restore the %dx%d board", text, size, size)
+				}
+				legal := LegalMoves(&state)
+				if len(legal) == 0 {
+					t.Fatalf("%dx%d: no legal moves in %q", size, size, text)
+				}
+				next, _, err := Apply(state, legal[rng.Intn(len(legal))])
+				if err != nil {
+					t.Fatalf("%dx%d: unexpected error: %v", size, size, err)
+				}
+				state = next
+			}
+		}
+	}
+}
+
+func TestRuleset_BoardSizePosition(t *testing.T) {
+	if _, err := parseGameOptions(url.Values{"position": {"5/5/5/5/5 8,0 8,0 1 0 -"}}); err == nil {
+		t.Error("expected a 5x5 position on the standard board to be rejected")
+	}
+	opts, err := parseGameOptions(url.Values{"position": {"5/5/5/5/K4 7,0 8,0 2 1 -"}, "boardSize": {"5"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	game := NewGame()
+	game.applyOptions(opts)
+	if game.GameState.boardSize() != 5 || LegalMoves(game.GameState)[len(LegalMoves(game.GameState))-1].Position != (Position{X: 4, Y: 4}) {
+		t.Errorf("expected the game to be played on the 5x5 board, got %q", FormatState(game.GameState))
+	}
+}
+
+// --- Games ---
+
+func TestRuleset_GameOptions(t *testing.T) {
+	opts, err := parseGameOptions(url.Values{"pieces": {"6"}})
+	if err != nil || opts.Variant != VariantCustom || opts.Rules == nil || opts.Rules.Pieces != 6 {
+		t.Fatalf("expected a custom variant with six pieces, got %+v (%v)", opts, err)
+	}
+	for _, bad := range []url.Values{
+		{"pieces": {"6"}, "rated": {"true"}},
+		{"pieces": {"6"}, "variant": {"standard"}},
+	} {
+		if _, err := parseGameOptions(bad); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+
+	s := NewServer()
+	game := s.createGame(nil, opts, nil)
+	if game.GameState.Rules == nil || game.GameState.P1.Kittens != 6 {
+		t.Errorf("expected the game to be dealt six kittens, got %+v", game.GameState.P1)
+	}
+	if lg := game.lobbyEntry(); lg.Variant != VariantCustom || lg.Rules == nil || lg.Rules.Pieces != 6 {
+		t.Errorf("expected the lobby to show the rules, got %+v", lg)
+	}
+
+	now := time.Now()
+	standard := &queueEntry{queue: QueueCasual, opts: GameOptions{Variant: VariantStandard}, since: now}
+	custom := &queueEntry{queue: QueueCasual, opts: opts, since: now}
+	if compatible(standard, custom, now) {
+		t.Error("expected players asking for different rules not to be paired")
+	}
+	same := &queueEntry{queue: QueueCasual, opts: opts, since: now}
+	if !compatible(custom, same, now) {
+		t.Error("expected players asking for the same rules to be paired")
+	}
+}
+
+func TestRuleset_ArchivedGameKeepsRules(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newSeatedGame(s)
+	game.applyOptions(GameOptions{Variant: VariantCustom, Rules: &Ruleset{BoardSize: 6, Pieces: 5, WinLength: 4, EightCatsWin: true, MixedLines: true}})
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	playAction(t, game, placeAction(P2Kitten, 5, 5))
+	game.resign(s, "player1")
+
+	replay, err := s.loadReplay(game.ID)
+	if err != nil || replay.States[0].Rules == nil || replay.States[0].P1.Kittens != 5 {
+		t.Fatalf("expected the replay to start under the game's rules, got %+v (%v)", replay, err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleExportRecord(rec, httptest.NewRequest("GET", "/archive/export?id="+game.ID, nil))
+	text := rec.Body.String()
+	for _, want := range []string{`[Variant "custom"]`, `[Rules "pieces=5&winLength=4"]`} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected the export to contain %s, got\n%s", want, text)
+		}
+	}
+	rec = httptest.NewRecorder()
+	s.handleImportRecord(rec, httptest.NewRequest("POST", "/archive/import", strings.NewReader(text)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"pieces":5`) {
+		t.Errorf("expected the record to import under its rules, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// Squares beyond the standard board survive export and import on a larger board.
+func TestRuleset_LargeBoardRecordRoundTrips(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newSeatedGame(s)
+	game.applyOptions(GameOptions{Variant: VariantCustom, Rules: &Ruleset{BoardSize: 8, Pieces: 8, WinLength: 3, EightCatsWin: true, MixedLines: true}})
+	playAction(t, game, placeAction(P1Kitten, 7, 7))
+	playAction(t, game, placeAction(P2Kitten, 6, 0))
+	game.resign(s, "player1")
+
+	rec := httptest.NewRecorder()
+	s.handleExportRecord(rec, httptest.NewRequest("GET", "/archive/export?id="+game.ID, nil))
+	text := rec.Body.String()
+	if !strings.Contains(text, `[Rules "boardSize=8"]`) || !strings.Contains(text, "1. K h8 2. K g1 0-1") {
+		t.Fatalf("expected the export to keep the 8x8 board, got\n%s", text)
+	}
+	parsed, err := ParseRecord(text)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	steps, final, err := parsed.Replay()
+	if err != nil {
+		t.Fatalf("unexpected replay error: %v", err)
+	}
+	if len(steps) != 2 || final.Board != game.GameState.Board || final.Winner != 2 {
+		t.Errorf("expected the replay to rebuild the 8x8 game, got %d steps and winner %d", len(steps), final.Winner)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	maxSpectators   = 50
+	spectatorBuffer = 32 // per-spectator queue; a spectator that falls this far behind is dropped
+)
+
+// spectator is a read-only connection attached to a game. Each spectator has its own
+// queue and writer goroutine so a slow spectator can never stall the game's writePump.
+type spectator struct {
+	conn      *websocket.Conn
+	send      chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type SpectatorCount struct {
+	Spectators int `json:"spectators"`
+}
+
+func (sp *spectator) close() {
+	sp.closeOnce.Do(func() {
+		close(sp.done)
+	})
+}
+
+// addSpectator attaches a read-only connection to a live or waiting game.
+func (server *Server) addSpectator(conn *websocket.Conn, requestedGameID string) (*Game, *spectator, error) {
+	server.serverMutex.Lock()
+	defer server.serverMutex.Unlock()
+
+	game, exists := server.games[requestedGameID]
+	if !exists {
+		return nil, nil, fmt.Errorf("game not found")
+	}
+
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if len(game.spectators) >= maxSpectators {
+		return nil, nil, fmt.Errorf("spectator limit reached")
+	}
+	sp := &spectator{
+		conn: conn,
+		send: make(chan Message, spectatorBuffer),
+		done: make(chan struct{}),
+	}
+	game.spectators[sp] = struct{}{}
+	return game, sp, nil
+}
+
+func (game *Game) removeSpectator(sp *spectator) {
+	game.mutex.Lock()
+	_, present := game.spectators[sp]
+	delete(game.spectators, sp)
+	game.mutex.Unlock()
+	sp.close()
+
+	if present {
+		game.broadcastSpectatorCount()
+	}
+}
+
+// fanOutToSpectators queues msg for every spectator without blocking.
+// Spectators whose queue is full are disconnected.
+func (game *Game) fanOutToSpectators(msg Message) {
+	game.mutex.Lock()
+	spectators := make([]*spectator, 0, len(game.spectators))
+	for sp := range game.spectators {
+		spectators = append(spectators, sp)
+	}
+	game.mutex.Unlock()
+
+	outMsg := msg
+	outMsg.PlayerID = "spectator"
+	for _, sp := range spectators {
+		select {
+		case sp.send <- outMsg:
+		default:
+			log.Printf("Spectator in game %s too slow, disconnecting", game.ID)
+			sp.close()
+		}
+	}
+}
+
+func (game *Game) spectatorCount() int {
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	return len(game.spectators)
+}
+
+func (game *Game) broadcastSpectatorCount() {
+	msg := Message{
+		Type:    "spectators",
+		GameID:  game.ID,
+		State:   game.GameState.State,
+		Payload: SpectatorCount{Spectators: game.spectatorCount()},
+	}
+	select {
+	case game.send <- msg:
+	default:
+		log.Printf("Send channel full, dropping spectator count for game %s", game.ID)
+	}
+}
+
+// spectatorWritePump drains a spectator's queue and keeps the connection alive with pings.
+func (sp *spectator) writePump(game *Game) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in spectator writePump for game %s: %v", game.ID, r)
+		}
+	}()
+	// Closing the connection unblocks the spectator's read loop
+	defer sp.conn.Close()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-game.done:
+			return
+		case <-sp.done:
+			return
+		case msg := <-sp.send:
+			sp.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := sp.conn.WriteJSON(msg); err != nil {
+				log.Printf("Failed to write to spectator in game %s: %v", game.ID, err)
+				return
+			}
+		case <-ticker.C:
+			sp.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := sp.conn.WriteJSON(Message{Type: "ping"}); err != nil {
+				log.Printf("Failed to ping spectator in game %s: %v", game.ID, err)
+				return
+			}
+		}
+	}
+}
+
+// readPump discards everything a spectator sends except pongs; nothing reaches the game.
+func (sp *spectator) readPump(game *Game, version int) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in spectator readPump for game %s: %v", game.ID, r)
+		}
+	}()
+
+	sp.conn.SetReadDeadline(time.Now().Add(pongWait))
+	for {
+		_, data, err := sp.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if frame, err := decodeFrame(data, version); err == nil && frame.Type == framePong {
+			sp.conn.SetReadDeadline(time.Now().Add(pongWait))
+		}
+	}
+}
+
+func (s *Server) handleSpectator(conn *websocket.Conn, gameID string, version int) {
+	game, sp, err := s.addSpectator(conn, gameID)
+	if err != nil {
+		conn.WriteJSON(Message{Type: "error", Code: CodeSpectateFailed, Payload: "Could not spectate game: " + err.Error()})
+		conn.Close()
+		return
+	}
+
+	game.mutex.Lock()
+	game.GameState.Spectators = len(game.spectators)
+	game.mutex.Unlock()
+
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := conn.WriteJSON(Message{
+		Type:     "joined",
+		GameID:   game.ID,
+		PlayerID: "spectator",
+		Version:  version,
+		State:    game.GameState.State,
+		Payload:  game.GameState,
+	}); err != nil {
+		log.Printf("Error sending initial game state to spectator: %v", err)
+		game.removeSpectator(sp)
+		conn.Close()
+		return
+	}
+	game.broadcastSpectatorCount()
+	log.Printf("Spectator joined game %s", game.ID)
+
+	go sp.writePump(game)
+	sp.readPump(game, version)
+
+	game.removeSpectator(sp)
+	conn.Close()
+	log.Printf("Spectator left game %s", game.ID)
+}
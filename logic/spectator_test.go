@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestAddSpectator_UnknownGame(t *testing.T) {
+	s := NewServer()
+	if _, _, err := s.addSpectator(nil, "NOPE"); err == nil {
+		t.Error("expected error spectating a game that doesn't exist")
+	}
+}
+
+func TestAddSpectator_Limit(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	for i := 0; i < maxSpectators; i++ {
+		if _, _, err := s.addSpectator(nil, game.ID); err != nil {
+			t.Fatalf("unexpected error adding spectator %d: %v", i, err)
+		}
+	}
+	if _, _, err := s.addSpectator(nil, game.ID); err == nil {
+		t.Error("expected error once the spectator limit is reached")
+	}
+}
+
+// The broadcast payload carries the current spectator count.
+func TestBroadcast_IncludesSpectatorCount(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	s.addSpectator(nil, game.ID)
+	s.addSpectator(nil, game.ID)
+
+	game.broadcastGameState()
+
+	msg := <-game.send
+	if msg.Payload.(GameState).Spectators != 2 {
+		t.Errorf("expected 2 spectators in broadcast, got %d", msg.Payload.(GameState).Spectators)
+	}
+}
+
+// A spectator whose queue is full is dropped rather than blocking the fan-out.
+func TestFanOut_DropsSlowSpectator(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	_, slow, _ := s.addSpectator(nil, game.ID)
+	_, fast, _ := s.addSpectator(nil, game.ID)
+
+	for i := 0; i < spectatorBuffer; i++ {
+		game.fanOutToSpectators(Message{Type: "gameState"})
+		<-fast.send
+	}
+	game.fanOutToSpectators(Message{Type: "gameState"})
+
+	select {
+	case <-slow.done:
+	default:
+		t.Error("expected slow spectator to be disconnected")
+	}
+	select {
+	case <-fast.done:
+		t.Error("expected spectator that keeps up to stay connected")
+	default:
+	}
+	if msg := <-fast.send; msg.PlayerID != "spectator" {
+		t.Errorf("expected spectator messages to be addressed to spectator, got %q", msg.PlayerID)
+	}
+}
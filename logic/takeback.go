@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// TakebackNotice is the payload of takebackRequested / takebackDeclined.
+type TakebackNotice struct {
+	Seat string `json:"seat"` // seat that asked for the takeback
+}
+
+// recordHistory pushes the current state before an action replaces it. Caller must hold game.mutex.
+// Apply never writes through GameState's slices, so a value copy is a full snapshot.
+func (game *Game) recordHistory() {
+	game.history = append(game.history, *game.GameState)
+}
+
+// replayHistory rebuilds the history of a restored game by replaying its move list from the
+// start position, so a takeback can reach back past a restart. gs is the persisted state,
+// which supplies the first mover and rules.
+func replayHistory(position string, gs *GameState, actions []ArchivedAction) ([]GameState, error) {
+	start, err := startState(position, gs.firstMover(), gs.Rules)
+	if err != nil {
+		return nil, err
+	}
+	state := *start
+	history := make([]GameState, 0, len(actions))
+	for _, a := range actions {
+		history = append(history, state)
+		next, _, err := Apply(state, a.Action)
+		if err != nil {
+			return nil, fmt.Errorf("action %d: %v", a.Seq, err)
+		}
+		state = next
+	}
+	return history, nil
+}
+
+// takebackPoint finds the history entry where seat's last completed turn began: the latest
+// WAITING state with seat to move and an earlier turn number than now. Rolling back to it also
+// undoes the opponent's reply and any selection still pending. Returns -1 if there is none.
+func (game *Game) takebackPoint(seat string) int {
+	for i := len(game.history) - 1; i >= 0; i-- {
+		past := &game.history[i]
+		if past.State != "WAITING" || past.TurnNumber >= game.GameState.TurnNumber {
+			continue
+		}
+		if seatNumber(seat) == moverNumber(past) {
+			return i
+		}
+	}
+	return -1
+}
+
+// requestTakeback records seat's request and asks the other seat. A bot opponent accepts straight away.
+func (game *Game) requestTakeback(s *Server, seat string) error {
+	game.mutex.Lock()
+	if game.GameState.isOver() {
+		game.mutex.Unlock()
+		return fmt.Errorf("game is over")
+	}
+	if game.takebackPoint(seat) < 0 {
+		game.mutex.Unlock()
+		return fmt.Errorf("no move to take back")
+	}
+	if game.takebackFrom != "" {
+		game.mutex.Unlock()
+		return fmt.Errorf("takeback already requested")
+	}
+	game.takebackFrom = seat
+	game.mutex.Unlock()
+
+	log.Printf("Player %s requested a takeback in game %s", seat, game.ID)
+	if game.bot != nil {
+		return game.answerTakeback(s, game.bot.Seat, true)
+	}
+	game.sendTakebackNotice("takebackRequested", otherSeat(seat), seat)
+	return nil
+}
+
+// answerTakeback resolves a pending request. seat must be the opponent of the requester.
+func (game *Game) answerTakeback(s *Server, seat string, accept bool) error {
+	game.mutex.Lock()
+	requester := game.takebackFrom
+	if requester == "" || requester == seat {
+		game.mutex.Unlock()
+		return fmt.Errorf("no takeback to answer")
+	}
+	game.takebackFrom = ""
+
+	if !accept {
+		game.mutex.Unlock()
+		game.sendTakebackNotice("takebackDeclined", requester, requester)
+		return nil
+	}
+
+	point := game.takebackPoint(requester)
+	if point < 0 {
+		game.mutex.Unlock()
+		return fmt.Errorf("no move to take back")
+	}
+	game.rollback(point)
+	turn := game.GameState.TurnNumber
+	game.mutex.Unlock()
+
+	log.Printf("Takeback accepted in game %s, back to turn %d", game.ID, turn)
+	game.commitTurn(s)
+	return nil
+}
+
+// rollback restores history[point] and drops everything after it. Caller must hold game.mutex.
+// The move that led into the restored state is cleared so clients don't animate it again.
+func (game *Game) rollback(point int) {
+	restored := game.history[point]
+	game.dropActions(len(game.history) - point)
+	game.history = game.history[:point]
+
+	restored.BroadcastSeq = game.GameState.BroadcastSeq
+	restored.Placed = Move{}
+	restored.BoopMovement = nil
+	restored.Booped = nil
+	restored.Lines = nil
+	restored.GraduatedLine = nil
+	*game.GameState = restored
+}
+
+// sendTakebackNotice sends msgType privately to seat to; the payload names the requester.
+func (game *Game) sendTakebackNotice(msgType string, to string, requester string) {
+	msg := Message{
+		Type:     msgType,
+		GameID:   game.ID,
+		PlayerID: to,
+		State:    game.GameState.State,
+		Payload:  TakebackNotice{Seat: requester},
+		To:       to,
+	}
+	select {
+	case game.send <- msg:
+	default:
+		log.Printf("Send channel full, dropping %s for game %s", msgType, game.ID)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import "testing"
+
+// --- Helpers ---
+
+func playAction(t *testing.T, game *Game, action Action) {
+	t.Helper()
+	game.mutex.Lock()
+	defer game.mutex.Unlock()
+	if _, err := game.applyAction(action); err != nil {
+		t.Fatalf("unexpected error applying %+v: %v", action, err)
+	}
+}
+
+func placeAction(tile uint8, x, y uint8) Action {
+	return Action{Type: ActionPlace, Position: Position{X: x, Y: y}, Piece: tile}
+}
+
+// --- Takebacks ---
+
+func TestTakeback_AcceptedRollsBackRequestersLastTurn(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	playAction(t, game, placeAction(P2Kitten, 5, 5))
+
+	if err := game.requestTakeback(s, "player1"); err != nil {
+		t.Fatalf("unexpected error requesting takeback: %v", err)
+	}
+	if err := game.answerTakeback(s, "player2", true); err != nil {
+		t.Fatalf("unexpected error accepting takeback: %v", err)
+	}
+
+	gs := game.GameState
+	if gs.TurnNumber != 0 || !gs.isPlayer1() {
+		t.Errorf("expected P1 to move on turn 0, got turn %d", gs.TurnNumber)
+	}
+	if gs.Board[0][0] != 0 || gs.Board[5][5] != 0 {
+		t.Error("expected both placements to be undone")
+	}
+	if gs.P1.Kittens != 8 || gs.P2.Kittens != 8 {
+		t.Errorf("expected full pools, got P1 %d P2 %d kittens", gs.P1.Kittens, gs.P2.Kittens)
+	}
+	if gs.Placed.Piece != 0 {
+		t.Error("expected the restored state to carry no placement to animate")
+	}
+	if len(game.history) != 0 {
+		t.Errorf("expected history to be truncated, got %d entries", len(game.history))
+	}
+}
+
+func TestTakeback_UndoesPendingSelectionStep(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	place(game.GameState, P1Kitten, 0, 2)
+	place(game.GameState, P1Kitten, 1, 2)
+	place(game.GameState, P1Kitten, 2, 0)
+	place(game.GameState, P1Kitten, 2, 1)
+	before := *game.GameState
+
+	playAction(t, game, placeAction(P1Kitten, 2, 2))
+	if game.GameState.State != "MULTIPLE_WAITING" {
+		t.Fatalf("expected MULTIPLE_WAITING, got %s", game.GameState.State)
+	}
+	playAction(t, game, Action{Type: ActionGraduateLine, Position: Position{X: 1, Y: 2}})
+
+	game.requestTakeback(s, "player1")
+	if err := game.answerTakeback(s, "player2", true); err != nil {
+		t.Fatalf("unexpected error accepting takeback: %v", err)
+	}
+	if game.GameState.Board != before.Board || game.GameState.P1 != before.P1 {
+		t.Error("expected the placement and its graduation to be undone together")
+	}
+	if game.GameState.State != "WAITING" || game.GameState.TurnNumber != 0 {
+		t.Errorf("expected WAITING on turn 0, got %s on turn %d", game.GameState.State, game.GameState.TurnNumber)
+	}
+}
+
+func TestTakeback_NothingToTakeBack(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+
+	if err := game.requestTakeback(s, "player2"); err == nil {
+		t.Error("expected an error when player2 has not completed a turn")
+	}
+	if game.takebackFrom != "" {
+		t.Error("expected no pending request after a rejected one")
+	}
+}
+
+func TestTakeback_DeclinedKeepsState(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+
+	game.requestTakeback(s, "player1")
+	if err := game.answerTakeback(s, "player1", true); err == nil {
+		t.Error("expected the requester to be unable to answer their own request")
+	}
+	if err := game.answerTakeback(s, "player2", false); err != nil {
+		t.Fatalf("unexpected error declining takeback: %v", err)
+	}
+	if game.GameState.Board[0][0] != P1Kitten || game.GameState.TurnNumber != 1 {
+		t.Error("expected state to be unchanged after a decline")
+	}
+	if game.takebackFrom != "" {
+		t.Error("expected the request to be cleared after a decline")
+	}
+}
+
+func TestTakeback_MoveCancelsPendingRequest(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+
+	game.requestTakeback(s, "player1")
+	playAction(t, game, placeAction(P2Kitten, 5, 5))
+	if err := game.answerTakeback(s, "player2", true); err == nil {
+		t.Error("expected the request to lapse once player2 moved")
+	}
+}
+
+func TestTakeback_BotAcceptsImmediately(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	game.bot = newBot(BotRandom, "player2")
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	playAction(t, game, placeAction(P2Kitten, 5, 5))
+
+	if err := game.requestTakeback(s, "player1"); err != nil {
+		t.Fatalf("unexpected error requesting takeback: %v", err)
+	}
+	if game.GameState.TurnNumber != 0 || game.GameState.Board[0][0] != 0 {
+		t.Error("expected the bot to accept the takeback straight away")
+	}
+}
+
+func TestTakeback_PromptsOnlyTheOpponent(t *testing.T) {
+	s := NewServer()
+	game := newSeatedGame(s)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+
+	game.requestTakeback(s, "player1")
+	msg := <-game.send
+	if msg.Type != "takebackRequested" || msg.To != "player2" {
+		t.Fatalf("expected takebackRequested addressed to player2, got %+v", msg)
+	}
+
+	game.answerTakeback(s, "player2", false)
+	msg = <-game.send
+	if msg.Type != "takebackDeclined" || msg.To != "player1" {
+		t.Errorf("expected takebackDeclined addressed to player1, got %+v", msg)
+	}
+}
+
+// The history is rebuilt from the saved move list, so a takeback works after a restart.
+func TestTakeback_AfterRestart(t *testing.T) {
+	s := newTestServerWithDB(t)
+	game := newSeatedGame(s)
+	s.saveNewGame(game)
+	playAction(t, game, placeAction(P1Kitten, 0, 0))
+	playAction(t, game, placeAction(P2Kitten, 5, 5))
+	playAction(t, game, placeAction(P1Kitten, 2, 2))
+	s.saveGame(game)
+	want := game.history[1]
+
+	restarted := NewServer()
+	restarted.db = s.db
+	if err := restarted.restoreGames(); err != nil {
+		t.Fatalf("restoreGames: %v", err)
+	}
+	restored := restarted.games[game.ID]
+	if restored == nil {
+		t.Fatal("expected the game to be restored")
+	}
+	defer restored.shutdown()
+	if len(restored.history) != 3 {
+		t.Fatalf("expected a history entry per saved action, got %d", len(restored.history))
+	}
+
+	if err := restored.requestTakeback(restarted, "player2"); err != nil {
+		t.Fatalf("unexpected error requesting takeback: %v", err)
+	}
+	if err := restored.answerTakeback(restarted, "player1", true); err != nil {
+		t.Fatalf("unexpected error accepting takeback: %v", err)
+	}
+	gs := restored.GameState
+	if gs.TurnNumber != 1 || gs.Board != want.Board || gs.P1 != want.P1 || gs.P2 != want.P2 {
+		t.Errorf("expected player2's move to be taken back, got turn %d", gs.TurnNumber)
+	}
+	if moves, _ := restarted.loadActions(game.ID); len(moves) != 1 {
+		t.Errorf("expected one saved action left, got %d", len(moves))
+	}
+}
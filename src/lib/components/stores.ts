@@ -12,9 +12,19 @@ export type GameState = {
 	p1: Player;
 	p2: Player;
 	winner: number;
+	result?: string;
+	firstMover?: number;
 	placed: NewMove;
 	boopMovement: BoopMovement[];
 	booped: Booped[];
+	legalMoves?: Action[];
+};
+
+// Action the player to move may take; `piece` is the tile for placements
+export type Action = {
+	type: "place" | "graduateLine" | "graduatePiece";
+	position: Position;
+	piece?: number;
 };
 
 type Position = {
@@ -45,6 +55,9 @@ export type ServerMessage = {
 	gameID: string;
 	playerID: string;
 	state: string;
+	code?: string;
+	version?: number;
+	invite?: { code: string; url: string };
 	payload: GameState | any;
 };
 
@@ -54,6 +67,11 @@ export type Player = {
 	placed: number;
 };
 
+// Player 1 acts on even turns when they moved first (firstMover 1, or missing from older servers), odd otherwise
+export function isP1Turn(turnNumber: number, firstMover?: number): boolean {
+	return (turnNumber % 2 === 0) === ((firstMover ?? 1) === 1);
+}
+
 export let gameState: Writable<GameState> = writable(newGameState());
 // const gs: GameState = {};
 //
@@ -120,7 +138,18 @@ export let waitingForOpponent = writable(false);
 export let onlineGameID = writable("");
 export let pieceChoice = writable(0);
 export let inGame = writable(false);
-export let waitingGameIDs = writable("");
+export type LobbyGame = {
+	id: string;
+	creator: string;
+	createdAt: string;
+	timeControl?: { initialMs: number; incrementMs: number; perMoveMs?: number };
+	variant: string;
+	rated: boolean;
+	first: string;
+	passphrase: boolean;
+};
+
+export let lobbyGames: Writable<LobbyGame[]> = writable([]);
 export let lastClickPos = writable({ x: 0, y: 0 });
 export let noPiecesMsg = writable("");
 